@@ -0,0 +1,233 @@
+package deje
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/util"
+)
+
+// Vote for an Event with sc.AcceptorKey, and publish the Vote. Fails,
+// without publishing anything, unless the key is one of the document's
+// acceptors (see document.Vote).
+//
+// The hash may be abbreviated, as long as the Event is known (see
+// GetEvent).
+func (sc *SimpleClient) Vote(hash string) error {
+	if sc.IsClosed() {
+		return ErrClosed
+	}
+	if sc.AcceptorKey == nil {
+		return errors.New("SimpleClient has no AcceptorKey")
+	}
+
+	sc.lock()
+	hash, err := sc.expandHash(hash)
+	if err != nil {
+		sc.unlock()
+		return err
+	}
+	vote := document.NewVote(hash, sc.AcceptorKey)
+	if err := sc.addVote(vote); err != nil {
+		sc.unlock()
+		return err
+	}
+	sc.vote = hash
+	sc.unlock()
+	return sc.Publish(votesMessage(vote))
+}
+
+// Whether enough of the document's acceptors have voted for an Event,
+// whose hash may be abbreviated (see GetEvent). See
+// document.Document.HasQuorum.
+func (sc *SimpleClient) HasQuorum(hash string) bool {
+	sc.lock()
+	defer sc.unlock()
+	return sc.hasQuorum(hash)
+}
+
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) hasQuorum(hash string) bool {
+	expanded, err := sc.expandHash(hash)
+	return err == nil && sc.GetDoc().HasQuorum(expanded)
+}
+
+// Publish every Vote we know of.
+func (sc *SimpleClient) PublishVotes() error {
+	sc.lock()
+	message := votesMessage(sc.getVotes()...)
+	sc.unlock()
+	return sc.Publish(message)
+}
+
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) getVotes() []document.Vote {
+	var votes []document.Vote
+	for _, by_acceptor := range sc.GetDoc().Votes {
+		for _, vote := range by_acceptor {
+			votes = append(votes, vote)
+		}
+	}
+	return votes
+}
+
+func votesMessage(votes ...document.Vote) map[string]interface{} {
+	if votes == nil {
+		votes = []document.Vote{}
+	}
+	return map[string]interface{}{
+		"type":  "02-publish-votes",
+		"votes": votes,
+	}
+}
+
+// Store a Vote, and reanalyze the tip, since votes can change it.
+//
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) addVote(vote document.Vote) error {
+	doc := sc.GetDoc()
+	if err := doc.AddVote(vote); err != nil {
+		return err
+	}
+	sc.dirty = true
+	if len(doc.Timestamps) > 0 {
+		sc.reTip()
+	}
+	return nil
+}
+
+// How many votes a SimpleClient holds on to until it can check them.
+// Past this, the oldest are dropped.
+const heldVotesLimit = 1024
+
+// Votes can arrive before the Events they're for, or the genesis (peers
+// publish them right after their timestamps), and until then, there's
+// no telling who the acceptors are. So they're held for releaseVotes,
+// as long as they're properly signed, and the document may yet turn out
+// to have a genesis.
+//
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) rcvVotes(parent map[string]interface{}) error {
+	votes, ok := parent["votes"].([]interface{})
+	if !ok {
+		return errors.New("Message with bad 'votes' param")
+	}
+	for _, serial_vote := range votes {
+		var vote document.Vote
+		if err := util.CloneMarshal(serial_vote, &vote); err != nil {
+			return err
+		}
+		if err := vote.Verify(); err != nil {
+			sc.Log(err)
+			sc.notify(Notification{Kind: NotifyError, Err: err})
+			continue
+		}
+
+		switch {
+		case sc.canCheckVote(vote):
+			sc.tryVote(vote)
+		case sc.lacksGenesis():
+			sc.Log("Ignoring vote, the document has no genesis")
+		default:
+			sc.heldVotes = append(sc.heldVotes, vote)
+			if len(sc.heldVotes) > heldVotesLimit {
+				sc.heldVotes = sc.heldVotes[1:]
+			}
+		}
+	}
+	return nil
+}
+
+// Add the votes held by rcvVotes that can be checked now, or drop them
+// all, if it turns out the document has no genesis.
+//
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) releaseVotes() {
+	if sc.lacksGenesis() {
+		sc.heldVotes = nil
+		return
+	}
+	var still_held []document.Vote
+	for _, vote := range sc.heldVotes {
+		if sc.canCheckVote(vote) {
+			sc.tryVote(vote)
+		} else {
+			still_held = append(still_held, vote)
+		}
+	}
+	sc.heldVotes = still_held
+}
+
+// Whether we know who the acceptors in charge of a Vote's Event are.
+//
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) canCheckVote(vote document.Vote) bool {
+	doc := sc.GetDoc()
+	if _, err := doc.Genesis(); err != nil {
+		return false
+	}
+	_, ok := doc.Acceptors(vote.Event)
+	return ok
+}
+
+// Whether the document has no genesis, and isn't getting one: the
+// timestamps are in, we have all of their history, and none turned up.
+//
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) lacksGenesis() bool {
+	return sc.GetDoc().GenesisHash == "" && len(sc.geneses) == 0 &&
+		sc.timestampsReceived > 0 && sc.hasHistory()
+}
+
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) tryVote(vote document.Vote) {
+	// Not fatal, the vote just doesn't count
+	if err := sc.addVote(vote); err != nil {
+		sc.Log(err)
+		sc.notify(Notification{Kind: NotifyError, Err: err})
+	}
+}
+
+// If we're one of the acceptors in charge of the tip, vote for it,
+// unless we already have. Once we've voted for an Event, we only vote
+// for its descendants, so we never back competing forks. The Vote is
+// published once sc.mutex is released. Returns whether we voted, in
+// which case the tip has already been reanalyzed.
+//
+// Must be called with sc.mutex held, after sc.Tip is updated.
+func (sc *SimpleClient) voteForTip() bool {
+	doc := sc.GetDoc()
+	if sc.AcceptorKey == nil || sc.Tip == nil {
+		return false
+	}
+	hash := sc.Tip.Hash()
+	acceptor := hex.EncodeToString(sc.AcceptorKey.Public().(ed25519.PublicKey))
+	if !doc.IsAcceptor(hash, acceptor) {
+		return false
+	}
+	if _, voted := doc.Votes[hash][acceptor]; voted {
+		return false
+	}
+	if sc.vote != "" {
+		previous, ok := doc.Events[sc.vote]
+		if !ok {
+			return false
+		}
+		if ancestor, err := sc.Tip.GetCommonAncestor(previous); err != nil || ancestor != previous {
+			return false
+		}
+	}
+
+	// Our own vote can't be bad
+	vote := document.NewVote(hash, sc.AcceptorKey)
+	sc.addVote(vote)
+	sc.vote = hash
+	sc.pending = append(sc.pending, func() {
+		if err := sc.Publish(votesMessage(vote)); err != nil {
+			sc.Log(err)
+		}
+	})
+	return true
+}
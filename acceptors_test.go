@@ -0,0 +1,499 @@
+package deje
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// Deterministic keys, so tests don't depend on randomness.
+func acceptorKeys(n int) ([]ed25519.PrivateKey, []interface{}) {
+	keys := make([]ed25519.PrivateKey, n)
+	ids := make([]interface{}, n)
+	for i := range keys {
+		seed := bytes.Repeat([]byte{byte(i + 1)}, ed25519.SeedSize)
+		keys[i] = ed25519.NewKeyFromSeed(seed)
+		ids[i] = hex.EncodeToString(keys[i].Public().(ed25519.PublicKey))
+	}
+	return keys, ids
+}
+
+func TestSimpleClient_Acceptors(t *testing.T) {
+	topic := "deje://loopback/acceptors"
+	keys, ids := acceptorKeys(3)
+	hub := NewLoopbackHub()
+
+	writer := NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+	acceptors := make([]*SimpleClient, len(keys))
+	for i, key := range keys {
+		acceptors[i] = NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+		acceptors[i].AcceptorKey = key
+	}
+	for _, sc := range append([]*SimpleClient{writer}, acceptors...) {
+		if err := sc.Connect(""); err != nil {
+			t.Fatal(err)
+		}
+		defer sc.Close()
+	}
+	// Let everyone's (empty) answers to each other's requests for
+	// timestamps die down, so they don't clobber the new ones
+	<-time.After(timeout)
+
+	genesis, err := writer.CreateGenesis(creatorKey, map[string]interface{}{
+		"acceptors": ids,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := writer.Set([]interface{}{"hello"}, "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every acceptor votes for each tip it finds, and publishes its votes
+	ctx, cancel := context.WithTimeout(context.Background(), 20*timeout)
+	defer cancel()
+	for _, sc := range append([]*SimpleClient{writer}, acceptors...) {
+		if err := sc.WaitForQuorum(ctx, event.Hash()); err != nil {
+			t.Fatal(err)
+		}
+		if err := sc.WaitForTip(ctx, event.Hash()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writer.WithDocument(func(doc *document.Document) {
+		assert.Equal(t, 2, doc.Quorum(genesis.Hash()))
+		assert.True(t, doc.VoteCount(genesis.Hash()) >= 2)
+	})
+
+	// Latecomers get votes along with timestamps
+	late := NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+	defer late.Close()
+	if err := late.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := late.WaitForTip(ctx, event.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if err := late.WaitForQuorum(ctx, event.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	late.WithDocument(func(doc *document.Document) {
+		assert.Equal(t, 2, doc.Quorum(genesis.Hash()))
+		assert.True(t, doc.VoteCount(event.Hash()) >= 2)
+	})
+	assert.Equal(t, map[string]interface{}{"hello": "world", "acceptors": ids}, late.Export())
+}
+
+func TestSimpleClient_Acceptors_ForkWithVotesWins(t *testing.T) {
+	topic := "deje://loopback/acceptors-fork"
+	keys, ids := acceptorKeys(3)
+	source := document.NewDocument()
+	genesis, err := source.CreateGenesis(document.Genesis{
+		Topic:       topic,
+		Permissions: map[string]interface{}{"acceptors": ids},
+	}, creatorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forks := make([]document.Event, 2)
+	for i := range forks {
+		forks[i] = source.NewEvent("SET")
+		forks[i].Arguments["path"] = []interface{}{"fork"}
+		forks[i].Arguments["value"] = float64(i)
+		forks[i].SetParent(*genesis)
+		forks[i].Register()
+	}
+
+	sc := NewSimpleClientWithTransport(topic, NewLoopbackHub().NewTransport(), nil)
+	sub := sc.Subscribe(NotifyError, 10)
+	defer sub.Close()
+	if err := receiveEvents(sc, *genesis, forks[0], forks[1]); err != nil {
+		t.Fatal(err)
+	}
+	err = sc.onRcv(map[string]interface{}{
+		"type":       "02-publish-timestamps",
+		"timestamps": []interface{}{forks[0].Hash(), forks[1].Hash()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, forks[0].Hash(), sc.GetTip().Hash(), "First fork wins without votes")
+
+	rcvVotes := func(votes ...document.Vote) error {
+		serialized := make([]interface{}, len(votes))
+		for i, vote := range votes {
+			serialized[i] = map[string]interface{}{
+				"event":     vote.Event,
+				"acceptor":  vote.Acceptor,
+				"signature": vote.Signature,
+			}
+		}
+		return sc.onRcv(map[string]interface{}{
+			"type":  "02-publish-votes",
+			"votes": serialized,
+		})
+	}
+	forged := document.NewVote(forks[1].Hash(), keys[0])
+	forged.Event = forks[0].Hash()
+	assert.NoError(t, rcvVotes(
+		document.NewVote(forks[1].Hash(), keys[0]),
+		forged,
+		document.NewVote(forks[1].Hash(), keys[1]),
+	))
+	assert.EqualError(t, (<-sub.C).Err, "Bad vote signature")
+	assert.Equal(t, forks[1].Hash(), sc.GetTip().Hash(), "Fork with more votes wins")
+	assert.True(t, sc.HasQuorum(forks[1].Hash()))
+	assert.False(t, sc.HasQuorum(forks[0].Hash()))
+	assert.Equal(t, map[string]interface{}{"fork": float64(1), "acceptors": ids}, sc.Export())
+
+	// A vote for the other fork isn't enough to swing it back
+	assert.NoError(t, rcvVotes(document.NewVote(forks[0].Hash(), keys[2])))
+	assert.Equal(t, forks[1].Hash(), sc.GetTip().Hash())
+
+	assert.EqualError(t, sc.onRcv(map[string]interface{}{
+		"type":  "02-publish-votes",
+		"votes": "nope",
+	}), "Message with bad 'votes' param")
+	assert.Error(t, sc.onRcv(map[string]interface{}{
+		"type":  "02-publish-votes",
+		"votes": []interface{}{"nope"},
+	}))
+	assert.Equal(t, 0, len(sub.C))
+}
+
+func TestSimpleClient_Vote(t *testing.T) {
+	topic := "deje://loopback/vote"
+	keys, _ := acceptorKeys(1)
+	hub := NewLoopbackHub()
+	types := listenLoopback(t, hub, topic)
+	sc := NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	expectTypes(t, types, "02-request-timestamps")
+
+	assert.EqualError(t, sc.Vote("some hash"), "SimpleClient has no AcceptorKey")
+
+	// Not an acceptor, so the vote isn't kept, or published
+	sc.AcceptorKey = keys[0]
+	assert.EqualError(t, sc.Vote("some hash"),
+		"Not an acceptor: '"+hex.EncodeToString(keys[0].Public().(ed25519.PublicKey))+"'")
+	assert.True(t, sc.HasQuorum("some hash"), "No acceptors, no requirements")
+	sc.WithDocument(func(doc *document.Document) {
+		assert.Len(t, doc.Votes, 0)
+	})
+
+	assert.NoError(t, sc.PublishVotes())
+	expectTypes(t, types, "02-publish-votes")
+
+	assert.NoError(t, sc.Close())
+	assert.Equal(t, ErrClosed, sc.Vote("some hash"))
+}
+
+func TestSimpleClient_VoteForTip(t *testing.T) {
+	keys, ids := acceptorKeys(2)
+	permissions := map[string]interface{}{"acceptors": ids[:1]}
+	tests := []struct {
+		Desc    string
+		Key     ed25519.PrivateKey
+		Genesis bool
+		Voted   bool
+	}{
+		{"No AcceptorKey", nil, true, false},
+		{"No tip", keys[0], false, false},
+		{"Not an acceptor", keys[1], true, false},
+		{"Acceptor", keys[0], true, true},
+	}
+	for _, test := range tests {
+		sc := NewSimpleClientWithTransport("deje://loopback/vote-for-tip", NewLoopbackHub().NewTransport(), nil)
+		sc.AcceptorKey = test.Key
+		if test.Genesis {
+			// Finding the genesis as the tip votes for it
+			if _, err := sc.CreateGenesis(creatorKey, permissions); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sc.WithDocument(func(doc *document.Document) {
+			votes := 0
+			if test.Voted {
+				votes = 1
+			}
+			assert.Len(t, doc.Votes, votes, test.Desc)
+		})
+
+		// Never twice for the same tip
+		sc.lock()
+		assert.False(t, sc.voteForTip(), test.Desc)
+		sc.unlock()
+	}
+
+	// Publishing happens after the vote is kept, so failures are only
+	// logged
+	buffer := new(syncBuffer)
+	logger := log.New(buffer, "vote_test: ", 0)
+	sc := NewSimpleClientWithTransport("deje://loopback/vote-for-tip", NewLoopbackHub().NewTransport(), logger)
+	if _, err := sc.CreateGenesis(creatorKey, permissions); err != nil {
+		t.Fatal(err)
+	}
+	sc.AcceptorKey = keys[0]
+	sc.Close()
+	sc.lock()
+	assert.True(t, sc.voteForTip())
+	sc.unlock()
+	assert.Equal(t, "vote_test: "+ErrClosed.Error()+"\n", buffer.String())
+	assert.True(t, sc.HasQuorum(sc.GetTip().Hash()))
+}
+
+// An acceptor sticks with the branch it voted for.
+func TestSimpleClient_VoteForTip_Forks(t *testing.T) {
+	keys, ids := acceptorKeys(1)
+	sc := NewSimpleClientWithTransport("deje://loopback/vote-forks", NewLoopbackHub().NewTransport(), nil)
+	sc.AcceptorKey = keys[0]
+	genesis, err := sc.CreateGenesis(creatorKey, map[string]interface{}{"acceptors": ids})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := func(parent *document.Event, value string) *document.Event {
+		ev := sc.GetDoc().NewEvent("SET")
+		ev.SetParent(*parent)
+		ev.Arguments["path"] = []interface{}{"key"}
+		ev.Arguments["value"] = value
+		register(sc, &ev)
+		return &ev
+	}
+	chosen := child(genesis, "chosen")
+	fork := child(genesis, "fork")
+	descendant := child(chosen, "descendant")
+
+	tests := []struct {
+		Desc  string
+		Tip   *document.Event
+		Voted bool
+	}{
+		{"Descends from the genesis", chosen, true},
+		{"Competes with the last vote", fork, false},
+		{"Goes back on the last vote", genesis, false},
+		{"Descends from the last vote", descendant, true},
+	}
+	for _, test := range tests {
+		sc.lock()
+		sc.Tip = test.Tip
+		assert.Equal(t, test.Voted, sc.voteForTip(), test.Desc)
+		sc.unlock()
+	}
+
+	// Can't tell, if the last vote isn't around any more
+	sc.lock()
+	sc.vote = "missing"
+	sc.Tip = fork
+	assert.False(t, sc.voteForTip())
+	sc.unlock()
+
+	// Voting by hand moves us to another branch
+	assert.NoError(t, sc.Vote(fork.Hash()))
+	after_fork := child(fork, "after fork")
+	sc.lock()
+	sc.Tip = after_fork
+	assert.True(t, sc.voteForTip())
+	sc.unlock()
+}
+
+func TestSimpleClient_HeldVotes(t *testing.T) {
+	topic := "deje://loopback/held-votes"
+	keys, ids := acceptorKeys(2)
+	genesis := newGenesis(t, document.Genesis{
+		Topic:       topic,
+		Permissions: map[string]interface{}{"acceptors": ids[:1]},
+	}, creatorKey)
+	sc := NewSimpleClientWithTransport(topic, NewLoopbackHub().NewTransport(), nil)
+	sub := sc.Subscribe(NotifyError, 10)
+	defer sub.Close()
+
+	child := document.NewEvent("SET")
+	child.SetParent(genesis)
+	child.Arguments["path"] = []interface{}{"key"}
+	child.Arguments["value"] = "value"
+
+	// Peers publish votes right after timestamps, which can be before
+	// we know the genesis, and so the acceptors
+	votes := []interface{}{}
+	for _, vote := range []document.Vote{
+		document.NewVote(genesis.Hash(), keys[0]),
+		document.NewVote(genesis.Hash(), keys[1]),
+		document.NewVote(child.Hash(), keys[0]),
+	} {
+		var serialized interface{}
+		if err := util.CloneMarshal(vote, &serialized); err != nil {
+			t.Fatal(err)
+		}
+		votes = append(votes, serialized)
+	}
+	assert.NoError(t, sc.onRcv(map[string]interface{}{
+		"type":  "02-publish-votes",
+		"votes": votes,
+	}))
+	assert.Equal(t, 0, len(sub.C))
+	sc.WithDocument(func(doc *document.Document) {
+		assert.Len(t, doc.Votes, 0)
+	})
+
+	if err := receiveEvents(sc, genesis); err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualError(t, (<-sub.C).Err, "Not an acceptor: '"+ids[1].(string)+"'")
+	sc.WithDocument(func(doc *document.Document) {
+		assert.Equal(t, 1, doc.VoteCount(genesis.Hash()))
+	})
+
+	// Votes for Events that haven't turned up are held until they do
+	sc.lock()
+	assert.Len(t, sc.heldVotes, 1)
+	sc.unlock()
+	if err := receiveEvents(sc, child); err != nil {
+		t.Fatal(err)
+	}
+	sc.WithDocument(func(doc *document.Document) {
+		assert.Equal(t, 1, doc.VoteCount(child.Hash()))
+	})
+	sc.lock()
+	assert.Len(t, sc.heldVotes, 0)
+	sc.unlock()
+}
+
+func TestSimpleClient_HeldVotes_Limits(t *testing.T) {
+	keys, _ := acceptorKeys(1)
+	sc := NewSimpleClientWithTransport("deje://loopback/held-limits", NewLoopbackHub().NewTransport(), nil)
+	sub := sc.Subscribe(NotifyError, 10)
+	defer sub.Close()
+	rcvVotes := func(votes ...document.Vote) {
+		serialized := make([]interface{}, len(votes))
+		for i, vote := range votes {
+			if err := util.CloneMarshal(vote, &serialized[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assert.NoError(t, sc.onRcv(map[string]interface{}{
+			"type":  "02-publish-votes",
+			"votes": serialized,
+		}))
+	}
+	heldVotes := func() []document.Vote {
+		sc.lock()
+		defer sc.unlock()
+		return sc.heldVotes
+	}
+
+	// Badly signed votes aren't held
+	forged := document.NewVote("some hash", keys[0])
+	forged.Event = "other hash"
+	rcvVotes(forged)
+	assert.EqualError(t, (<-sub.C).Err, "Bad vote signature")
+	assert.Len(t, heldVotes(), 0)
+
+	// Past the limit, the oldest are dropped
+	votes := make([]document.Vote, heldVotesLimit+1)
+	for i := range votes {
+		votes[i] = document.NewVote(fmt.Sprintf("hash %d", i), keys[0])
+	}
+	rcvVotes(votes...)
+	if held := heldVotes(); assert.Len(t, held, heldVotesLimit) {
+		assert.Equal(t, votes[1], held[0])
+	}
+
+	// Once it's clear there's no genesis, they're all dropped
+	assert.NoError(t, sc.onRcv(map[string]interface{}{
+		"type":       "02-publish-timestamps",
+		"timestamps": []interface{}{},
+	}))
+	assert.Len(t, heldVotes(), 0)
+	rcvVotes(votes[0])
+	assert.Len(t, heldVotes(), 0, "No genesis, no need to hold votes")
+	assert.Equal(t, 0, len(sub.C))
+}
+
+func TestSimpleClient_PublishVotes_Empty(t *testing.T) {
+	topic := "deje://loopback/no-votes"
+	hub := NewLoopbackHub()
+	lt := hub.NewTransport()
+	if err := lt.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan interface{}, 10)
+	lt.Subscribe(topic, func(topic string, event interface{}) {
+		messages <- event
+	})
+
+	sc := NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	<-messages
+	assert.NoError(t, sc.PublishVotes())
+	assert.Equal(t, map[string]interface{}{
+		"type":  "02-publish-votes",
+		"votes": []interface{}{},
+	}, <-messages)
+}
+
+func TestSimpleClient_AbbreviatedHashes(t *testing.T) {
+	keys, ids := acceptorKeys(1)
+	sc := NewSimpleClientWithTransport("deje://loopback/abbreviated", NewLoopbackHub().NewTransport(), nil)
+	genesis, err := sc.CreateGenesis(creatorKey, map[string]interface{}{
+		"acceptors": ids,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := sc.Set([]interface{}{"hello"}, "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := event.Hash()[:10]
+
+	found, err := sc.GetEvent(prefix)
+	if assert.NoError(t, err) {
+		assert.Equal(t, event.Hash(), found.Hash())
+	}
+	_, err = sc.GetEvent("")
+	assert.True(t, errors.Is(err, document.ErrAmbiguousPrefix))
+
+	sc.AcceptorKey = keys[0]
+	assert.False(t, sc.HasQuorum(prefix))
+	assert.True(t, errors.Is(sc.Vote(""), document.ErrAmbiguousPrefix))
+	assert.NoError(t, sc.Vote(prefix))
+	assert.True(t, sc.HasQuorum(prefix))
+	assert.False(t, sc.HasQuorum(genesis.Hash()[:10]))
+	assert.False(t, sc.HasQuorum(""))
+	sc.WithDocument(func(doc *document.Document) {
+		assert.Len(t, doc.Votes[event.Hash()], 1)
+		assert.Len(t, doc.Votes[prefix], 0)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	assert.NoError(t, sc.WaitForTip(ctx, prefix))
+	assert.NoError(t, sc.WaitForQuorum(ctx, prefix))
+	assert.Equal(t, context.DeadlineExceeded, sc.WaitForTip(ctx, ""))
+
+	// The error is a snapshot, so it's safe to use while the document
+	// changes
+	_, err = sc.GetEvent("zz")
+	done := make(chan struct{})
+	go func() {
+		sc.Set([]interface{}{"other"}, "edit")
+		close(done)
+	}()
+	assert.Contains(t, err.Error(), "No such event: 'zz'\n\nAvailable hashes (2):")
+	<-done
+}
@@ -0,0 +1,86 @@
+package deje
+
+import (
+	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/state"
+	"github.com/DJDNS/go-deje/util"
+)
+
+// Set the value at a path in the document. See Do.
+func (sc *SimpleClient) Set(path []interface{}, value interface{}) (*document.Event, error) {
+	return sc.Do("SET", map[string]interface{}{
+		"path":  path,
+		"value": value,
+	})
+}
+
+// Delete the value at a path in the document. See Do.
+func (sc *SimpleClient) Delete(path []interface{}) (*document.Event, error) {
+	return sc.Do("DELETE", map[string]interface{}{
+		"path": path,
+	})
+}
+
+// Make an edit to the document, as a new Event on top of the current
+// tip. If there's no tip yet, but the document has a genesis, the
+// Event builds on that instead.
+//
+// The Event is tried out on a scratch copy of the document state
+// first, so if it can't be applied, an error is returned and nothing
+// changes. Otherwise, it is registered, and promoted as the new tip
+// just like with Promote.
+//
+// If publishing fails, the Event is still returned, since it has
+// already been applied locally. Peers will pick it up the next time
+// they ask for events.
+//
+// Fails with ErrPinned if the document is pinned (see Pin).
+func (sc *SimpleClient) Do(handler string, args map[string]interface{}) (*document.Event, error) {
+	if sc.IsClosed() {
+		return nil, ErrClosed
+	}
+
+	sc.lock()
+	if sc.pin != "" {
+		sc.unlock()
+		return nil, ErrPinned
+	}
+	doc := sc.GetDoc()
+	event := doc.NewEvent(handler)
+	if args != nil {
+		// Store exactly what peers will see after deserializing
+		if err := util.CloneMarshal(args, &event.Arguments); err != nil {
+			sc.unlock()
+			return nil, err
+		}
+	}
+	if sc.Tip != nil {
+		event.SetParent(*sc.Tip)
+	} else if genesis, err := doc.Genesis(); err == nil {
+		event.SetParent(*genesis)
+	}
+
+	// reTip keeps the state at the tip, which is the Event's parent
+	scratch := state.NewDocumentState()
+	scratch.Apply(&state.SetPrimitive{
+		Path:  []interface{}{},
+		Value: doc.State.Export(),
+	})
+	if err := event.ApplyTo(scratch); err != nil {
+		sc.unlock()
+		return nil, err
+	}
+
+	event.Register()
+	events_message := sc.eventMessage(event)
+	doc.Timestamps = append(doc.Timestamps, event.Hash())
+	sc.addLocal(&event)
+	sc.reTip()
+	timestamps_message := sc.timestampsMessage()
+	sc.unlock()
+
+	if err := sc.Publish(events_message); err != nil {
+		return &event, err
+	}
+	return &event, sc.Publish(timestamps_message)
+}
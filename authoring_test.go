@@ -0,0 +1,113 @@
+package deje
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimpleClient_Set_Delete(t *testing.T) {
+	hub := NewLoopbackHub()
+	topic := "deje://loopback/authoring"
+	writer := NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+	reader := NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+	for _, sc := range []*SimpleClient{writer, reader} {
+		if err := sc.Connect(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Record how many events come with each publish-events message
+	counts := make(chan int, 20)
+	listener := hub.NewTransport()
+	if err := listener.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	listener.Subscribe(topic, func(topic string, event interface{}) {
+		message := event.(map[string]interface{})
+		if message["type"] == "02-publish-events" {
+			events, _ := message["events"].([]interface{})
+			counts <- len(events)
+		}
+	})
+
+	first, err := writer.Set([]interface{}{"hello"}, "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", first.ParentHash)
+	second, err := writer.Set([]interface{}{"count"}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, first.Hash(), second.ParentHash)
+	third, err := writer.Delete([]interface{}{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, second.Hash(), third.ParentHash)
+
+	expected := map[string]interface{}{"count": float64(3)}
+	assert.Equal(t, expected, writer.Export())
+	assert.Equal(t, third.Hash(), writer.GetTip().Hash())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*timeout)
+	defer cancel()
+	if err := reader.WaitForTip(ctx, third.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, reader.Export())
+
+	// Only the new event is broadcast each time
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 1, <-counts)
+	}
+}
+
+func TestSimpleClient_Do_Invalid(t *testing.T) {
+	lts := setupLoopback(t, 1)
+	sc := NewSimpleClientWithTransport("deje://loopback/invalid", lts[0], nil)
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	tip, err := sc.Set([]interface{}{"hello"}, "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Description string
+		Handler     string
+		Args        map[string]interface{}
+	}{
+		{"Parent does not exist", "SET", map[string]interface{}{
+			"path":  []interface{}{"no", "such", "path"},
+			"value": 1,
+		}},
+		{"No path", "DELETE", nil},
+		{"Unknown handler", "CUSTOM", map[string]interface{}{}},
+		{"Unserializable", "SET", map[string]interface{}{
+			"path":  []interface{}{"chan"},
+			"value": make(chan int),
+		}},
+	}
+	for _, test := range tests {
+		event, err := sc.Do(test.Handler, test.Args)
+		assert.Error(t, err, test.Description)
+		assert.Nil(t, event, test.Description)
+	}
+
+	// Nothing changed
+	assert.Equal(t, tip.Hash(), sc.GetTip().Hash())
+	assert.Equal(t, []string{tip.Hash()}, sc.GetTimestamps())
+	assert.Equal(t, map[string]interface{}{"hello": "world"}, sc.Export())
+	sc.WithDocument(func(doc *document.Document) {
+		assert.Len(t, doc.Events, 1)
+	})
+
+	assert.NoError(t, sc.Close())
+	_, err = sc.Set([]interface{}{"hello"}, "again")
+	assert.Equal(t, ErrClosed, err)
+}
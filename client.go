@@ -1,25 +1,39 @@
 package deje
 
 import (
+	"errors"
+
 	"github.com/DJDNS/go-deje/document"
-	"github.com/jcelliott/turnpike"
 )
 
-// Contains a document and a WAMP connection.
+// Returned by any attempt to use a Client or SimpleClient after it
+// has been closed.
+var ErrClosed = errors.New("Client is closed")
+
+// Contains a document and a Transport connection.
 type Client struct {
 	Doc   *document.Document
 	Topic string
 
-	onEvent  *OnEventCallback
-	tpClient *turnpike.Client
+	onConnect *OnConnectCallback
+	onEvent   *OnEventCallback
+	transport Transport
+	closed    bool
 }
 
+// Create a Client that uses the default Transport, which speaks WAMP v1
+// (see Wamp1Transport).
 func NewClient(topic string) Client {
+	return NewClientWithTransport(topic, NewWamp1Transport())
+}
+
+// Create a Client that talks to the network over the given Transport.
+func NewClientWithTransport(topic string, transport Transport) Client {
 	doc := document.NewDocument()
 	return Client{
-		Doc:      &doc,
-		Topic:    topic,
-		tpClient: turnpike.NewClient(),
+		Doc:       &doc,
+		Topic:     topic,
+		transport: transport,
 	}
 }
 
@@ -27,14 +41,14 @@ func NewClient(topic string) Client {
 // ID value. sessionId is just a server-chosen string.
 type OnConnectCallback func(sessionId string)
 
-// Called when another peer in the same WAMP topic publishes a
+// Called when another peer in the same topic publishes a
 // JSON-compatible event to all subscribers.
 type OnEventCallback func(event interface{})
 
 // Set callback to be executed when a successful connection has been
-// made to a WAMP router.
+// made to a router.
 func (c *Client) SetConnectCallback(callback OnConnectCallback) {
-	c.tpClient.SetSessionOpenCallback(callback)
+	c.onConnect = &callback
 }
 
 // Set callback to be executed when a published event is received.
@@ -42,27 +56,61 @@ func (c *Client) SetEventCallback(callback OnEventCallback) {
 	c.onEvent = &callback
 }
 
+// Set callback to be executed when the connection is lost, other than
+// by closing it.
+func (c *Client) SetDisconnectCallback(callback OnDisconnectCallback) {
+	c.transport.SetDisconnectCallback(callback)
+}
+
 // Publish an event to all subscribers. Can be any JSON-compatible
 // value.
 func (c *Client) Publish(event interface{}) error {
-	return c.tpClient.PublishExcludeMe(
-		c.Topic,
-		event,
-	)
+	if c.closed {
+		return ErrClosed
+	}
+	return c.transport.Publish(c.Topic, event)
 }
 
-// Connect to a WAMP router. This also calls the 'connect' callback on
+// Connect to a router. This also calls the 'connect' callback on
 // success.
 func (c *Client) Connect(url string) error {
-	err := c.tpClient.Connect(url, "http://localhost/")
+	if c.closed {
+		return ErrClosed
+	}
+	return c.connect(url)
+}
+
+func (c *Client) connect(url string) error {
+	err := c.transport.Connect(url)
 	if err != nil {
 		return err
 	}
+	if c.onConnect != nil {
+		(*c.onConnect)(c.transport.SessionID())
+	}
 
 	handler := func(topic string, event interface{}) {
 		if c.onEvent != nil {
 			(*c.onEvent)(event)
 		}
 	}
-	return c.tpClient.Subscribe(c.Topic, handler)
+	return c.transport.Subscribe(c.Topic, handler)
+}
+
+// Unsubscribe from the topic, and close the connection. After this,
+// Connect and Publish return ErrClosed, as does calling Close again.
+func (c *Client) Close() error {
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+
+	var err error
+	if c.transport.SessionID() != "" {
+		err = c.transport.Unsubscribe(c.Topic)
+	}
+	if close_err := c.transport.Close(); err == nil {
+		err = close_err
+	}
+	return err
 }
@@ -18,8 +18,8 @@ func TestClient_NewClient(t *testing.T) {
 		t.Fatalf("Expected topic '%s', got '%s'", topic, client.Topic)
 	}
 
-	if client.tpClient == nil {
-		t.Fatal("client.tpClient should not be nil pointer")
+	if client.transport == nil {
+		t.Fatal("client.transport should not be nil")
 	}
 }
 
@@ -34,6 +34,33 @@ func setupServer() (string, func()) {
 	}
 }
 
+func TestClient_Close(t *testing.T) {
+	topic := "http://example.com/deje/some-doc"
+	client := NewClient(topic)
+	server_addr, server_closer := setupServer()
+	defer server_closer()
+
+	if err := client.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if client.transport.SessionID() != "" {
+		t.Fatal("Transport should be closed")
+	}
+
+	if err := client.Close(); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed from Close, got %v", err)
+	}
+	if err := client.Publish("foo"); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed from Publish, got %v", err)
+	}
+	if err := client.Connect(server_addr); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed from Connect, got %v", err)
+	}
+}
+
 func TestClient_Connect(t *testing.T) {
 	topic := "http://example.com/deje/some-doc"
 	client := NewClient(topic)
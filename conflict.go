@@ -0,0 +1,122 @@
+package deje
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/DJDNS/go-deje/document"
+)
+
+// Given with NotifyConflict, when a lost edit wasn't rebased because
+// AutoRebase is off.
+var ErrLostEdit = errors.New("Local edit lost to a competing fork")
+
+// Look for our own edits that are no longer compatible with the tip,
+// and tell subscribers about them. With AutoRebase, they are replayed
+// on top of the tip, and published.
+//
+// Returns whether anything was rebased, in which case the caller
+// should reTip again. Must be called with sc.mutex held.
+func (sc *SimpleClient) checkConflicts() bool {
+	if sc.Tip == nil || len(sc.local) == 0 {
+		return false
+	}
+	tip_history, ok := sc.Tip.GetHistory()
+	if !ok {
+		return false
+	}
+	in_tip := make(map[string]bool, len(tip_history))
+	for _, ev := range tip_history {
+		in_tip[ev.Hash()] = true
+	}
+
+	// Sorted, so that rebasing happens in a predictable order
+	hashes := make([]string, 0, len(sc.local))
+	for hash := range sc.local {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	doc := sc.GetDoc()
+	var rebased bool
+	for _, hash := range hashes {
+		if in_tip[hash] {
+			continue
+		}
+		event, ok := doc.Events[hash]
+		if !ok {
+			continue
+		}
+		history, ok := event.GetHistory()
+		if !ok || historyContains(history, sc.Tip.Hash()) {
+			// Can't tell yet, or still ahead of the tip
+			continue
+		}
+
+		delete(sc.local, hash)
+		n := Notification{Kind: NotifyConflict, Event: event}
+		if sc.AutoRebase {
+			n.Rebased, n.Err = sc.rebase(event)
+			rebased = rebased || n.Rebased != nil
+		} else {
+			n.Err = ErrLostEdit
+		}
+		if n.Err != nil {
+			sc.Log(n.Err, hash)
+		}
+		sc.notify(n)
+	}
+	return rebased
+}
+
+// Replay an edit on top of the tip, register and timestamp the new
+// events, and queue them up to be published. Returns the last of the
+// new events, or nil if the edit is already part of the tip's history,
+// so there was nothing to replay. Must be called with sc.mutex held.
+func (sc *SimpleClient) rebase(event *document.Event) (*document.Event, error) {
+	events, err := event.Rebase(sc.Tip)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	for _, ev := range events {
+		ev.Register()
+	}
+	last := events[len(events)-1]
+	events_message := sc.eventMessage(*last)
+
+	doc := sc.GetDoc()
+	doc.Timestamps = append(doc.Timestamps, last.Hash())
+	sc.addLocal(last)
+	timestamps_message := sc.timestampsMessage()
+
+	sc.pending = append(sc.pending, func() {
+		if err := sc.Publish(events_message); err != nil {
+			sc.Log(err)
+		}
+		if err := sc.Publish(timestamps_message); err != nil {
+			sc.Log(err)
+		}
+	})
+	return last, nil
+}
+
+// Remember one of our own edits, so we notice if it loses out to a
+// competing fork. Earlier edits it was built on are forgotten, since
+// it can't survive without them. Must be called with sc.mutex held.
+func (sc *SimpleClient) addLocal(event *document.Event) {
+	if history, ok := event.GetHistory(); ok {
+		for _, ev := range history {
+			delete(sc.local, ev.Hash())
+		}
+	}
+	sc.local[event.Hash()] = true
+}
+
+func historyContains(history []*document.Event, hash string) bool {
+	for _, ev := range history {
+		if ev.Hash() == hash {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package deje
+
+import (
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimpleClient_Conflict(t *testing.T) {
+	tests := []struct {
+		Description string
+		AutoRebase  bool
+		Theirs      func(event *document.Event) // Set up the competing edit
+		Rebased     bool
+		Error       string
+		Export      map[string]interface{}
+	}{
+		{
+			"Rebased on top of theirs", true,
+			func(event *document.Event) {
+				event.HandlerName = "SET"
+				event.Arguments["path"] = []interface{}{"items", "theirs"}
+				event.Arguments["value"] = "b"
+			},
+			true, "",
+			map[string]interface{}{"items": map[string]interface{}{
+				"mine":   "a",
+				"theirs": "b",
+			}},
+		},
+		{
+			"AutoRebase disabled", false,
+			func(event *document.Event) {
+				event.HandlerName = "SET"
+				event.Arguments["path"] = []interface{}{"items", "theirs"}
+				event.Arguments["value"] = "b"
+			},
+			false, ErrLostEdit.Error(),
+			map[string]interface{}{"items": map[string]interface{}{
+				"theirs": "b",
+			}},
+		},
+		{
+			"Conflicting edits", true,
+			func(event *document.Event) {
+				event.HandlerName = "DELETE"
+				event.Arguments["path"] = []interface{}{"items"}
+			},
+			false, "Key not present in map",
+			map[string]interface{}{},
+		},
+	}
+	for _, test := range tests {
+		hub := NewLoopbackHub()
+		topic := "deje://loopback/conflict"
+		sc := NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+		sc.AutoRebase = test.AutoRebase
+		if err := sc.Connect(""); err != nil {
+			t.Fatal(err)
+		}
+		conflicts := sc.Subscribe(NotifyConflict, 10)
+
+		base, err := sc.Set([]interface{}{"items"}, map[string]interface{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mine, err := sc.Set([]interface{}{"items", "mine"}, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A peer made a competing edit, and timestamped it before ours
+		theirs := document.NewEvent("")
+		test.Theirs(&theirs)
+		theirs.SetParent(*base)
+		peer := hub.NewTransport()
+		if err := peer.Connect(""); err != nil {
+			t.Fatal(err)
+		}
+		peer.Publish(topic, map[string]interface{}{
+			"type":   "02-publish-events",
+			"events": []interface{}{theirs},
+		})
+		peer.Publish(topic, map[string]interface{}{
+			"type":       "02-publish-timestamps",
+			"timestamps": []interface{}{base.Hash(), theirs.Hash(), mine.Hash()},
+		})
+
+		select {
+		case n := <-conflicts.C:
+			assert.Equal(t, mine.Hash(), n.Event.Hash(), test.Description)
+			if test.Error != "" {
+				assert.EqualError(t, n.Err, test.Error, test.Description)
+			} else {
+				assert.NoError(t, n.Err, test.Description)
+			}
+			if test.Rebased && assert.NotNil(t, n.Rebased, test.Description) {
+				assert.Equal(t, theirs.Hash(), n.Rebased.ParentHash, test.Description)
+				assert.Equal(t, n.Rebased.Hash(), sc.GetTip().Hash(), test.Description)
+			} else {
+				assert.Nil(t, n.Rebased, test.Description)
+				assert.Equal(t, theirs.Hash(), sc.GetTip().Hash(), test.Description)
+			}
+		case <-time.After(20 * timeout):
+			t.Fatalf("%s: Timed out waiting for conflict", test.Description)
+		}
+		assert.Equal(t, test.Export, sc.Export(), test.Description)
+
+		// Each edit is only reported once
+		sc.ReTip()
+		assert.Len(t, conflicts.C, 0, test.Description)
+		sc.Close()
+	}
+}
+
+func TestSimpleClient_CheckConflicts_Unsettled(t *testing.T) {
+	buffer := new(syncBuffer)
+	logger := log.New(buffer, "conflict_test: ", 0)
+	sc := NewSimpleClientWithTransport("deje://loopback/unsettled", NewLoopbackHub().NewTransport(), logger)
+	sc.AutoRebase = true
+	base, err := sc.Set([]interface{}{"items"}, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine, err := sc.Set([]interface{}{"items", "mine"}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.Close() // So that publishing rebased edits fails, below
+
+	sc.lock()
+	doc := sc.GetDoc()
+	newEvent := func(parent string) *document.Event {
+		ev := doc.NewEvent("SET")
+		ev.Arguments["path"] = []interface{}{"items", "theirs"}
+		ev.Arguments["value"] = "b"
+		ev.ParentHash = parent
+		ev.Register()
+		return &ev
+	}
+
+	// Edits that are still ahead of the tip, or have gone missing,
+	// can't have lost out yet
+	ahead := newEvent(mine.Hash())
+	sc.local[ahead.Hash()] = true
+	sc.local["missing"] = true
+	assert.False(t, sc.checkConflicts())
+	assert.Len(t, sc.local, 3)
+
+	// Nor can anything, when the tip's own history is incomplete
+	tip := sc.Tip
+	sc.Tip = newEvent("missing")
+	assert.False(t, sc.checkConflicts())
+	assert.Len(t, sc.local, 3)
+	sc.Tip = tip
+
+	// Publishing happens once the mutex is released, so failures are
+	// only logged
+	rebased, err := sc.rebase(newEvent(base.Hash()))
+	assert.NoError(t, err)
+	assert.NotNil(t, rebased)
+	sc.unlock()
+	assert.Equal(t, 2, strings.Count(buffer.String(), ErrClosed.Error()))
+}
+
+func TestSimpleClient_Rebase_Nothing(t *testing.T) {
+	sc := NewSimpleClientWithTransport("deje://loopback/rebase-nothing", NewLoopbackHub().NewTransport(), nil)
+	base, err := sc.Set([]interface{}{"items"}, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.Set([]interface{}{"items", "mine"}, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Already part of the tip's history, so there's nothing to replay
+	sc.lock()
+	events := len(sc.GetDoc().Events)
+	rebased, err := sc.rebase(base)
+	assert.NoError(t, err)
+	assert.Nil(t, rebased)
+	assert.Len(t, sc.GetDoc().Events, events)
+	assert.Len(t, sc.pending, 0)
+	sc.unlock()
+}
@@ -0,0 +1,239 @@
+package deje
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Default bounds for the delay between reconnection attempts.
+const (
+	DefaultMinReconnectDelay = 100 * time.Millisecond
+	DefaultMaxReconnectDelay = 30 * time.Second
+)
+
+// How long Close waits to send messages held back while reconnecting.
+const DefaultCloseTimeout = 5 * time.Second
+
+// How many messages a SimpleClient holds on to while reconnecting.
+// Past this, the oldest are dropped.
+const outboxLimit = 1024
+
+// Whether a SimpleClient is currently talking to the network.
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnected
+	StateReconnecting
+)
+
+func (state ConnectionState) String() string {
+	switch state {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	}
+	return "unknown"
+}
+
+// An optional callback to be called whenever the connection state of a
+// SimpleClient changes. It may be called from any goroutine.
+type OnConnectionStateCallback func(state ConnectionState)
+
+// Set a callback for when the connection state changes.
+func (sc *SimpleClient) SetConnectionStateCallback(c OnConnectionStateCallback) {
+	sc.connMutex.Lock()
+	defer sc.connMutex.Unlock()
+	sc.onConnectionStateCallback = c
+}
+
+// Get the current connection state.
+func (sc *SimpleClient) ConnectionState() ConnectionState {
+	sc.connMutex.Lock()
+	defer sc.connMutex.Unlock()
+	return sc.connState
+}
+
+func (sc *SimpleClient) setConnectionState(state ConnectionState) {
+	sc.connMutex.Lock()
+	sc.connState = state
+	callback := sc.onConnectionStateCallback
+	sc.connMutex.Unlock()
+
+	if callback != nil {
+		callback(state)
+	}
+}
+
+// Called by the transport when the connection is lost.
+func (sc *SimpleClient) onDisconnect(err error) {
+	sc.Log(err)
+	sc.setConnectionState(StateDisconnected)
+	if sc.AutoReconnect {
+		sc.setConnectionState(StateReconnecting)
+		reconnected := make(chan struct{})
+		sc.connMutex.Lock()
+		sc.reconnected = reconnected
+		sc.connMutex.Unlock()
+		go func() {
+			sc.reconnect()
+			close(reconnected)
+		}()
+	}
+}
+
+// Keep trying to reconnect, backing off exponentially between attempts.
+//
+// Once connected, anything published in the meantime is sent, and we
+// ask peers for their timestamps and events, in case we missed any. If
+// we're closed with messages still held back, there's one last attempt
+// to send them, which Close waits on.
+func (sc *SimpleClient) reconnect() {
+	delay := sc.MinReconnectDelay
+	for {
+		select {
+		case <-time.After(delay):
+		case <-sc.closing:
+		}
+		sc.connMutex.Lock()
+		url := sc.url
+		state := sc.connState
+		closed := sc.closed
+		held := len(sc.outbox)
+		sc.connMutex.Unlock()
+		if state != StateReconnecting || closed && held == 0 {
+			// Someone else took care of it, or we were closed
+			return
+		}
+
+		err := sc.client.connect(url)
+		if err == nil {
+			break
+		}
+		sc.Log("Reconnect failed:", err)
+		if closed {
+			return
+		}
+		delay = nextReconnectDelay(delay, sc.MaxReconnectDelay)
+	}
+
+	// Flush while holding the lock, so nothing gets published out of order
+	sc.connMutex.Lock()
+	if sc.closed && sc.connState != StateReconnecting {
+		// Close stopped waiting for us
+		sc.connMutex.Unlock()
+		sc.client.transport.Close()
+		return
+	}
+	for _, data := range sc.outbox {
+		if err := sc.client.Publish(data); err != nil {
+			sc.Log(err)
+		}
+	}
+	sc.outbox = nil
+	if sc.closed {
+		// Close takes it from here
+		sc.connMutex.Unlock()
+		return
+	}
+	sc.connState = StateConnected
+	callback := sc.onConnectionStateCallback
+	sc.connMutex.Unlock()
+	if callback != nil {
+		callback(StateConnected)
+	}
+
+	if err := sc.RequestTimestamps(); err != nil {
+		sc.Log(err)
+	}
+	if err := sc.RequestEvents(); err != nil {
+		sc.Log(err)
+	}
+}
+
+// Hold on to a message until we are reconnected. Must be called with
+// sc.connMutex held.
+func (sc *SimpleClient) queueOutbound(data interface{}) error {
+	// Catch unserializable messages now, rather than at flush time
+	if _, err := json.Marshal(data); err != nil {
+		return err
+	}
+	sc.outbox = append(sc.outbox, data)
+	if len(sc.outbox) > outboxLimit {
+		sc.outbox = sc.outbox[1:]
+	}
+	return nil
+}
+
+// Whether Close has been called.
+func (sc *SimpleClient) IsClosed() bool {
+	sc.connMutex.Lock()
+	defer sc.connMutex.Unlock()
+	return sc.closed
+}
+
+// Disconnect for good. If we're reconnecting, anything held back in
+// the meantime gets one last chance to be sent (see CloseTimeout), and
+// is otherwise discarded. Then we unsubscribe from the topic and close
+// the connection, which sends whatever the transport has queued.
+//
+// After this, any method that talks to the network returns ErrClosed,
+// as does calling Close again. Every Subscription is closed too.
+func (sc *SimpleClient) Close() error {
+	sc.connMutex.Lock()
+	if sc.closed {
+		sc.connMutex.Unlock()
+		return ErrClosed
+	}
+	sc.closed = true
+	close(sc.closing)
+	flushing := sc.connState == StateReconnecting && len(sc.outbox) > 0
+	reconnected := sc.reconnected
+	sc.connMutex.Unlock()
+
+	if flushing && reconnected != nil {
+		select {
+		case <-reconnected:
+		case <-time.After(sc.CloseTimeout):
+		}
+	}
+
+	sc.connMutex.Lock()
+	if len(sc.outbox) > 0 {
+		sc.Log("Discarding", len(sc.outbox), "unsent messages")
+	}
+	sc.outbox = nil
+	was_disconnected := sc.connState == StateDisconnected
+	sc.connState = StateDisconnected
+	callback := sc.onConnectionStateCallback
+	sc.connMutex.Unlock()
+
+	// Not holding the lock, since unsubscribing may have to wait on
+	// the transport, which may be waiting on us to handle an event.
+	err := sc.client.Close()
+	if !was_disconnected && callback != nil {
+		callback(StateDisconnected)
+	}
+
+	// Wake up anything waiting on the document
+	sc.lock()
+	sc.dirty = true
+	sc.closeSubscriptions()
+	sc.unlock()
+	return err
+}
+
+// Double the delay, without going over max.
+func nextReconnectDelay(delay, max time.Duration) time.Duration {
+	delay *= 2
+	if delay <= 0 {
+		delay = time.Millisecond
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
@@ -0,0 +1,487 @@
+package deje
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fails the first few connection attempts, and records when each
+// attempt was made.
+type flakyTransport struct {
+	*LoopbackTransport
+	Failures int
+
+	mutex    sync.Mutex
+	attempts []time.Time
+}
+
+func (ft *flakyTransport) Connect(url string) error {
+	ft.mutex.Lock()
+	ft.attempts = append(ft.attempts, time.Now())
+	fail := len(ft.attempts) <= ft.Failures
+	ft.mutex.Unlock()
+	if fail {
+		return errors.New("Flaky connection")
+	}
+	return ft.LoopbackTransport.Connect(url)
+}
+
+func (ft *flakyTransport) Attempts() []time.Time {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	return append([]time.Time{}, ft.attempts...)
+}
+
+// A LoopbackTransport that runs a hook when it connects, and can be
+// told to fail to publish.
+type hookTransport struct {
+	*LoopbackTransport
+	OnConnect  func()
+	PublishErr error
+}
+
+func (ht *hookTransport) Connect(url string) error {
+	err := ht.LoopbackTransport.Connect(url)
+	if ht.OnConnect != nil {
+		ht.OnConnect()
+	}
+	return err
+}
+
+func (ht *hookTransport) Publish(topic string, event interface{}) error {
+	if ht.PublishErr != nil {
+		return ht.PublishErr
+	}
+	return ht.LoopbackTransport.Publish(topic, event)
+}
+
+// Record the type of every message published to a topic.
+func listenLoopback(t *testing.T, hub *LoopbackHub, topic string) chan string {
+	types := make(chan string, 20)
+	lt := hub.NewTransport()
+	if err := lt.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	lt.Subscribe(topic, func(topic string, event interface{}) {
+		evtype, _ := event.(map[string]interface{})["type"].(string)
+		types <- evtype
+	})
+	return types
+}
+
+func expectTypes(t *testing.T, types chan string, expected ...string) {
+	for _, evtype := range expected {
+		select {
+		case got := <-types:
+			assert.Equal(t, evtype, got)
+		case <-time.After(timeout):
+			t.Fatalf("Timed out waiting for %s", evtype)
+		}
+	}
+}
+
+func recordStates(sc *SimpleClient) chan ConnectionState {
+	states := make(chan ConnectionState, 20)
+	sc.SetConnectionStateCallback(func(state ConnectionState) {
+		states <- state
+	})
+	return states
+}
+
+func expectStates(t *testing.T, states chan ConnectionState, expected ...ConnectionState) {
+	for _, state := range expected {
+		select {
+		case got := <-states:
+			assert.Equal(t, state, got)
+		case <-time.After(20 * timeout):
+			t.Fatalf("Timed out waiting for state %s", state)
+		}
+	}
+}
+
+func TestConnectionState_String(t *testing.T) {
+	assert.Equal(t, "disconnected", StateDisconnected.String())
+	assert.Equal(t, "connected", StateConnected.String())
+	assert.Equal(t, "reconnecting", StateReconnecting.String())
+	assert.Equal(t, "unknown", ConnectionState(-1).String())
+}
+
+func TestNextReconnectDelay(t *testing.T) {
+	tests := []struct {
+		Delay    time.Duration
+		Max      time.Duration
+		Expected time.Duration
+	}{
+		{time.Second, time.Minute, 2 * time.Second},
+		{40 * time.Second, time.Minute, time.Minute},
+		{time.Minute, time.Minute, time.Minute},
+		{0, time.Minute, time.Millisecond},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, nextReconnectDelay(test.Delay, test.Max))
+	}
+}
+
+func TestSimpleClient_Reconnect(t *testing.T) {
+	hub := NewLoopbackHub()
+	topic := "deje://loopback/reconnect"
+	lt := hub.NewTransport()
+	sc := NewSimpleClientWithTransport(topic, lt, nil)
+	sc.MinReconnectDelay = time.Millisecond
+	states := recordStates(sc)
+	types := listenLoopback(t, hub, topic)
+
+	assert.Equal(t, StateDisconnected, sc.ConnectionState())
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	expectStates(t, states, StateConnected)
+	expectTypes(t, types, "02-request-timestamps")
+
+	lt.Drop()
+	expectStates(t, states, StateDisconnected, StateReconnecting, StateConnected)
+	assert.Equal(t, StateConnected, sc.ConnectionState())
+	assert.NotEqual(t, "", lt.SessionID(), "Reattached to hub")
+
+	// Resync after reconnecting
+	expectTypes(t, types, "02-request-timestamps", "02-request-events")
+}
+
+func TestSimpleClient_Reconnect_Backoff(t *testing.T) {
+	hub := NewLoopbackHub()
+	topic := "deje://loopback/backoff"
+	ft := &flakyTransport{LoopbackTransport: hub.NewTransport()}
+	sc := NewSimpleClientWithTransport(topic, ft, nil)
+	sc.MinReconnectDelay = 2 * time.Millisecond
+	sc.MaxReconnectDelay = 8 * time.Millisecond
+	states := recordStates(sc)
+	types := listenLoopback(t, hub, topic)
+
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	expectStates(t, states, StateConnected)
+	expectTypes(t, types, "02-request-timestamps")
+
+	ft.Failures = 6 // Including the initial connection
+	ft.Drop()
+	expectStates(t, states, StateDisconnected, StateReconnecting)
+
+	// Published while reconnecting, so held back
+	assert.NoError(t, sc.PublishTimestamps())
+	if err := sc.Publish(make(chan int)); err == nil {
+		t.Fatal("Should have failed, chan int cannot be serialized")
+	}
+
+	expectStates(t, states, StateConnected)
+	expectTypes(t, types,
+		"02-publish-timestamps",
+		"02-request-timestamps",
+		"02-request-events",
+	)
+
+	// 1 initial connection, 5 failures, 1 success
+	attempts := ft.Attempts()
+	if !assert.Equal(t, 7, len(attempts)) {
+		t.FailNow()
+	}
+	minimums := []time.Duration{4, 8, 8, 8, 8}
+	for i, minimum := range minimums {
+		gap := attempts[i+2].Sub(attempts[i+1])
+		assert.True(t, gap >= minimum*time.Millisecond,
+			"Attempt %d came after %s, expected at least %dms", i+2, gap, minimum)
+	}
+}
+
+func TestSimpleClient_Reconnect_Disabled(t *testing.T) {
+	hub := NewLoopbackHub()
+	lt := hub.NewTransport()
+	sc := NewSimpleClientWithTransport("deje://loopback/disabled", lt, nil)
+	sc.AutoReconnect = false
+	states := recordStates(sc)
+
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	lt.Drop()
+	expectStates(t, states, StateConnected, StateDisconnected)
+	select {
+	case state := <-states:
+		t.Fatalf("Unexpected state change: %s", state)
+	case <-time.After(5 * time.Millisecond):
+	}
+	assert.Equal(t, "", lt.SessionID())
+}
+
+func TestSimpleClient_Reconnect_Unneeded(t *testing.T) {
+	lt := NewLoopbackHub().NewTransport()
+	sc := NewSimpleClientWithTransport("deje://loopback/unneeded", lt, nil)
+	sc.MinReconnectDelay = time.Millisecond
+
+	// Not reconnecting, so there's nothing to do
+	sc.reconnect()
+	assert.Equal(t, StateDisconnected, sc.ConnectionState())
+	assert.Equal(t, "", lt.SessionID())
+}
+
+func TestSimpleClient_Reconnect_Closed(t *testing.T) {
+	hub := NewLoopbackHub()
+	topic := "deje://loopback/closed"
+	types := listenLoopback(t, hub, topic)
+	ht := &hookTransport{LoopbackTransport: hub.NewTransport()}
+	sc := NewSimpleClientWithTransport(topic, ht, nil)
+	sc.MinReconnectDelay = time.Millisecond
+	sc.setConnectionState(StateReconnecting)
+	assert.NoError(t, sc.Publish(map[string]interface{}{"type": "held back"}))
+
+	// Closed just as the connection goes through, with Close waiting,
+	// so what's held back is sent, and the rest is left to Close
+	ht.OnConnect = func() {
+		sc.connMutex.Lock()
+		sc.closed = true
+		sc.connMutex.Unlock()
+	}
+	sc.reconnect()
+	expectTypes(t, types, "held back")
+	assert.Equal(t, StateReconnecting, sc.ConnectionState())
+	assert.NotEqual(t, "", ht.SessionID())
+
+	// Or if Close already gave up waiting, the connection is let go
+	ht.OnConnect = func() {
+		sc.connMutex.Lock()
+		sc.closed = true
+		sc.connState = StateDisconnected
+		sc.connMutex.Unlock()
+	}
+	sc.connMutex.Lock()
+	sc.closed = false
+	sc.outbox = []interface{}{map[string]interface{}{"type": "too late"}}
+	sc.connMutex.Unlock()
+	sc.setConnectionState(StateReconnecting)
+	sc.reconnect()
+	assert.Equal(t, StateDisconnected, sc.ConnectionState())
+	assert.Equal(t, "", ht.SessionID())
+	assert.Equal(t, 0, len(types))
+}
+
+func TestSimpleClient_Reconnect_PublishFails(t *testing.T) {
+	buffer := new(syncBuffer)
+	logger := log.New(buffer, "reconnect_test: ", 0)
+	ht := &hookTransport{
+		LoopbackTransport: NewLoopbackHub().NewTransport(),
+		PublishErr:        errors.New("Publishing fails"),
+	}
+	sc := NewSimpleClientWithTransport("deje://loopback/publish-fails", ht, logger)
+	sc.MinReconnectDelay = time.Millisecond
+	sc.setConnectionState(StateReconnecting)
+	assert.NoError(t, sc.Publish("held back"))
+
+	// Connected anyway, but the held back message, and the requests
+	// to resync, are lost
+	sc.reconnect()
+	assert.Equal(t, StateConnected, sc.ConnectionState())
+	assert.Equal(t, strings.Repeat("reconnect_test: Publishing fails\n", 3), buffer.String())
+}
+
+// Whatever was done locally stays done, and the Event comes back along
+// with the error.
+func TestSimpleClient_PublishFails(t *testing.T) {
+	tests := []struct {
+		Desc string
+		Do   func(sc *SimpleClient) (*document.Event, error)
+	}{
+		{"Do", func(sc *SimpleClient) (*document.Event, error) {
+			return sc.Set([]interface{}{"hello"}, "world")
+		}},
+		{"Promote", func(sc *SimpleClient) (*document.Event, error) {
+			event := sc.GetDoc().NewEvent("SET")
+			event.Arguments["path"] = []interface{}{"hello"}
+			event.Arguments["value"] = "world"
+			register(sc, &event)
+			return &event, sc.Promote(event)
+		}},
+		{"CreateGenesis", func(sc *SimpleClient) (*document.Event, error) {
+			return sc.CreateGenesis(creatorKey, nil)
+		}},
+	}
+	for _, test := range tests {
+		ht := &hookTransport{
+			LoopbackTransport: NewLoopbackHub().NewTransport(),
+			PublishErr:        errors.New("Publishing fails"),
+		}
+		sc := NewSimpleClientWithTransport("deje://loopback/publish-fails", ht, nil)
+		event, err := test.Do(sc)
+		assert.EqualError(t, err, "Publishing fails", test.Desc)
+		if assert.NotNil(t, event, test.Desc) && assert.NotNil(t, sc.GetTip(), test.Desc) {
+			assert.Equal(t, event.Hash(), sc.GetTip().Hash(), test.Desc)
+		}
+	}
+}
+
+func TestSimpleClient_Publish_OutboxLimit(t *testing.T) {
+	sc := NewSimpleClientWithTransport("deje://loopback/outbox", NewLoopbackHub().NewTransport(), nil)
+	sc.setConnectionState(StateReconnecting)
+	for i := 0; i <= outboxLimit; i++ {
+		assert.NoError(t, sc.Publish(i))
+	}
+	assert.Error(t, sc.Publish(func() {}), "Unserializable")
+
+	// The oldest message makes way
+	sc.connMutex.Lock()
+	assert.Len(t, sc.outbox, outboxLimit)
+	assert.Equal(t, 1, sc.outbox[0])
+	sc.connMutex.Unlock()
+}
+
+func TestSimpleClient_Close(t *testing.T) {
+	hub := NewLoopbackHub()
+	topic := "deje://loopback/close"
+	lt := hub.NewTransport()
+	sc := NewSimpleClientWithTransport(topic, lt, nil)
+	states := recordStates(sc)
+	types := listenLoopback(t, hub, topic)
+	other := NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	expectStates(t, states, StateConnected)
+
+	// Let the initial timestamp requests settle
+	<-time.After(timeout)
+	for len(types) > 0 {
+		<-types
+	}
+
+	assert.False(t, sc.IsClosed())
+	assert.NoError(t, sc.Close())
+	assert.True(t, sc.IsClosed())
+	expectStates(t, states, StateDisconnected)
+	assert.Equal(t, "", lt.SessionID())
+
+	// No longer hears from the topic, so never answers requests
+	assert.NoError(t, other.RequestEvents())
+	expectTypes(t, types, "02-request-events")
+	select {
+	case evtype := <-types:
+		t.Fatalf("Closed client is still talking: %s", evtype)
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	event := sc.GetDoc().NewEvent("SET")
+	register(sc, &event)
+	assert.Equal(t, ErrClosed, sc.Close())
+	assert.Equal(t, ErrClosed, sc.Connect(""))
+	assert.Equal(t, ErrClosed, sc.Publish("foo"))
+	assert.Equal(t, ErrClosed, sc.RequestTimestamps())
+	assert.Equal(t, ErrClosed, sc.Promote(event))
+}
+
+func TestSimpleClient_Close_Reconnecting(t *testing.T) {
+	hub := NewLoopbackHub()
+	ft := &flakyTransport{LoopbackTransport: hub.NewTransport()}
+	sc := NewSimpleClientWithTransport("deje://loopback/close-reconnecting", ft, nil)
+	sc.MinReconnectDelay = time.Millisecond
+	sc.MaxReconnectDelay = time.Millisecond
+	states := recordStates(sc)
+
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	ft.Failures = 1000
+	ft.Drop()
+	expectStates(t, states, StateConnected, StateDisconnected, StateReconnecting)
+	assert.NoError(t, sc.PublishTimestamps(), "Held back")
+
+	assert.NoError(t, sc.Close())
+	expectStates(t, states, StateDisconnected)
+
+	// Reconnect attempts stop
+	<-time.After(5 * time.Millisecond)
+	attempts := len(ft.Attempts())
+	<-time.After(5 * time.Millisecond)
+	assert.Equal(t, attempts, len(ft.Attempts()))
+
+	// Even in the middle of a long wait
+	waiting := NewSimpleClientWithTransport("deje://loopback/close-waiting", hub.NewTransport(), nil)
+	waiting.MinReconnectDelay = time.Hour
+	waiting.setConnectionState(StateReconnecting)
+	done := make(chan struct{})
+	go func() {
+		waiting.reconnect()
+		close(done)
+	}()
+	<-time.After(5 * time.Millisecond)
+	waiting.Close()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal("Still waiting to reconnect after Close")
+	}
+}
+
+// Whatever was published before Close gets one last chance to be sent.
+func TestSimpleClient_Close_Flush(t *testing.T) {
+	hub := NewLoopbackHub()
+	topic := "deje://loopback/close-flush"
+	types := listenLoopback(t, hub, topic)
+	ft := &flakyTransport{LoopbackTransport: hub.NewTransport()}
+	sc := NewSimpleClientWithTransport(topic, ft, nil)
+	sc.MinReconnectDelay = time.Hour
+	states := recordStates(sc)
+
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	expectTypes(t, types, "02-request-timestamps")
+	ft.Drop()
+	expectStates(t, states, StateConnected, StateDisconnected, StateReconnecting)
+	assert.NoError(t, sc.PublishTimestamps(), "Held back")
+
+	assert.NoError(t, sc.Close())
+	expectTypes(t, types, "02-publish-timestamps")
+	expectStates(t, states, StateDisconnected)
+	assert.Equal(t, "", ft.SessionID())
+}
+
+// But Close doesn't wait forever for it.
+func TestSimpleClient_Close_FlushTimeout(t *testing.T) {
+	buffer := new(syncBuffer)
+	logger := log.New(buffer, "close_test: ", 0)
+	ht := &hookTransport{LoopbackTransport: NewLoopbackHub().NewTransport()}
+	sc := NewSimpleClientWithTransport("deje://loopback/close-timeout", ht, logger)
+	sc.MinReconnectDelay = time.Hour
+	sc.CloseTimeout = time.Millisecond
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	ht.Drop()
+	assert.NoError(t, sc.PublishTimestamps(), "Held back")
+
+	stuck := make(chan struct{})
+	ht.OnConnect = func() { <-stuck }
+	assert.NoError(t, sc.Close())
+	assert.Contains(t, buffer.String(), "close_test: Discarding 1 unsent messages\n")
+
+	// Once the connection finally goes through, it's let go
+	sc.connMutex.Lock()
+	reconnected := sc.reconnected
+	sc.connMutex.Unlock()
+	close(stuck)
+	select {
+	case <-reconnected:
+	case <-time.After(timeout):
+		t.Fatal("Still reconnecting after Close")
+	}
+	assert.Equal(t, "", ht.SessionID())
+}
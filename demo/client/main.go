@@ -5,15 +5,19 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/DJDNS/go-deje"
-	state "github.com/DJDNS/go-deje/state"
+	"github.com/DJDNS/go-deje/document"
 )
 
 var host = flag.String("host", "localhost:8080", "Router to connect to")
 var topic = flag.String("topic", "deje://demo/", "DEJE topic to subscribe to")
 var filename = flag.String("file", "", "File to load from and save to")
+var realm = flag.String("realm", "", "WAMP v2 realm to join (default: use WAMP v1)")
+var listen = flag.String("listen", "", "Address to accept gossip peers on (no router needed)")
+var peers = flag.String("peers", "", "Comma-separated gossip peer addresses (no router needed)")
 
 func load(sc *deje.SimpleClient) {
 	if *filename == "" {
@@ -28,13 +32,19 @@ func load(sc *deje.SimpleClient) {
 	}
 	defer file.Close()
 
-	doc := sc.GetDoc()
-	if err = doc.Deserialize(file); err != nil {
+	sc.WithDocument(func(doc *document.Document) {
+		err = doc.Deserialize(file)
+	})
+	if err != nil {
 		log.Fatal(err)
 	}
 	log.Printf("Topic: %s", sc.GetTopic())
 	sc.ReTip()
-	log.Printf("Tip: %s", sc.Tip)
+	if tip := sc.GetTip(); tip != nil {
+		log.Printf("Tip: %s", tip.Hash())
+	} else {
+		log.Printf("Tip: none")
+	}
 }
 
 func save(sc *deje.SimpleClient) {
@@ -49,8 +59,10 @@ func save(sc *deje.SimpleClient) {
 	}
 	defer file.Close()
 
-	doc := sc.GetDoc()
-	if err = doc.Serialize(file); err != nil {
+	sc.WithDocument(func(doc *document.Document) {
+		err = doc.Serialize(file)
+	})
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -97,19 +109,40 @@ func main() {
 	io_loop_commander := make(chan string, 4)
 	io_loop_closer := make(Closer)
 
-	sc := deje.NewSimpleClient(*topic, logger)
+	var transport deje.Transport
+	if *listen != "" || *peers != "" {
+		var seeds []string
+		if *peers != "" {
+			seeds = strings.Split(*peers, ",")
+		}
+		transport = deje.NewGossipTransport(*listen, seeds)
+		url = ""
+	} else if *realm != "" {
+		transport = deje.NewWamp2Transport(*realm)
+	} else {
+		transport = deje.NewWamp1Transport()
+	}
+
+	sc := deje.NewSimpleClientWithTransport(*topic, transport, logger)
+	sc.SetConnectionStateCallback(func(state deje.ConnectionState) {
+		log.Printf("Connection is now %s", state)
+	})
 	if err := sc.Connect(url); err != nil {
 		log.Fatal(err)
 	} else {
-		log.Println("Connected to " + url)
+		log.Printf("Connected to '%s' (session %s)", url, transport.SessionID())
 		log.Printf("Listening to topic '%s'", *topic)
 	}
 
 	go io_ratelimit_loop(sc, io_loop_commander, io_loop_closer)
 	io_loop_commander <- "load"
-	sc.SetPrimitiveCallback(func(p state.Primitive) {
-		io_loop_commander <- "save"
-	})
+	// Don't hold up the network goroutine while the IO loop is busy
+	changes := sc.Subscribe(deje.NotifyPrimitive, 64)
+	go func() {
+		for range changes.C {
+			io_loop_commander <- "save"
+		}
+	}()
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
@@ -118,4 +151,7 @@ func main() {
 
 	io_loop_commander <- "close"
 	<-io_loop_closer
+	if err := sc.Close(); err != nil {
+		log.Println(err)
+	}
 }
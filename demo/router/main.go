@@ -1,15 +1,40 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"strings"
 
+	"github.com/DJDNS/go-deje"
 	"github.com/jcelliott/turnpike"
 )
 
+var serve = flag.String("serve", "", "Comma-separated DEJE topics to follow, and serve over HTTP at /documents/<host>/<path>")
+
+// Follow a topic through our own router, and serve its document.
+func follow(topic string) {
+	parsed, err := url.Parse(topic)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := log.New(os.Stderr, topic+": ", log.LstdFlags)
+	sc := deje.NewSimpleClient(topic, logger)
+	if err := sc.Connect("ws://localhost:8080/ws"); err != nil {
+		log.Fatal(err)
+	}
+
+	prefix := strings.TrimSuffix(path.Join("/documents", parsed.Host, parsed.Path), "/")
+	http.Handle(prefix+"/", http.StripPrefix(prefix, deje.NewHandler(sc)))
+	log.Println("Serving " + topic + " at " + prefix + "/")
+}
+
 func main() {
+	flag.Parse()
 	server := turnpike.NewServer()
 	gopath_dir := os.Getenv("GOPATH")
 	host_location := []string{
@@ -22,9 +47,24 @@ func main() {
 	http.Handle("/ws", server.Handler)
 	http.Handle("/", http.FileServer(http.Dir(static_path)))
 
+	// Listen first, since following topics means connecting to ourselves
+	listener, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		log.Fatal("Listen:", err)
+	}
 	log.Println("Listening on port 8080")
 	log.Println("Serving files from " + static_path)
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal("ListenAndServe:", err)
+	done := make(chan error)
+	go func() {
+		done <- http.Serve(listener, nil)
+	}()
+
+	if *serve != "" {
+		for _, topic := range strings.Split(*serve, ",") {
+			follow(topic)
+		}
+	}
+	if err := <-done; err != nil {
+		log.Fatal("Serve:", err)
 	}
 }
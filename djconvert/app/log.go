@@ -0,0 +1,282 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/timestamps"
+)
+
+// How many characters of each hash to show in graphs, at least. Like
+// git, more are shown when that's what it takes to tell the Events
+// apart, so a short hash can always be given back as a prefix.
+const minShortHashLength = 7
+
+// How many characters of each argument to show, before cutting it off.
+const argumentSummaryLength = 40
+
+// Print the history of an Event, or of the tip if hash_prefix is "".
+// With graph, draw every Event instead (or just the history, if
+// hash_prefix is given), with forks and all.
+func DoCommandLog(input io.Reader, output io.Writer, hash_prefix string, graph bool) error {
+	doc := document.NewDocument()
+	if err := doc.Deserialize(input); err != nil {
+		return err
+	}
+
+	// PeerTimestampServices never fail
+	tt := timestamps.NewTimestampTracker(&doc, timestamps.NewPeerTimestampService(&doc))
+	tip, _ := tt.FindLatest()
+
+	event := tip
+	if hash_prefix != "" {
+		var err error
+		event, err = doc.GetEventByPrefix(hash_prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	if graph {
+		var events []*document.Event
+		if hash_prefix != "" {
+			events = getHistory(event)
+		} else {
+			for _, ev := range doc.Events {
+				events = append(events, ev)
+			}
+		}
+		_, err := io.WriteString(output, drawGraph(&doc, events, tip))
+		return err
+	}
+	if event == nil {
+		return errors.New("Document has no tip, give an event hash")
+	}
+	_, err := io.WriteString(output, formatLog(&doc, getHistory(event)))
+	return err
+}
+
+// Like Event.GetHistory, but stops at the first missing parent,
+// instead of giving up.
+func getHistory(event *document.Event) []*document.Event {
+	history := []*document.Event{event}
+	for {
+		parent, ok := event.GetParent()
+		if !ok {
+			return history
+		}
+		history = append(history, parent)
+		event = parent
+	}
+}
+
+// Describe each Event in full, newest first, like git log.
+func formatLog(doc *document.Document, history []*document.Event) string {
+	positions := timestampPositions(doc)
+	entries := make([]string, len(history))
+	for i, event := range history {
+		parent := event.ParentHash
+		if parent == "" {
+			parent = "(none)"
+		}
+		timestamp := "(none)"
+		if position, ok := positions[event.Hash()]; ok {
+			timestamp = fmt.Sprintf("%d of %d", position+1, len(doc.Timestamps))
+		}
+
+		entries[i] = fmt.Sprintf(
+			"event %s\nParent:    %s\nHandler:   %s\nArguments: %s\nTimestamp: %s\n",
+			event.Hash(), parent, event.HandlerName,
+			summarizeArguments(event.Arguments), timestamp,
+		)
+	}
+	return strings.Join(entries, "\n")
+}
+
+// Where each Event was first timestamped, counting from 0.
+func timestampPositions(doc *document.Document) map[string]int {
+	positions := make(map[string]int)
+	for i, hash := range doc.Timestamps {
+		if _, ok := positions[hash]; !ok {
+			positions[hash] = i
+		}
+	}
+	return positions
+}
+
+// Sum up an Event's arguments on one line, as key=value pairs, where
+// each value is JSON, cut short if it's long.
+func summarizeArguments(args map[string]interface{}) string {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		// Arguments come from JSON, so they can always go back
+		value, _ := json.Marshal(args[key])
+		summary := string(value)
+		if runes := []rune(summary); len(runes) > argumentSummaryLength {
+			summary = string(runes[:argumentSummaryLength-3]) + "..."
+		}
+		pairs[i] = key + "=" + summary
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Draw the graph of the given Events, children above their parents,
+// like git log --graph. Each Event is a *, and each column of | is a
+// line of history, waiting for its next parent. Forks are where
+// columns come together.
+func drawGraph(doc *document.Document, events []*document.Event, tip *document.Event) string {
+	events = graphOrder(doc, events)
+	included := make(map[string]bool, len(events))
+	for _, event := range events {
+		included[event.Hash()] = true
+	}
+
+	short := shortHashLength(doc)
+	var lines []string
+	var columns []string // The hash each column is waiting for
+	for _, event := range events {
+		hash := event.Hash()
+		column := -1
+		for i := len(columns) - 1; i >= 0; i-- {
+			if columns[i] != hash {
+				continue
+			}
+			if column != -1 {
+				// Another child of this Event - fold it in
+				lines = append(lines, graphShift(len(columns), column, true))
+				columns = append(columns[:column], columns[column+1:]...)
+			}
+			column = i
+		}
+		if column == -1 {
+			// Nothing has led here yet - start a new column
+			column = len(columns)
+			columns = append(columns, hash)
+		}
+
+		line := graphColumns(len(columns), column) + "  " + shortHash(hash, short) +
+			" " + event.HandlerName
+		if summary := summarizeArguments(event.Arguments); summary != "" {
+			line += " " + summary
+		}
+		if tip != nil && hash == tip.Hash() {
+			line += " (tip)"
+		}
+		lines = append(lines, line)
+
+		if included[event.ParentHash] {
+			columns[column] = event.ParentHash
+		} else {
+			// The end of the line
+			if column != len(columns)-1 {
+				lines = append(lines, graphShift(len(columns), column, false))
+			}
+			columns = append(columns[:column], columns[column+1:]...)
+		}
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// How many characters it takes for every hash in the Document to have
+// its own prefix, or minShortHashLength if that's more. Hashes that
+// describe themselves share a header (see util.HashAlgorithm), so
+// this is usually a little longer than it is in git.
+func shortHashLength(doc *document.Document) int {
+	hashes := make([]string, 0, len(doc.Events))
+	for hash := range doc.Events {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	// Only neighbours in sorted order can share a longer prefix
+	length := minShortHashLength
+	for i := 1; i < len(hashes); i++ {
+		previous, hash := hashes[i-1], hashes[i]
+		common := 0
+		for common < len(previous) && common < len(hash) && previous[common] == hash[common] {
+			common++
+		}
+		if common+1 > length {
+			length = common + 1
+		}
+	}
+	return length
+}
+
+// The first length characters of a hash, or all of it, if it's short.
+func shortHash(hash string, length int) string {
+	if len(hash) < length {
+		return hash
+	}
+	return hash[:length]
+}
+
+// Children before parents, and otherwise in hash order. Events are
+// sorted by how far they are from the root (or from the oldest
+// known ancestor, when the history is incomplete), deepest first.
+func graphOrder(doc *document.Document, events []*document.Event) []*document.Event {
+	depths := make(map[string]int, len(events))
+	var depth func(event *document.Event) int
+	depth = func(event *document.Event) int {
+		hash := event.Hash()
+		if d, ok := depths[hash]; ok {
+			return d
+		}
+		d := 0
+		if parent, ok := doc.Events[event.ParentHash]; ok {
+			d = depth(parent) + 1
+		}
+		depths[hash] = d
+		return d
+	}
+
+	sorted := append([]*document.Event{}, events...)
+	sort.Slice(sorted, func(i, j int) bool {
+		depth_i, depth_j := depth(sorted[i]), depth(sorted[j])
+		if depth_i != depth_j {
+			return depth_i > depth_j
+		}
+		return sorted[i].Hash() < sorted[j].Hash()
+	})
+	return sorted
+}
+
+// A row of columns, with a * in the given one.
+func graphColumns(count, star int) string {
+	cells := make([]string, count)
+	for i := range cells {
+		cells[i] = "|"
+	}
+	cells[star] = "*"
+	return strings.Join(cells, " ")
+}
+
+// A row where the given column goes away, and the ones to its right
+// move over to fill the gap. If merge is true, the column joins the
+// one to its left, instead of just ending.
+func graphShift(count, removed int, merge bool) string {
+	line := []byte(strings.Repeat(" ", 2*count-1))
+	for i := 0; i < count; i++ {
+		if i < removed {
+			line[2*i] = '|'
+		} else if i > removed || merge {
+			line[2*i-1] = '/'
+		}
+	}
+	return strings.TrimRight(string(line), " ")
+}
@@ -0,0 +1,231 @@
+package app
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/stretchr/testify/assert"
+)
+
+// A document with a fork, and another line of history that's cut off:
+//
+//	a2   b1   o1
+//	|    |    |
+//	a1---+    orphan
+//	|         |
+//	root      (missing)
+func setupLogDocument(t *testing.T) (string, map[string]*document.Event) {
+	doc := document.NewDocument()
+	events := make(map[string]*document.Event)
+	add := func(name, handler, parent string, args map[string]interface{}) {
+		ev := doc.NewEvent(handler)
+		ev.Arguments = args
+		ev.ParentHash = parent
+		if parent_event, ok := events[parent]; ok {
+			ev.SetParent(*parent_event)
+		}
+		ev.Register()
+		events[name] = &ev
+	}
+	add("root", "SET", "", map[string]interface{}{"path": []interface{}{}, "value": "root"})
+	add("a1", "SET", "root", map[string]interface{}{"path": []interface{}{}, "value": "a1"})
+	add("a2", "SET", "a1", map[string]interface{}{
+		"path":  []interface{}{},
+		"value": strings.Repeat("long ", 20),
+	})
+	add("b1", "SET", "root", map[string]interface{}{})
+	add("orphan", "DELETE", "missing", map[string]interface{}{"path": []interface{}{"x"}})
+	add("o1", "SET", "orphan", map[string]interface{}{"path": []interface{}{"x"}, "value": 9})
+	doc.Timestamps = []string{
+		events["root"].Hash(), events["a1"].Hash(),
+		events["b1"].Hash(), events["a2"].Hash(),
+	}
+
+	var buf bytes.Buffer
+	if err := doc.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String(), events
+}
+
+func TestDoCommandLog(t *testing.T) {
+	input, events := setupLogDocument(t)
+	hash := func(name string) string { return events[name].Hash() }
+	short := func(name string) string { return hash(name)[:minShortHashLength] }
+
+	tests := []struct {
+		Input          string
+		HashPrefix     string
+		Graph          bool
+		ExpectedOutput string
+		ExpectedError  string
+	}{
+		// Bad input
+		{
+			"{{", "", false,
+			"",
+			"invalid character '{' looking for beginning of object key string",
+		},
+		// No tip
+		{
+			"{}", "", false,
+			"",
+			"Document has no tip, give an event hash",
+		},
+		// No such event
+		{
+			"{}", "Blasternaut", false,
+			"",
+			"No such event: 'Blasternaut'\n\nAvailable hashes (0):\n",
+		},
+		// History of the tip
+		{
+			input, "", false,
+			"event " + hash("a2") + "\n" +
+				"Parent:    " + hash("a1") + "\n" +
+				"Handler:   SET\n" +
+				`Arguments: path=[] value="long long long long long long long l...` + "\n" +
+				"Timestamp: 4 of 4\n" +
+				"\n" +
+				"event " + hash("a1") + "\n" +
+				"Parent:    " + hash("root") + "\n" +
+				"Handler:   SET\n" +
+				`Arguments: path=[] value="a1"` + "\n" +
+				"Timestamp: 2 of 4\n" +
+				"\n" +
+				"event " + hash("root") + "\n" +
+				"Parent:    (none)\n" +
+				"Handler:   SET\n" +
+				`Arguments: path=[] value="root"` + "\n" +
+				"Timestamp: 1 of 4\n",
+			"",
+		},
+		// History of a given event, which stops where it's cut off
+		{
+			input, hash("orphan")[:10], false,
+			"event " + hash("orphan") + "\n" +
+				"Parent:    missing\n" +
+				"Handler:   DELETE\n" +
+				`Arguments: path=["x"]` + "\n" +
+				"Timestamp: (none)\n",
+			"",
+		},
+		// Graph of everything, deepest first, then in hash order
+		{
+			input, "", true,
+			"*  " + short("a2") + ` SET path=[] value="long long long long long long long l... (tip)` + "\n" +
+				"| *  " + short("o1") + ` SET path=["x"] value=9` + "\n" +
+				"* |  " + short("a1") + ` SET path=[] value="a1"` + "\n" +
+				"| | *  " + short("b1") + " SET\n" +
+				"| * |  " + short("orphan") + ` DELETE path=["x"]` + "\n" +
+				"|  /\n" +
+				"|/\n" +
+				"*  " + short("root") + ` SET path=[] value="root"` + "\n",
+			"",
+		},
+		// Graph of a given event's history
+		{
+			input, hash("b1"), true,
+			"*  " + short("b1") + " SET\n" +
+				"*  " + short("root") + ` SET path=[] value="root"` + "\n",
+			"",
+		},
+		// Graph of an empty document
+		{
+			"{}", "", true,
+			"",
+			"",
+		},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		err := DoCommandLog(
+			strings.NewReader(test.Input),
+			buf,
+			test.HashPrefix,
+			test.Graph,
+		)
+
+		assert.Equal(t, test.ExpectedOutput, buf.String())
+		if test.ExpectedError == "" {
+			assert.NoError(t, err)
+		} else if assert.Error(t, err) {
+			assert.Equal(t, test.ExpectedError, err.Error())
+		}
+	}
+
+	// Failing to write
+	err := DoCommandLog(strings.NewReader(input), FakeWriter(0), "", false)
+	assert.EqualError(t, err, "Fails immediately")
+	err = DoCommandLog(strings.NewReader(input), FakeWriter(0), "", true)
+	assert.EqualError(t, err, "Fails immediately")
+}
+
+func TestShortHashLength(t *testing.T) {
+	tests := []struct {
+		Hashes []string
+		Length int
+	}{
+		{nil, minShortHashLength},
+		{[]string{"1220abcdef0123"}, minShortHashLength},
+		{[]string{"1220abcdef0123", "1220abcde10123"}, 10},
+		{[]string{"1220abc", "1220abcdef0123", "1220abd"}, 8},
+		{[]string{"aa582b4df04ba01a", "1220aa582b4df04b"}, minShortHashLength},
+	}
+	for _, test := range tests {
+		doc := document.NewDocument()
+		for _, hash := range test.Hashes {
+			doc.Events[hash] = &document.Event{}
+		}
+		assert.Equal(t, test.Length, shortHashLength(&doc), "%v", test.Hashes)
+	}
+
+	assert.Equal(t, "1220abc", shortHash("1220abc", 8))
+	assert.Equal(t, "1220abc", shortHash("1220abcdef", 7))
+}
+
+func TestSummarizeArguments(t *testing.T) {
+	tests := []struct {
+		Arguments map[string]interface{}
+		Summary   string
+	}{
+		{nil, ""},
+		{map[string]interface{}{"b": 2, "a": []interface{}{"x"}}, `a=["x"] b=2`},
+		{
+			map[string]interface{}{"value": strings.Repeat("x", 38)},
+			`value="` + strings.Repeat("x", 38) + `"`,
+		},
+		{
+			map[string]interface{}{"value": strings.Repeat("x", 39)},
+			`value="` + strings.Repeat("x", 36) + "...",
+		},
+		// Cut by character, not by byte
+		{
+			map[string]interface{}{"value": strings.Repeat("é", 39)},
+			`value="` + strings.Repeat("é", 36) + "...",
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.Summary, summarizeArguments(test.Arguments))
+	}
+}
+
+func TestMain_Log(t *testing.T) {
+	dir := setupTestDir(t)
+	defer removeTestDir(t, dir)
+	buf := new(bytes.Buffer)
+	stdout = buf
+	defer func() { stdout = os.Stdout }()
+
+	source := path.Join(dir, "doc_hello_world.json")
+	assert.NoError(t, Main([]string{"log", source, "aa58", "--graph"}, false))
+	assert.Equal(t,
+		"*  aa582b4 SET path=[] value={\"hello\":\"world\"} (tip)\n",
+		buf.String())
+
+	assert.Error(t, Main([]string{"log", path.Join(dir, "missing.json")}, false))
+}
@@ -7,23 +7,29 @@ import (
 	"github.com/docopt/docopt-go"
 )
 
-var version = "djconvert 0.0.13"
+var version = "djconvert 0.0.14"
 var usage_string = `djconvert - Converts files to and from DocCache format.
 
 Usage:
     djconvert up <source> <target> [--pretty]
     djconvert down <source> <target> <event-hash> [--pretty]
+    djconvert log <source> [<event-hash>] [--graph]
     djconvert -h | --help
     djconvert --version
 
 Options:
     --pretty      Pretty-print JSON in output file.
+    --graph       Draw every event in the document as a graph, or just
+                  the history of <event-hash>.
     -h --help     Show this message.
     --version     Show version info.
 `
 var do_help = true
 var options_first = false
 
+// Where the log command writes to.
+var stdout io.Writer = os.Stdout
+
 func Main(argv []string, exit bool) error {
 	do_help = exit
 	args, err := docopt.Parse(usage_string, argv, do_help, version, options_first, exit)
@@ -32,6 +38,16 @@ func Main(argv []string, exit bool) error {
 	}
 
 	input_filename := args["<source>"].(string)
+	if args["log"] == true {
+		input, err := os.Open(input_filename)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+		hash_prefix, _ := args["<event-hash>"].(string)
+		return DoCommandLog(input, stdout, hash_prefix, args["--graph"].(bool))
+	}
+
 	output_filename := args["<target>"].(string)
 	pretty := args["--pretty"].(bool)
 
@@ -48,7 +48,7 @@ func TestMain(t *testing.T) {
 				"{{ .Dir }}/doc.json",
 			},
 			false,
-			map[string]string{"doc.json": `{"events":{"aa582b4df04ba01af5205e702d4d16ed0b2c0705":{"parent":"","handler":"SET","args":{"path":[],"value":{"hello":"world"}}}},"timestamps":["aa582b4df04ba01af5205e702d4d16ed0b2c0705"]}` + "\n"},
+			map[string]string{"doc.json": `{"events":{"12203842bdb2aa6295c9d0f020d350c869951348a7e8a4b156a8df4a2a2e3fbb535d":{"parent":"","handler":"SET","args":{"path":[],"value":{"hello":"world"}}}},"timestamps":["12203842bdb2aa6295c9d0f020d350c869951348a7e8a4b156a8df4a2a2e3fbb535d"]}` + "\n"},
 		},
 		// Up pretty
 		{
@@ -60,7 +60,7 @@ func TestMain(t *testing.T) {
 			false,
 			map[string]string{"doc.json": `{
     "events": {
-        "aa582b4df04ba01af5205e702d4d16ed0b2c0705": {
+        "12203842bdb2aa6295c9d0f020d350c869951348a7e8a4b156a8df4a2a2e3fbb535d": {
             "parent": "",
             "handler": "SET",
             "args": {
@@ -72,7 +72,7 @@ func TestMain(t *testing.T) {
         }
     },
     "timestamps": [
-        "aa582b4df04ba01af5205e702d4d16ed0b2c0705"
+        "12203842bdb2aa6295c9d0f020d350c869951348a7e8a4b156a8df4a2a2e3fbb535d"
     ]
 }` + "\n"},
 		},
@@ -18,7 +18,7 @@ func TestDoCommandUp(t *testing.T) {
 
 	expected_output := `{
     "events": {
-        "41a772b775b1c4afbbfb42b7a91b3031a712ab42": {
+        "1220ea314240c4a2194dbe11ad1673533d07e2db09ac5ae5e8b0c02467f4223ad4b9": {
             "parent": "",
             "handler": "SET",
             "args": {
@@ -30,7 +30,7 @@ func TestDoCommandUp(t *testing.T) {
         }
     },
     "timestamps": [
-        "41a772b775b1c4afbbfb42b7a91b3031a712ab42"
+        "1220ea314240c4a2194dbe11ad1673533d07e2db09ac5ae5e8b0c02467f4223ad4b9"
     ]
 }` + "\n"
 	assert.NoError(t, DoCommandUp(reader, writer))
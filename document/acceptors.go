@@ -0,0 +1,246 @@
+package document
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+
+	"github.com/DJDNS/go-deje/state"
+)
+
+// What an acceptor actually signs, for a given Event hash.
+const votePrefix = "DEJE vote: "
+
+// A signed statement, by one of a Document's acceptors, that it
+// accepts an Event as part of the official history.
+//
+// Acceptors are hex-encoded ed25519 public keys, listed in the
+// Document's state (see Document.Acceptors).
+type Vote struct {
+	Event     string `json:"event"`
+	Acceptor  string `json:"acceptor"`
+	Signature string `json:"signature"`
+}
+
+// Sign a Vote for the Event with the given hash.
+func NewVote(hash string, key ed25519.PrivateKey) Vote {
+	public := key.Public().(ed25519.PublicKey)
+	return Vote{
+		Event:     hash,
+		Acceptor:  hex.EncodeToString(public),
+		Signature: hex.EncodeToString(ed25519.Sign(key, []byte(votePrefix+hash))),
+	}
+}
+
+// Check that the Vote was really signed by its Acceptor. This says
+// nothing about whether the Acceptor is one of a Document's acceptors.
+func (v Vote) Verify() error {
+	public, err := parseAcceptorKey(v.Acceptor)
+	if err != nil {
+		return err
+	}
+	signature, err := hex.DecodeString(v.Signature)
+	if err != nil || !ed25519.Verify(public, []byte(votePrefix+v.Event), signature) {
+		return errors.New("Bad vote signature")
+	}
+	return nil
+}
+
+func parseAcceptorKey(key string) (ed25519.PublicKey, error) {
+	public, err := hex.DecodeString(key)
+	if err != nil || len(public) != ed25519.PublicKeySize {
+		return nil, errors.New("Bad acceptor key: '" + key + "'")
+	}
+	return ed25519.PublicKey(public), nil
+}
+
+// Where the acceptors are listed in the Document's state.
+const acceptorsKey = "acceptors"
+
+// Get the acceptors listed in the "acceptors" permission, which is
+// optional.
+func (g Genesis) Acceptors() ([]string, error) {
+	listed, ok := g.Permissions[acceptorsKey]
+	if !ok {
+		return nil, nil
+	}
+	return parseAcceptors(listed)
+}
+
+// Parse a list of acceptors, as found in a genesis or the state.
+func parseAcceptors(listed interface{}) ([]string, error) {
+	list, ok := listed.([]interface{})
+	if !ok {
+		return nil, errors.New("Bad acceptors list")
+	}
+	acceptors := make([]string, len(list))
+	for i, item := range list {
+		key, ok := item.(string)
+		if !ok {
+			return nil, errors.New("Bad acceptors list")
+		}
+		if _, err := parseAcceptorKey(key); err != nil {
+			return nil, err
+		}
+		acceptors[i] = key
+	}
+	return acceptors, nil
+}
+
+// Get the acceptors in charge of the Event with the given hash. They're
+// listed under "acceptors" in the Document's state, as the Event found
+// it (at its parent), so Events can change the acceptors like any other
+// content, but only with the approval of the ones before them. The
+// GENESIS Event lists the first acceptors, and is in their charge
+// itself. A list that isn't valid means there are no acceptors.
+//
+// Documents without a genesis have none. Otherwise, ok is false if
+// there's no telling yet, because the Event, or some of its history,
+// hasn't turned up.
+func (doc *Document) Acceptors(hash string) (acceptors []string, ok bool) {
+	if doc.GenesisHash == "" {
+		return nil, true
+	}
+	ev, known := doc.Events[hash]
+	if !known || !ev.HasHistory() {
+		return nil, false
+	}
+	if !ev.DescendsFromGenesis() {
+		return nil, true
+	}
+	base := ev.ParentHash
+	if hash == doc.GenesisHash {
+		base = hash
+	}
+	acceptors, _ = parseAcceptors(doc.acceptorsAfter(base))
+	return acceptors, true
+}
+
+// Get the "acceptors" entry of the state an Event leaves behind. Only
+// primitives that touch it can change it, so it's worked out from the
+// parent's, and remembered. The Event's history must be complete.
+func (doc *Document) acceptorsAfter(hash string) interface{} {
+	if doc.acceptors == nil {
+		doc.acceptors = make(map[string]interface{})
+	}
+
+	// Walk back to something already worked out, or a root
+	var path []*Event
+	var listed interface{}
+	for current := hash; current != ""; {
+		if known, ok := doc.acceptors[current]; ok {
+			listed = known
+			break
+		}
+		ev := doc.Events[current]
+		path = append(path, ev)
+		current = ev.ParentHash
+	}
+
+	// Fill in from the oldest
+	for i := len(path) - 1; i >= 0; i-- {
+		listed = applyToAcceptors(listed, *path[i])
+		doc.acceptors[path[i].GetKey()] = listed
+	}
+	return listed
+}
+
+// Apply the primitives of an Event that touch the "acceptors" entry of
+// the state to it. Events that can't be applied change nothing.
+func applyToAcceptors(listed interface{}, ev Event) interface{} {
+	primitives, err := ev.getPrimitives()
+	if err != nil {
+		return listed
+	}
+	for _, primitive := range primitives {
+		var path []interface{}
+		switch p := primitive.(type) {
+		case *state.SetPrimitive:
+			path = p.Path
+		case *state.DeletePrimitive:
+			path = p.Path
+		}
+		if len(path) != 0 && path[0] != acceptorsKey {
+			continue
+		}
+
+		scratch := state.NewDocumentState()
+		if listed != nil {
+			scratch.Apply(&state.SetPrimitive{
+				Path:  []interface{}{acceptorsKey},
+				Value: listed,
+			})
+		}
+		scratch.Apply(primitive)
+		root, _ := scratch.Export().(map[string]interface{})
+		listed = root[acceptorsKey]
+	}
+	return listed
+}
+
+// Whether the given key is one of the acceptors in charge of the Event
+// with the given hash.
+func (doc *Document) IsAcceptor(hash, key string) bool {
+	acceptors, _ := doc.Acceptors(hash)
+	for _, acceptor := range acceptors {
+		if acceptor == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Store a Vote, if it has a good signature, and its Acceptor is one of
+// the acceptors in charge of its Event. That can't be checked for
+// Events that haven't turned up yet, so Votes for them are refused too.
+// Documents without a genesis have no acceptors, so they take no votes.
+//
+// Adding the same Vote again is harmless.
+func (doc *Document) AddVote(v Vote) error {
+	if err := v.Verify(); err != nil {
+		return err
+	}
+	if _, ok := doc.Acceptors(v.Event); !ok {
+		return errors.New("Vote for unknown event: '" + v.Event + "'")
+	}
+	if !doc.IsAcceptor(v.Event, v.Acceptor) {
+		return errors.New("Not an acceptor: '" + v.Acceptor + "'")
+	}
+	if doc.Votes == nil {
+		doc.Votes = make(map[string]map[string]Vote)
+	}
+	if doc.Votes[v.Event] == nil {
+		doc.Votes[v.Event] = make(map[string]Vote)
+	}
+	doc.Votes[v.Event][v.Acceptor] = v
+	return nil
+}
+
+// Count the votes for an Event, from the acceptors in charge of it.
+func (doc *Document) VoteCount(hash string) int {
+	var count int
+	votes := doc.Votes[hash]
+	acceptors, _ := doc.Acceptors(hash)
+	for _, acceptor := range acceptors {
+		if _, ok := votes[acceptor]; ok {
+			count++
+		}
+	}
+	return count
+}
+
+// How many votes an Event needs to be accepted: a majority of the
+// acceptors in charge of it. This is 0 if there are none.
+func (doc *Document) Quorum(hash string) int {
+	acceptors, _ := doc.Acceptors(hash)
+	if len(acceptors) == 0 {
+		return 0
+	}
+	return len(acceptors)/2 + 1
+}
+
+// Whether enough of the acceptors in charge of an Event have voted for
+// it. Every Event has quorum if there are no acceptors.
+func (doc *Document) HasQuorum(hash string) bool {
+	return doc.VoteCount(hash) >= doc.Quorum(hash)
+}
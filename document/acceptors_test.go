@@ -0,0 +1,267 @@
+package document
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Deterministic keys, so tests don't depend on randomness.
+func acceptorKey(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+func acceptorID(key ed25519.PrivateKey) string {
+	return hex.EncodeToString(key.Public().(ed25519.PublicKey))
+}
+
+// Create a genesis listing the given acceptors.
+func setupAcceptors(t *testing.T, d *Document, keys ...ed25519.PrivateKey) *Event {
+	acceptors := make([]interface{}, len(keys))
+	for i, key := range keys {
+		acceptors[i] = acceptorID(key)
+	}
+	genesis, err := d.CreateGenesis(Genesis{
+		Topic:       "deje://example.com/",
+		Permissions: map[string]interface{}{"acceptors": acceptors},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return genesis
+}
+
+func TestVote_Verify(t *testing.T) {
+	key := acceptorKey(1)
+	vote := NewVote("some hash", key)
+	assert.Equal(t, "some hash", vote.Event)
+	assert.Equal(t, acceptorID(key), vote.Acceptor)
+	assert.NoError(t, vote.Verify())
+
+	forged := vote
+	forged.Event = "other hash"
+	wrong_key := vote
+	wrong_key.Acceptor = acceptorID(acceptorKey(2))
+	not_hex := vote
+	not_hex.Signature = "xyz"
+	bad_key := vote
+	bad_key.Acceptor = "abcd"
+
+	tests := []struct {
+		Vote  Vote
+		Error string
+	}{
+		{forged, "Bad vote signature"},
+		{wrong_key, "Bad vote signature"},
+		{not_hex, "Bad vote signature"},
+		{bad_key, "Bad acceptor key: 'abcd'"},
+	}
+	for _, test := range tests {
+		assert.EqualError(t, test.Vote.Verify(), test.Error)
+	}
+}
+
+func TestGenesis_Acceptors(t *testing.T) {
+	valid := acceptorID(acceptorKey(1))
+	tests := []struct {
+		Permissions map[string]interface{}
+		Acceptors   []string
+		Error       string
+	}{
+		{nil, nil, ""},
+		{map[string]interface{}{"acceptors": []interface{}{}}, []string{}, ""},
+		{map[string]interface{}{"acceptors": []interface{}{valid}}, []string{valid}, ""},
+		{map[string]interface{}{"acceptors": valid}, nil, "Bad acceptors list"},
+		{map[string]interface{}{"acceptors": []interface{}{5}}, nil, "Bad acceptors list"},
+		{map[string]interface{}{"acceptors": []interface{}{"zz"}}, nil, "Bad acceptor key: 'zz'"},
+	}
+	for _, test := range tests {
+		acceptors, err := Genesis{Permissions: test.Permissions}.Acceptors()
+		assert.Equal(t, test.Acceptors, acceptors)
+		if test.Error != "" {
+			assert.EqualError(t, err, test.Error)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	// Bad acceptors make a bad GENESIS Event
+	d := NewDocument()
+	_, err := d.CreateGenesis(Genesis{
+		Topic:       "deje://example.com/",
+		Permissions: map[string]interface{}{"acceptors": "nope"},
+	}, nil)
+	assert.EqualError(t, err, "Bad acceptors list")
+}
+
+func TestDocument_Votes(t *testing.T) {
+	keys := []ed25519.PrivateKey{acceptorKey(1), acceptorKey(2), acceptorKey(3)}
+	outsider := acceptorKey(4)
+
+	d := NewDocument()
+	acceptors, ok := d.Acceptors("anything")
+	assert.Nil(t, acceptors)
+	assert.True(t, ok, "No genesis, no acceptors")
+	assert.Equal(t, 0, d.Quorum("anything"))
+	assert.True(t, d.HasQuorum("anything"), "No acceptors, no requirements")
+
+	genesis := setupAcceptors(t, &d, keys...)
+	child := addChild(&d, "SET", genesis)
+	hash := child.Hash()
+	acceptors, ok = d.Acceptors(hash)
+	assert.Equal(t, []string{acceptorID(keys[0]), acceptorID(keys[1]), acceptorID(keys[2])},
+		acceptors)
+	assert.True(t, ok)
+	assert.True(t, d.IsAcceptor(hash, acceptorID(keys[1])))
+	assert.False(t, d.IsAcceptor(hash, acceptorID(outsider)))
+	assert.Equal(t, 2, d.Quorum(hash))
+	assert.False(t, d.HasQuorum(hash))
+
+	assert.EqualError(t, d.AddVote(NewVote(hash, outsider)),
+		"Not an acceptor: '"+acceptorID(outsider)+"'")
+	assert.Len(t, d.Votes[hash], 0, "Outsiders' votes aren't kept")
+	assert.NoError(t, d.AddVote(NewVote(hash, keys[0])))
+	assert.NoError(t, d.AddVote(NewVote(hash, keys[0])), "Adding again is harmless")
+	assert.NoError(t, d.AddVote(NewVote(genesis.Hash(), keys[1])))
+	assert.Equal(t, 1, d.VoteCount(hash), "Other events don't count")
+	assert.False(t, d.HasQuorum(hash))
+
+	forged := NewVote(hash, keys[2])
+	forged.Acceptor = acceptorID(keys[1])
+	assert.EqualError(t, d.AddVote(forged), "Bad vote signature")
+	assert.Equal(t, 1, d.VoteCount(hash))
+
+	assert.NoError(t, d.AddVote(NewVote(hash, keys[2])))
+	assert.Equal(t, 2, d.VoteCount(hash))
+	assert.True(t, d.HasQuorum(hash))
+
+	// Votes for Events that haven't turned up can't be checked
+	_, ok = d.Acceptors("missing")
+	assert.False(t, ok)
+	assert.EqualError(t, d.AddVote(NewVote("missing", keys[0])),
+		"Vote for unknown event: 'missing'")
+	assert.Len(t, d.Votes["missing"], 0)
+
+	// Or if the votes have been cleared out
+	d.Votes = nil
+	assert.NoError(t, d.AddVote(NewVote(hash, keys[0])))
+	assert.Equal(t, 1, d.VoteCount(hash))
+
+	// Without a genesis, there are no acceptors to take votes from
+	var blank Document
+	assert.EqualError(t, blank.AddVote(NewVote(hash, keys[0])),
+		"Not an acceptor: '"+acceptorID(keys[0])+"'")
+	assert.Len(t, blank.Votes, 0)
+}
+
+// Acceptors live in the state, so Events can change them, but an Event
+// is in the charge of the acceptors before it.
+func TestDocument_Acceptors_State(t *testing.T) {
+	keys := []ed25519.PrivateKey{acceptorKey(1), acceptorKey(2), acceptorKey(3)}
+	d := NewDocument()
+	genesis := setupAcceptors(t, &d, keys[0])
+	assert.NoError(t, genesis.Goto())
+	assert.Equal(t, map[string]interface{}{
+		"acceptors": []interface{}{acceptorID(keys[0])},
+	}, d.State.Export())
+
+	set := func(parent *Event, path []interface{}, value interface{}) *Event {
+		ev := d.NewEvent("SET")
+		ev.SetParent(*parent)
+		ev.Arguments["path"] = path
+		ev.Arguments["value"] = value
+		ev.Register()
+		return &ev
+	}
+	acceptors := func(ev *Event) []string {
+		acceptors, ok := d.Acceptors(ev.Hash())
+		assert.True(t, ok)
+		return acceptors
+	}
+	added := set(genesis, []interface{}{"acceptors", 1}, acceptorID(keys[1]))
+	unrelated := set(added, []interface{}{"other"}, "value")
+	replaced := set(unrelated, []interface{}{},
+		map[string]interface{}{"acceptors": []interface{}{acceptorID(keys[2])}})
+	after := addChild(&d, "SET", replaced)
+	deleted := d.NewEvent("DELETE")
+	deleted.SetParent(*after)
+	deleted.Arguments["path"] = []interface{}{"acceptors"}
+	deleted.Register()
+	broken := set(&deleted, []interface{}{"acceptors"}, "not a list")
+	last := addChild(&d, "SET", broken)
+
+	tests := []struct {
+		Event     *Event
+		Acceptors []string
+	}{
+		{genesis, []string{acceptorID(keys[0])}},
+		{added, []string{acceptorID(keys[0])}},
+		{unrelated, []string{acceptorID(keys[0]), acceptorID(keys[1])}},
+		{replaced, []string{acceptorID(keys[0]), acceptorID(keys[1])}},
+		{after, []string{acceptorID(keys[2])}},
+		{&deleted, []string{acceptorID(keys[2])}},
+		{broken, nil},
+		{last, nil},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.Acceptors, acceptors(test.Event), "Event %d", i)
+	}
+
+	// Events outside the genesis's history have none
+	stray := addChild(&d, "SET", nil)
+	assert.Nil(t, acceptors(stray))
+	orphan := d.NewEvent("SET")
+	orphan.ParentHash = "missing"
+	orphan.Register()
+	_, ok := d.Acceptors(orphan.Hash())
+	assert.False(t, ok, "History is incomplete")
+}
+
+func TestDocument_Votes_Serialization(t *testing.T) {
+	key := acceptorKey(1)
+	source := NewDocument()
+	genesis := setupAcceptors(t, &source, key)
+	child := addChild(&source, "SET", genesis)
+	if !assert.NoError(t, source.AddVote(NewVote(child.Hash(), key))) {
+		return
+	}
+	// Slips past AddVote, but shouldn't survive a round trip
+	forged := NewVote(genesis.Hash(), key)
+	forged.Signature = "00"
+	source.Votes[genesis.Hash()] = map[string]Vote{forged.Acceptor: forged}
+
+	var buffer bytes.Buffer
+	if !assert.NoError(t, source.Serialize(&buffer)) {
+		return
+	}
+	dest := NewDocument()
+	if !assert.NoError(t, dest.Deserialize(&buffer)) {
+		return
+	}
+	assert.True(t, dest.IsAcceptor(child.Hash(), acceptorID(key)))
+	assert.Equal(t, 1, dest.VoteCount(child.Hash()))
+	assert.Equal(t, 0, dest.VoteCount(genesis.Hash()))
+	assert.Len(t, dest.Votes, 1)
+
+	// Votes from outsiders don't survive either
+	buffer.Reset()
+	outsider := NewVote(child.Hash(), acceptorKey(2))
+	source.Votes[child.Hash()][outsider.Acceptor] = outsider
+	if !assert.NoError(t, source.Serialize(&buffer)) {
+		return
+	}
+	dest = NewDocument()
+	if assert.NoError(t, dest.Deserialize(&buffer)) {
+		assert.Len(t, dest.Votes[child.Hash()], 1)
+	}
+
+	// Documents without votes serialize as before
+	buffer.Reset()
+	plain := NewDocument()
+	if assert.NoError(t, plain.Serialize(&buffer)) {
+		assert.NotContains(t, buffer.String(), "votes")
+	}
+}
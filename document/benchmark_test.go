@@ -0,0 +1,83 @@
+package document
+
+import (
+	"strconv"
+	"testing"
+)
+
+// An Event with enough arguments to make serializing it take a while.
+func benchmarkEvent(d *Document) Event {
+	ev := d.NewEvent("SET")
+	ev.Arguments["path"] = []interface{}{"items"}
+	items := make(map[string]interface{})
+	for i := 0; i < 100; i++ {
+		items["item "+strconv.Itoa(i)] = map[string]interface{}{
+			"index": float64(i),
+			"tags":  []interface{}{"a", "b", "c"},
+		}
+	}
+	ev.Arguments["value"] = items
+	return ev
+}
+
+// A chain of Events, with a single-Event fork off of the root.
+func benchmarkChain(length int) (tip, fork *Event) {
+	d := NewDocument()
+	root := addChild(&d, "root", nil)
+	fork = addChild(&d, "fork", root)
+	tip = root
+	for i := 1; i < length; i++ {
+		tip = addChild(&d, strconv.Itoa(i), tip)
+	}
+	return tip, fork
+}
+
+func BenchmarkEvent_Hash_Unregistered(b *testing.B) {
+	d := NewDocument()
+	ev := benchmarkEvent(&d)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ev.Hash()
+	}
+}
+
+func BenchmarkEvent_Hash_Registered(b *testing.B) {
+	d := NewDocument()
+	ev := benchmarkEvent(&d)
+	ev.Register()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ev.Hash()
+	}
+}
+
+func BenchmarkEvent_Register(b *testing.B) {
+	d := NewDocument()
+	ev := benchmarkEvent(&d)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ev.Register()
+		ev.Unregister()
+	}
+}
+
+func BenchmarkEvent_GetCommonAncestor(b *testing.B) {
+	for _, length := range []int{100, 10000} {
+		b.Run(strconv.Itoa(length), func(b *testing.B) {
+			tip, fork := benchmarkChain(length)
+			tip.GetCommonAncestor(fork) // Warm up the index
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tip.GetCommonAncestor(fork)
+			}
+		})
+	}
+}
+
+func BenchmarkEvent_GetHistory(b *testing.B) {
+	tip, _ := benchmarkChain(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tip.GetHistory()
+	}
+}
@@ -0,0 +1,123 @@
+package document
+
+import (
+	"errors"
+	"sort"
+)
+
+// A point in history where more than one Event shares a parent, so
+// at most one of them can end up as part of the tip's history.
+type Fork struct {
+	// The shared parent, or "" for competing root Events.
+	ParentHash string
+
+	// The competing Events, sorted by hash.
+	Children []*Event
+}
+
+// Get the contents of an EventSet, sorted by hash.
+func (es EventSet) Sorted() []*Event {
+	hashes := make([]string, 0, len(es))
+	for hash := range es {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	events := make([]*Event, len(hashes))
+	for i, hash := range hashes {
+		events[i] = es[hash]
+	}
+	return events
+}
+
+// Get every Event that has no children, sorted by hash. These are the
+// ends of each branch, and the only candidates for a new tip that
+// doesn't throw anything away.
+func (doc *Document) Leaves() []*Event {
+	leaves := make(EventSet)
+	for hash, ev := range doc.Events {
+		if len(doc.EventsByParent[hash]) == 0 {
+			leaves[hash] = ev
+		}
+	}
+	return leaves.Sorted()
+}
+
+// Get every point where history branches, sorted by parent hash.
+func (doc *Document) Forks() []Fork {
+	parents := make([]string, 0)
+	for parent_hash, children := range doc.EventsByParent {
+		if len(children) > 1 {
+			parents = append(parents, parent_hash)
+		}
+	}
+	sort.Strings(parents)
+
+	forks := make([]Fork, len(parents))
+	for i, parent_hash := range parents {
+		forks[i] = Fork{
+			ParentHash: parent_hash,
+			Children:   doc.EventsByParent[parent_hash].Sorted(),
+		}
+	}
+	return forks
+}
+
+// Get the branch that an Event is on, oldest first: every Event from
+// just after the most recent Fork in its history, up to and including
+// the Event itself. If there are no Forks in its history, this is the
+// whole history.
+func (doc *Document) BranchFrom(hash string) ([]*Event, error) {
+	ev, ok := doc.Events[hash]
+	if !ok {
+		return nil, errors.New("Unknown event hash")
+	}
+
+	branch := []*Event{ev}
+	for ev.ParentHash != "" && len(doc.EventsByParent[ev.ParentHash]) == 1 {
+		parent, ok := doc.Events[ev.ParentHash]
+		if !ok {
+			return nil, errors.New("Could not get parent")
+		}
+		branch = append(branch, parent)
+		ev = parent
+	}
+
+	// Collected newest first
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch, nil
+}
+
+// Get how many ancestors an Event has. Root Events have a depth of 0.
+func (doc *Document) Depth(hash string) (int, error) {
+	ev, ok := doc.Events[hash]
+	if !ok {
+		return 0, errors.New("Unknown event hash")
+	}
+	entry, ok := doc.indexEvent(ev, hash)
+	if !ok {
+		return 0, errors.New("Could not get parent")
+	}
+	return entry.depth, nil
+}
+
+// Get every Event descended from the given hash, not including the
+// Event itself. Use "" to get every Event with a complete history.
+//
+// Events are breadth-first, so each one comes after its parent.
+// Siblings are sorted by hash.
+func (doc *Document) Descendants(hash string) []*Event {
+	descendants := make([]*Event, 0)
+	queue := []string{hash}
+	for len(queue) > 0 {
+		children := doc.EventsByParent[queue[0]].Sorted()
+		queue = queue[1:]
+		for _, child := range children {
+			descendants = append(descendants, child)
+			queue = append(queue, child.Hash())
+		}
+	}
+	return descendants
+}
@@ -0,0 +1,166 @@
+package document
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Build and register a child Event, with a handler name for telling
+// them apart.
+func addChild(d *Document, name string, parent *Event) *Event {
+	ev := d.NewEvent(name)
+	if parent != nil {
+		ev.SetParent(*parent)
+	}
+	ev.Register()
+	return &ev
+}
+
+func sortedByHash(events ...*Event) []*Event {
+	events = append([]*Event{}, events...)
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Hash() < events[j].Hash()
+	})
+	return events
+}
+
+// Make sure every Event comes after its parent, if its parent is there.
+func assertParentsFirst(t *testing.T, events []*Event) {
+	seen := make(map[string]bool)
+	for _, ev := range events {
+		for _, other := range events {
+			if other.Hash() == ev.ParentHash {
+				assert.True(t, seen[ev.ParentHash], "Parent should come first")
+			}
+		}
+		seen[ev.Hash()] = true
+	}
+}
+
+func TestDocument_Branches(t *testing.T) {
+	d := NewDocument()
+	root := addChild(&d, "root", nil)
+	r2 := addChild(&d, "r2", nil)
+	a1 := addChild(&d, "a1", root)
+	a2 := addChild(&d, "a2", a1)
+	a3 := addChild(&d, "a3", a2)
+	b1 := addChild(&d, "b1", root)
+	b2 := addChild(&d, "b2", b1)
+	c2 := addChild(&d, "c2", b1)
+	orphan := d.NewEvent("orphan")
+	orphan.ParentHash = "missing"
+	orphan.Register()
+
+	assert.Equal(t, sortedByHash(a3, b2, c2, &orphan, r2), d.Leaves())
+	assert.Equal(t, []Fork{
+		{"", sortedByHash(root, r2)},
+		{root.Hash(), sortedByHash(a1, b1)},
+		{b1.Hash(), sortedByHash(b2, c2)},
+	}, d.Forks())
+
+	branch_tests := []struct {
+		Hash   string
+		Branch []*Event
+		Error  string
+	}{
+		{a3.Hash(), []*Event{a1, a2, a3}, ""},
+		{a1.Hash(), []*Event{a1}, ""},
+		{b1.Hash(), []*Event{b1}, ""},
+		{c2.Hash(), []*Event{c2}, ""},
+		{root.Hash(), []*Event{root}, ""},
+		{orphan.Hash(), nil, "Could not get parent"},
+		{"unknown", nil, "Unknown event hash"},
+	}
+	for _, test := range branch_tests {
+		branch, err := d.BranchFrom(test.Hash)
+		if test.Error != "" {
+			assert.EqualError(t, err, test.Error)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, test.Branch, branch)
+	}
+
+	depth_tests := []struct {
+		Hash  string
+		Depth int
+		Error string
+	}{
+		{root.Hash(), 0, ""},
+		{r2.Hash(), 0, ""},
+		{a3.Hash(), 3, ""},
+		{c2.Hash(), 2, ""},
+		{orphan.Hash(), 0, "Could not get parent"},
+		{"unknown", 0, "Unknown event hash"},
+	}
+	for _, test := range depth_tests {
+		depth, err := d.Depth(test.Hash)
+		if test.Error != "" {
+			assert.EqualError(t, err, test.Error)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, test.Depth, depth)
+	}
+
+	assert.Equal(t, sortedByHash(b2, c2), d.Descendants(b1.Hash()))
+	assert.Equal(t, []*Event{}, d.Descendants(a3.Hash()))
+	assert.Equal(t, []*Event{&orphan}, d.Descendants("missing"))
+	all := d.Descendants(root.Hash())
+	assert.Len(t, all, 6)
+	assertParentsFirst(t, all)
+	all = d.Descendants("")
+	assert.Len(t, all, 8, "Everything except the orphan")
+	assertParentsFirst(t, all)
+}
+
+func TestDocument_Branches_Large(t *testing.T) {
+	const length = 5000
+	const fork_every = 100
+	const side_length = 10
+
+	// A long trunk, with a side branch sprouting every so often
+	d := NewDocument()
+	trunk := make([]*Event, length)
+	var side_leaves []*Event
+	var parent *Event
+	for i := range trunk {
+		trunk[i] = addChild(&d, "trunk "+strconv.Itoa(i), parent)
+		parent = trunk[i]
+		if i%fork_every == 0 && i > 0 {
+			side := trunk[i-1]
+			for j := 0; j < side_length; j++ {
+				side = addChild(&d, "side "+strconv.Itoa(i)+" "+strconv.Itoa(j), side)
+			}
+			side_leaves = append(side_leaves, side)
+		}
+	}
+	tip := trunk[length-1]
+	num_forks := len(side_leaves)
+	total := length + num_forks*side_length
+
+	assert.Equal(t, sortedByHash(append(side_leaves, tip)...), d.Leaves())
+	assert.Len(t, d.Forks(), num_forks)
+
+	depth, err := d.Depth(tip.Hash())
+	assert.NoError(t, err)
+	assert.Equal(t, length-1, depth)
+	depth, err = d.Depth(side_leaves[0].Hash())
+	assert.NoError(t, err)
+	assert.Equal(t, fork_every-1+side_length, depth)
+
+	branch, err := d.BranchFrom(tip.Hash())
+	assert.NoError(t, err)
+	last_fork := (length - 1) / fork_every * fork_every
+	assert.Equal(t, trunk[last_fork:], branch)
+	branch, err = d.BranchFrom(side_leaves[0].Hash())
+	assert.NoError(t, err)
+	assert.Len(t, branch, side_length)
+
+	descendants := d.Descendants(trunk[0].Hash())
+	assert.Len(t, descendants, total-1)
+	assert.Len(t, d.Descendants(""), total)
+}
@@ -5,7 +5,7 @@
 // applied to reconstruct the contents of a document, see the
 // go-deje/state package.
 //
-//   The history model works as follows:
+// The history model works as follows:
 //
 // Events act like commits, expressing a change/delta from a parent
 // state. They can be simple primitives, or complex custom events,
@@ -5,6 +5,7 @@ import (
 	"io"
 
 	"github.com/DJDNS/go-deje/state"
+	"github.com/DJDNS/go-deje/util"
 )
 
 // A document is a single managed DEJE object, associated with
@@ -18,6 +19,11 @@ import (
 type Document struct {
 	State *state.DocumentState `json:"-"`
 
+	// How new Events are hashed. Changing this doesn't affect Events
+	// that are already registered, and since hashes describe their
+	// own algorithm, parents and children don't have to agree.
+	HashAlgorithm util.HashAlgorithm `json:"-"`
+
 	// Do not modify the contents of the following fields!
 	// They're there for you to have convenient and uninhibited
 	// READ-ONLY access. If you try to add or remove things manually,
@@ -28,15 +34,35 @@ type Document struct {
 	Events         EventSet            `json:"events"`
 	EventsByParent map[string]EventSet `json:"-"`
 	Timestamps     []string            `json:"timestamps"`
+
+	// The hash of the GENESIS Event, which is also the Document's ID.
+	// Documents without one accept any root Event. See Genesis.
+	GenesisHash string `json:"genesis,omitempty"`
+
+	// Votes for Events, by Event hash, then by acceptor. Use AddVote.
+	Votes map[string]map[string]Vote `json:"votes,omitempty"`
+
+	// The "acceptors" entry of the state after each Event, by hash,
+	// filled in as needed. See Acceptors.
+	acceptors map[string]interface{}
+
+	// Where each Event sits in history, filled in as needed.
+	index map[string]*indexEntry
+
+	// Every Event's hash, in order, for GetEventByPrefix. Built as
+	// needed.
+	hashes *hashIndex
 }
 
 // Create a new, blank Document, with fields initialized.
 func NewDocument() Document {
 	return Document{
 		State:          state.NewDocumentState(),
+		HashAlgorithm:  util.DefaultHashAlgorithm,
 		Events:         make(EventSet),
 		EventsByParent: make(map[string]EventSet),
 		Timestamps:     make([]string, 0),
+		Votes:          make(map[string]map[string]Vote),
 	}
 }
 
@@ -47,6 +73,11 @@ func (doc *Document) Serialize(w io.Writer) error {
 }
 
 // Deserialize JSON data from an io.Reader.
+//
+// Events are kept under their keys if those really are their hashes,
+// whatever the algorithm (including util.LegacySHA1). Otherwise, they
+// are registered under a fresh hash, made with doc.HashAlgorithm.
+// Votes with bad signatures, or from non-acceptors, are dropped.
 func (doc *Document) Deserialize(r io.Reader) error {
 	decoder := json.NewDecoder(r)
 	if err := decoder.Decode(doc); err != nil {
@@ -56,16 +87,87 @@ func (doc *Document) Deserialize(r io.Reader) error {
 	// Copy Events to avoid clobbering when we fix keys
 	var index int
 	events_copy := make([]Event, len(doc.Events))
-	for _, item := range doc.Events {
+	for key, item := range doc.Events {
 		events_copy[index] = *item
+		if util.VerifyHash(key, *item) {
+			events_copy[index].hash = key
+		}
 		index++
 	}
 	doc.Events = make(EventSet)
+	doc.index = nil
+	doc.hashes = nil
+	doc.acceptors = nil
 
 	// Integrate through registration
 	for i := range events_copy {
 		events_copy[i].Doc = doc
 		events_copy[i].Register()
 	}
+
+	// Votes are checked against the acceptors, who need the genesis
+	votes := doc.Votes
+	doc.Votes = make(map[string]map[string]Vote)
+	if doc.GenesisHash != "" {
+		if err := doc.SetGenesis(doc.GenesisHash); err != nil {
+			return err
+		}
+	}
+	for _, by_acceptor := range votes {
+		for _, vote := range by_acceptor {
+			doc.AddVote(vote)
+		}
+	}
 	return nil
 }
+
+// Re-register Events under the hashes that Timestamps and other
+// Events' ParentHashes know them by, where those hashes were made with
+// a different algorithm than doc.HashAlgorithm. Returns how many Events
+// were re-registered.
+//
+// Peers send Events without their keys, so Events from a document
+// with util.LegacySHA1 hashes (and no genesis to say so) would
+// otherwise be registered under hashes nothing refers to.
+func (doc *Document) Rekey() int {
+	foreign := make(map[util.HashAlgorithm]map[string]bool)
+	refer := func(hash string) {
+		if _, known := doc.Events[hash]; known || hash == "" {
+			return
+		}
+		algorithm, err := util.ParseHash(hash)
+		if err != nil || algorithm == doc.HashAlgorithm {
+			return
+		}
+		if foreign[algorithm] == nil {
+			foreign[algorithm] = make(map[string]bool)
+		}
+		foreign[algorithm][hash] = true
+	}
+	for _, hash := range doc.Timestamps {
+		refer(hash)
+	}
+	for _, ev := range doc.Events {
+		refer(ev.ParentHash)
+	}
+
+	var rekeyed int
+	for algorithm, hashes := range foreign {
+		var matches []*Event
+		var matched []string
+		for _, ev := range doc.Events {
+			hash, _ := util.HashObjectWith(algorithm, *ev)
+			if hashes[hash] {
+				matches = append(matches, ev)
+				matched = append(matched, hash)
+			}
+		}
+		for i, ev := range matches {
+			ev.Unregister()
+			ev.hash = matched[i]
+			ev.Register()
+		}
+		rekeyed += len(matches)
+	}
+	return rekeyed
+}
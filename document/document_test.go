@@ -3,6 +3,8 @@ package document
 import (
 	"bytes"
 	"errors"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -187,3 +189,128 @@ func TestDocument_Deserialize_BadKeys(t *testing.T) {
 		t.Fatal("Event was not registered under correct key")
 	}
 }
+
+func TestDocument_Deserialize_LegacyHashes(t *testing.T) {
+	// Legacy keys were obtained via:
+	// echo -n '{"parent":"","handler":"SET","args":{"path":[],"value":{"hello":"world"}}}' | sha1sum
+	// echo -n '{"parent":"aa58...","handler":"DELETE","args":{"path":["hello"]}}' | sha1sum
+	var buffer bytes.Buffer
+	buffer.WriteString(`{` +
+		`"events":{` +
+		`"aa582b4df04ba01af5205e702d4d16ed0b2c0705":{` +
+		`"parent":"","handler":"SET",` +
+		`"args":{"path":[],"value":{"hello":"world"}}` +
+		`},"3483d7409523a2af4f500f02c967692bdfe19d90":{` +
+		`"parent":"aa582b4df04ba01af5205e702d4d16ed0b2c0705","handler":"DELETE",` +
+		`"args":{"path":["hello"]}` +
+		`},"11143483d7409523a2af4f500f02c967692bdfe19d90":{` +
+		`"parent":"","handler":"Looks right, but isn't",` +
+		`"args":{}` +
+		`}}}` +
+		"\n",
+	)
+
+	dest := NewDocument()
+	if err := dest.Deserialize(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	// Legacy keys are kept, so history still hangs together
+	ev, ok := dest.Events["3483d7409523a2af4f500f02c967692bdfe19d90"]
+	if !ok {
+		t.Fatal("Legacy key was not kept")
+	}
+	history, ok := ev.GetHistory()
+	if !ok {
+		t.Fatal("Could not get history of legacy event")
+	}
+	comparem(t, 2, len(history), "Wrong history length")
+	comparem(t, "aa582b4df04ba01af5205e702d4d16ed0b2c0705", history[0].Hash(),
+		"Legacy parent not found under its key")
+
+	// Hashes that don't match are replaced
+	if _, ok := dest.Events["11143483d7409523a2af4f500f02c967692bdfe19d90"]; ok {
+		t.Fatal("Left an Event in under a bad key!")
+	}
+	impostor := dest.NewEvent("Looks right, but isn't")
+	if _, ok := dest.Events[impostor.Hash()]; !ok {
+		t.Fatal("Event was not registered under correct key")
+	}
+
+	// New Events still use the Document's algorithm
+	child := dest.NewEvent("SET")
+	child.SetParent(*ev)
+	comparem(t, "1220", child.Hash()[:4], "New Event should use SHA-256")
+}
+
+// A Document saved before hashes described themselves, untouched.
+func TestDocument_Deserialize_PreMultihash(t *testing.T) {
+	file, err := os.Open("testdata/legacy_document.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	dest := NewDocument()
+	if err := dest.Deserialize(file); err != nil {
+		t.Fatal(err)
+	}
+	comparem(t, 2, len(dest.Events), "Wrong number of events")
+
+	// Keys are kept, so lookups and history still work
+	ev, err := dest.GetEventByPrefix("b31d4d6a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparem(t, "b31d4d6a1dda5c7120a4b0953d15694a6a811f24", ev.Hash(), "Wrong event")
+	history, ok := ev.GetHistory()
+	if !ok {
+		t.Fatal("Could not get history of legacy event")
+	}
+	comparem(t, 2, len(history), "Wrong history length")
+	comparem(t, "bc28e13ea2a8d16fce3ca95b317a05fdef25ce94", history[0].Hash(),
+		"Legacy parent not found under its key")
+}
+
+// Events that arrive without their keys get them back from whatever
+// refers to them.
+func TestDocument_Rekey(t *testing.T) {
+	file, err := os.Open("testdata/legacy_document.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	legacy := NewDocument()
+	if err := legacy.Deserialize(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// As a peer would register them
+	dest := NewDocument()
+	for _, ev := range legacy.Events {
+		copied := dest.NewEvent(ev.HandlerName)
+		copied.ParentHash = ev.ParentHash
+		copied.Arguments = ev.Arguments
+		copied.Register()
+	}
+	_, err = dest.GetEventByPrefix("bc28e13e")
+	comparem(t, true, err != nil, "Legacy key should be lost at first")
+
+	// Nothing refers to the child yet, but its parent is known
+	comparem(t, 1, dest.Rekey(), "Wrong number of events rekeyed")
+	comparem(t, 0, dest.Rekey(), "Rekeying should be idempotent")
+
+	dest.Timestamps = []string{
+		"b31d4d6a1dda5c7120a4b0953d15694a6a811f24",
+		"not a hash",
+		"1220" + strings.Repeat("00", 32), // Ours, just unknown
+	}
+	comparem(t, 1, dest.Rekey(), "Wrong number of events rekeyed")
+	for key := range legacy.Events {
+		if _, ok := dest.Events[key]; !ok {
+			t.Errorf("Event not registered under legacy key %s", key)
+		}
+	}
+	comparem(t, 2, len(dest.Events), "Wrong number of events")
+	comparem(t, 2, len(dest.sortedHashes().hashes), "Hash index out of date")
+}
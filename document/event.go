@@ -11,11 +11,17 @@ import (
 // including a set of parameters. In practice, custom Event
 // types may be defined for a document, as well as permissions
 // for which users are allowed to perform which types of Events.
+//
+// Once an Event is registered, it must not be changed, since its hash
+// is cached (see Register). That goes for copies of it, too.
 type Event struct {
 	Doc         *Document              `json:"-"`
 	ParentHash  string                 `json:"parent"`
 	HandlerName string                 `json:"handler"`
 	Arguments   map[string]interface{} `json:"args"`
+
+	// Set by Register, cleared by Unregister
+	hash string
 }
 
 type EventSet map[string]*Event
@@ -50,8 +56,26 @@ func (e Event) Eq(other Event) bool {
 }
 
 // Get the hash of the Event object.
+//
+// This means serializing the whole Event, which is expensive, so
+// registered Events remember theirs. The algorithm is the Doc's, or
+// util.DefaultHashAlgorithm for Events without one. GENESIS Events
+// use the algorithm they specify, as long as it's a known one.
 func (e Event) Hash() string {
-	hash, _ := util.HashObject(e)
+	if e.hash != "" {
+		return e.hash
+	}
+	algorithm := util.DefaultHashAlgorithm
+	if e.Doc != nil && e.Doc.HashAlgorithm != "" {
+		algorithm = e.Doc.HashAlgorithm
+	}
+	if e.HandlerName == GenesisHandler {
+		declared, _ := e.Arguments["hash_algorithm"].(string)
+		if util.HashAlgorithm(declared).Known() {
+			algorithm = util.HashAlgorithm(declared)
+		}
+	}
+	hash, _ := util.HashObjectWith(algorithm, e)
 	return hash
 }
 
@@ -61,9 +85,14 @@ func (e *Event) SetParent(p Event) {
 
 // Register with the Doc. This stores it in a hash-based location,
 // so do not make changes to an Event after it has been registered.
+// The hash is worked out once, here, and cached from then on.
 func (e *Event) Register() {
 	key := e.GetKey()
+	e.hash = key
 	e.Doc.Events[key] = e
+	if e.Doc.hashes != nil {
+		e.Doc.hashes.add(key)
+	}
 
 	group_key := e.GetGroupKey()
 	group, ok := e.Doc.EventsByParent[group_key]
@@ -75,9 +104,18 @@ func (e *Event) Register() {
 }
 
 // Unregister from the Doc. This also cleans up empty groups.
+//
+// Afterwards, the Event may be changed again.
 func (e *Event) Unregister() {
 	key := e.GetKey()
+	e.hash = ""
 	delete(e.Doc.Events, key)
+	if e.Doc.hashes != nil {
+		e.Doc.hashes.remove(key)
+	}
+
+	// Other Events' histories may have gone through this one
+	e.Doc.index = nil
 
 	group_key := e.GetGroupKey()
 	group := e.Doc.EventsByParent[group_key]
@@ -98,20 +136,28 @@ func (e *Event) GetParent() (*Event, bool) {
 // If we fail to find a parent at any point, we return (nil, false).
 func (e *Event) GetHistory() ([]*Event, bool) {
 	history := []*Event{e}
-	current := e
-	for {
-		if current.ParentHash == "" {
-			return history, true
-		}
+	for current := e; current.ParentHash != ""; {
 		parent, ok := current.GetParent()
-		if ok {
-			// Prepend parent onto history
-			history = append([]*Event{parent}, history...)
-			current = parent
-		} else {
+		if !ok {
 			return nil, false
 		}
+		history = append(history, parent)
+		current = parent
+	}
+
+	// Collected newest first
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
 	}
+	return history, true
+}
+
+// Whether every ancestor of the Event is registered, so GetHistory
+// would succeed. Complete histories are remembered, so this only has
+// to look at Events it hasn't seen before.
+func (e *Event) HasHistory() bool {
+	_, ok := e.Doc.indexEvent(e, e.GetKey())
+	return ok
 }
 
 // Given a set of Events, and two specific ones to trace,
@@ -127,38 +173,28 @@ func (e *Event) GetHistory() ([]*Event, bool) {
 //
 // There may not be a common ancestor. In this event, we return
 // an error.
+//
+// Where Events sit in history is remembered by the Doc, so after
+// the first call, this takes O(log n) time for chains of length n.
 func (A *Event) GetCommonAncestor(B *Event) (*Event, error) {
 	d := A.Doc
-	ancestors := make(map[string]bool)
-	trails := make(chan *Event, 2)
-	var current *Event
-
-	trails <- A
-	trails <- B
-
-	for {
-		select {
-		case current = <-trails:
-			// Check current
-			current_key := current.GetKey()
-			if ancestors[current_key] {
-				return current, nil
-			} else {
-				ancestors[current_key] = true
-			}
+	hash_a, hash_b := A.GetKey(), B.GetKey()
+	if _, ok := d.indexEvent(A, hash_a); !ok {
+		return nil, errors.New("Bad parent hash")
+	}
+	if _, ok := d.indexEvent(B, hash_b); !ok {
+		return nil, errors.New("Bad parent hash")
+	}
 
-			// Get parent, add to trails
-			if current.ParentHash == "" {
-				continue
-			}
-			parent, ok := d.Events[current.ParentHash]
-			if !ok {
-				return current, errors.New("Bad parent hash")
-			}
-			trails <- parent
-		default:
-			return current, errors.New("No common ancestor")
-		}
+	switch ancestor := d.commonAncestor(hash_a, hash_b); ancestor {
+	case "":
+		return nil, errors.New("No common ancestor")
+	case hash_a:
+		return A, nil
+	case hash_b:
+		return B, nil
+	default:
+		return d.Events[ancestor], nil
 	}
 }
 
@@ -172,7 +208,7 @@ func (A *Event) CompatibleWith(B *Event) (bool, error) {
 		return false, err
 	}
 
-	return (parent.Eq(*A) || parent.Eq(*B)), nil
+	return (parent == A || parent == B), nil
 }
 
 // Traverse up the chain of parents until there's no more to traverse.
@@ -210,6 +246,29 @@ func (e Event) GetChildren() EventSet {
 // as long as the event's properties are sufficient to populate
 // the struct primitive.
 func (e Event) getPrimitives() ([]state.Primitive, error) {
+	if e.HandlerName == GenesisHandler {
+		// Mostly says things about the Document, but the first
+		// acceptors go in its state, where later Events can change them
+		g, err := e.GetGenesis()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := g.Permissions[acceptorsKey]; !ok {
+			return []state.Primitive{}, nil
+		}
+		acceptors, _ := g.Acceptors()
+		listed := make([]interface{}, len(acceptors))
+		for i, acceptor := range acceptors {
+			listed[i] = acceptor
+		}
+		primitives := []state.Primitive{
+			&state.SetPrimitive{
+				Path:  []interface{}{acceptorsKey},
+				Value: listed,
+			},
+		}
+		return primitives, nil
+	}
 	if e.HandlerName == "SET" || e.HandlerName == "DELETE" {
 		path_interface, ok := e.Arguments["path"]
 		if !ok {
@@ -250,12 +309,18 @@ func (e Event) getPrimitives() ([]state.Primitive, error) {
 // Does not check that the document is at the Event's parent
 // before attempting to apply primitives.
 func (e Event) Apply() error {
+	return e.ApplyTo(e.Doc.State)
+}
+
+// Like Apply, but to any DocumentState. Useful for trying an Event
+// out on a scratch copy of the state, without touching the Doc.
+func (e Event) ApplyTo(ds *state.DocumentState) error {
 	primitives, err := e.getPrimitives()
 	if err != nil {
 		return err
 	}
 	for _, primitive := range primitives {
-		err = e.Doc.State.Apply(primitive)
+		err = ds.Apply(primitive)
 		if err != nil {
 			return err
 		}
@@ -263,27 +328,22 @@ func (e Event) Apply() error {
 	return nil
 }
 
-// Internal function called by Goto, so that recursion does not
-// result in multiple state resets.
-func (e Event) gotoNoReset() error {
-	d := e.Doc
-	if e.ParentHash != "" {
-		parent, ok := d.Events[e.ParentHash]
-		if !ok {
-			return errors.New("Could not get parent")
-		}
-		err := parent.gotoNoReset()
-		if err != nil {
-			return err
-		}
-	}
-	return e.Apply()
-}
-
 // Attempt to navigate the DocumentState to this Event.
 //
 // Somewhat analogous to git checkout.
 func (e Event) Goto() error {
 	e.Doc.State.Reset()
-	return e.gotoNoReset()
+	history, ok := e.GetHistory()
+	if !ok {
+		return errors.New("Could not get parent")
+	}
+	if !history[0].DescendsFromGenesis() {
+		return errors.New("Event does not descend from genesis")
+	}
+	for _, ev := range history {
+		if err := ev.Apply(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/DJDNS/go-deje/state"
+	"github.com/DJDNS/go-deje/util"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -40,14 +41,55 @@ func TestEvent_GetKey(t *testing.T) {
 	key := ev.GetKey()
 
 	// Obtained via:
-	// echo -n '{"parent":"","handler":"handler_name","args":{"before":null,"hello":["world",5]}}' | sha1sum
-	expected := "86e5db5fcf8c749146f2adcc23c728769ef2bd98"
+	// echo -n '{"args":{"before":null,"hello":["world",5]},"handler":"handler_name","parent":""}' | sha256sum
+	// plus the multihash prefix for SHA-256
+	expected := "1220461d2237f65a3214ad44183a6bf51f491d646748a90abbc845b4c5618f4d5451"
 
 	if key != expected {
 		t.Fatalf("Expected %v, got %v", expected, key)
 	}
 }
 
+func TestEvent_Hash_Algorithm(t *testing.T) {
+	// Same Event as TestEvent_GetKey
+	tests := []struct {
+		Algorithm util.HashAlgorithm
+		Expected  string
+	}{
+		{"", "1220461d2237f65a3214ad44183a6bf51f491d646748a90abbc845b4c5618f4d5451"},
+		{util.SHA256, "1220461d2237f65a3214ad44183a6bf51f491d646748a90abbc845b4c5618f4d5451"},
+		{util.SHA1, "1114de516ea5642aec8cee822b378d01b5a6348493f7"},
+		{util.LegacySHA1, "86e5db5fcf8c749146f2adcc23c728769ef2bd98"},
+		{"unknown", ""},
+	}
+	for _, test := range tests {
+		d := NewDocument()
+		d.HashAlgorithm = test.Algorithm
+		ev := d.NewEvent("handler_name")
+		ev.Arguments["hello"] = []interface{}{"world", 5}
+		ev.Arguments["before"] = nil
+		assert.Equal(t, test.Expected, ev.Hash(), string(test.Algorithm))
+	}
+}
+
+func TestEvent_Hash_Cached(t *testing.T) {
+	d := NewDocument()
+	ev := d.NewEvent("handler_name")
+	before := ev.Hash()
+	ev.Arguments["changed"] = true
+	assert.NotEqual(t, before, ev.Hash(), "Unregistered events are rehashed")
+
+	registered := ev.Hash()
+	ev.Register()
+	ev.Arguments["changed"] = false // Don't do this at home
+	assert.Equal(t, registered, ev.Hash(), "Registered events are cached")
+	copied := ev
+	assert.Equal(t, registered, copied.Hash(), "Copies share the cache")
+
+	ev.Unregister()
+	assert.NotEqual(t, registered, ev.Hash(), "Unregistering clears the cache")
+}
+
 func TestEvent_GetGroupKey(t *testing.T) {
 	ev := NewEvent("SET")
 
@@ -219,12 +261,25 @@ func TestEvent_GetHistory(t *testing.T) {
 		t.Logf("Event %s", e.HandlerName)
 		assert.Equal(t, ok, got_ok)
 		assert.Equal(t, history, got_history)
+		assert.Equal(t, ok, e.HasHistory())
 	}
 	expect(t, &ev_first, []*Event{&ev_first}, true)
 	expect(t, &ev_second, []*Event{&ev_first, &ev_second}, true)
 	expect(t, &ev_third, []*Event{&ev_first, &ev_second, &ev_third}, true)
 	expect(t, &ev_fork, []*Event{&ev_first, &ev_fork}, true)
 	expect(t, &ev_no_parent, nil_event_slice, false)
+
+	// Missing parents can still turn up
+	ev_late := d.NewEvent("late")
+	ev_late_child := d.NewEvent("late child")
+	ev_late.SetParent(ev_third)
+	ev_late_child.SetParent(ev_late)
+	ev_late_child.Register()
+	expect(t, &ev_late_child, nil_event_slice, false)
+	ev_late.Register()
+	expect(t, &ev_late_child, []*Event{
+		&ev_first, &ev_second, &ev_third, &ev_late, &ev_late_child,
+	}, true)
 }
 
 func TestEvent_GetCommonAncestor_CommonAncestorExists(t *testing.T) {
@@ -635,6 +690,22 @@ func TestEvent_Apply_UnapplyablePrimitive(t *testing.T) {
 	}
 }
 
+func TestEvent_ApplyTo(t *testing.T) {
+	d := NewDocument()
+	ev := d.NewEvent("SET")
+	ev.Arguments["path"] = []interface{}{"hello"}
+	ev.Arguments["value"] = "world"
+
+	scratch := state.NewDocumentState()
+	assert.NoError(t, ev.ApplyTo(scratch))
+	assert.Equal(t, map[string]interface{}{"hello": "world"}, scratch.Export())
+	assert.Equal(t, map[string]interface{}{}, d.State.Export(),
+		"Doc state should be untouched")
+
+	ev.Arguments["path"] = []interface{}{"this", "that"}
+	assert.Error(t, ev.ApplyTo(scratch))
+}
+
 func TestEvent_Goto(t *testing.T) {
 	d := NewDocument()
 	ev_root := d.NewEvent("SET")
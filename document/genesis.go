@@ -0,0 +1,190 @@
+package document
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+
+	"github.com/DJDNS/go-deje/util"
+)
+
+// The handler name of the Event that starts a Document.
+const GenesisHandler = "GENESIS"
+
+// What a creator actually signs, ahead of the Genesis's canonical JSON.
+const genesisPrefix = "DEJE genesis: "
+
+// What a GENESIS Event says about its Document.
+//
+// Once a Document has a genesis, only Events that descend from it
+// count, which ties them to the genesis's topic, so they can't be
+// replayed into some unrelated Document. The hash of the GENESIS
+// Event doubles as the Document's ID.
+type Genesis struct {
+	// The deje:// topic the Document lives at.
+	Topic string `json:"topic"`
+
+	// The public key of whoever created the Document.
+	Creator string `json:"creator"`
+
+	// How Events in the Document are hashed. The GENESIS Event itself
+	// is always hashed with this, whatever its Document's setting.
+	HashAlgorithm util.HashAlgorithm `json:"hash_algorithm"`
+
+	// Who may do what, to begin with. The "acceptors" permission lists
+	// the keys that vote on Events (see Vote), and is where the
+	// Document's state starts out.
+	Permissions map[string]interface{} `json:"permissions"`
+
+	// The Creator's signature of everything above. See Verify.
+	Signature string `json:"signature,omitempty"`
+}
+
+// The bytes that get signed: everything but the Signature itself.
+func (g Genesis) signedBytes() ([]byte, error) {
+	g.Signature = ""
+	serialized, err := util.CanonicalJSON(g)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(genesisPrefix), serialized...), nil
+}
+
+// Check that the Genesis was signed by its Creator, which must be a
+// hex-encoded ed25519 public key. Peers only adopt a genesis that
+// passes this.
+func (g Genesis) Verify() error {
+	public, err := hex.DecodeString(g.Creator)
+	if err != nil || len(public) != ed25519.PublicKeySize {
+		return errors.New("Bad creator key: '" + g.Creator + "'")
+	}
+	signature, err := hex.DecodeString(g.Signature)
+	if err != nil {
+		return errors.New("Bad GENESIS signature")
+	}
+	signed, err := g.signedBytes()
+	if err != nil || !ed25519.Verify(public, signed, signature) {
+		return errors.New("Bad GENESIS signature")
+	}
+	return nil
+}
+
+// Create and register a GENESIS Event, and adopt it as the Document's
+// genesis (see SetGenesis).
+//
+// If the Genesis doesn't specify a HashAlgorithm, the Document's is
+// used. If a key is given, it becomes the Creator, and signs the
+// Genesis. Otherwise, the Genesis is left unsigned, which is fine for
+// local use, but peers won't adopt it.
+func (doc *Document) CreateGenesis(g Genesis, key ed25519.PrivateKey) (*Event, error) {
+	if doc.GenesisHash != "" {
+		return nil, errors.New("Document already has a genesis")
+	}
+	if g.HashAlgorithm == "" {
+		g.HashAlgorithm = doc.HashAlgorithm
+	}
+	if g.Permissions == nil {
+		g.Permissions = make(map[string]interface{})
+	}
+	if key != nil {
+		g.Creator = hex.EncodeToString(key.Public().(ed25519.PublicKey))
+		signed, err := g.signedBytes()
+		if err != nil {
+			return nil, err
+		}
+		g.Signature = hex.EncodeToString(ed25519.Sign(key, signed))
+	}
+
+	ev := doc.NewEvent(GenesisHandler)
+	if err := util.CloneMarshal(g, &ev.Arguments); err != nil {
+		return nil, err
+	}
+	g, err := ev.GetGenesis()
+	if err != nil {
+		return nil, err
+	}
+	ev.Register()
+	doc.useGenesis(ev.Hash(), g)
+	return &ev, nil
+}
+
+// Adopt a registered GENESIS Event as the Document's genesis, which
+// also switches the Document to its HashAlgorithm.
+//
+// This fails if the Document already has a different genesis. Setting
+// GenesisHash ahead of time, before the Event itself turns up, means
+// no other genesis will be adopted.
+func (doc *Document) SetGenesis(hash string) error {
+	if doc.GenesisHash != "" && doc.GenesisHash != hash {
+		return errors.New("Document already has a genesis")
+	}
+	ev, ok := doc.Events[hash]
+	if !ok {
+		return errors.New("Genesis event not found")
+	}
+	g, err := ev.GetGenesis()
+	if err != nil {
+		return err
+	}
+	doc.useGenesis(hash, g)
+	return nil
+}
+
+// Must only be called with a Genesis that passed GetGenesis.
+func (doc *Document) useGenesis(hash string, g Genesis) {
+	doc.GenesisHash = hash
+	doc.HashAlgorithm = g.HashAlgorithm
+}
+
+// Get the Document's GENESIS Event.
+func (doc *Document) Genesis() (*Event, error) {
+	if doc.GenesisHash == "" {
+		return nil, errors.New("Document has no genesis")
+	}
+	ev, ok := doc.Events[doc.GenesisHash]
+	if !ok {
+		return nil, errors.New("Genesis event not found")
+	}
+	return ev, nil
+}
+
+// Get what a GENESIS Event says about its Document.
+func (e Event) GetGenesis() (Genesis, error) {
+	var g Genesis
+	if e.HandlerName != GenesisHandler {
+		return g, errors.New("Not a GENESIS event")
+	}
+	if e.ParentHash != "" {
+		return g, errors.New("GENESIS event must not have a parent")
+	}
+	if err := util.CloneMarshal(e.Arguments, &g); err != nil {
+		return g, errors.New("Bad GENESIS arguments")
+	}
+	if g.Topic == "" {
+		return g, errors.New("GENESIS event has no topic")
+	}
+	if !g.HashAlgorithm.Known() {
+		return g, errors.New("Unknown hash algorithm: '" + string(g.HashAlgorithm) + "'")
+	}
+	if _, err := g.Acceptors(); err != nil {
+		return g, err
+	}
+	return g, nil
+}
+
+// Whether an Event belongs to its Doc, because it descends from the
+// Doc's genesis. Every Event does, if the Doc doesn't have a genesis.
+//
+// Like GetCommonAncestor, this takes O(log n) time after the first
+// call, for chains of length n.
+func (e *Event) DescendsFromGenesis() bool {
+	d := e.Doc
+	if d.GenesisHash == "" {
+		return true
+	}
+	hash := e.GetKey()
+	if _, ok := d.indexEvent(e, hash); !ok {
+		return false
+	}
+	return d.ancestorAt(hash, 0) == d.GenesisHash
+}
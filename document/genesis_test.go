@@ -0,0 +1,275 @@
+package document
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/DJDNS/go-deje/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocument_CreateGenesis(t *testing.T) {
+	d := NewDocument()
+	_, err := d.Genesis()
+	assert.EqualError(t, err, "Document has no genesis")
+
+	genesis, err := d.CreateGenesis(Genesis{
+		Topic:       "deje://example.com/doc",
+		Creator:     "creator key",
+		Permissions: map[string]interface{}{"writers": []interface{}{"creator key"}},
+	}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, GenesisHandler, genesis.HandlerName)
+	assert.Equal(t, "", genesis.ParentHash)
+	assert.Equal(t, map[string]interface{}{
+		"topic":          "deje://example.com/doc",
+		"creator":        "creator key",
+		"hash_algorithm": "sha2-256",
+		"permissions": map[string]interface{}{
+			"writers": []interface{}{"creator key"},
+		},
+	}, genesis.Arguments)
+	assert.Equal(t, genesis.Hash(), d.GenesisHash)
+
+	got, err := d.Genesis()
+	assert.NoError(t, err)
+	assert.Equal(t, genesis, got)
+
+	_, err = d.CreateGenesis(Genesis{Topic: "deje://example.com/other"}, nil)
+	assert.EqualError(t, err, "Document already has a genesis")
+	assert.Equal(t, genesis.Hash(), d.GenesisHash)
+}
+
+func TestDocument_CreateGenesis_HashAlgorithm(t *testing.T) {
+	d := NewDocument()
+	genesis, err := d.CreateGenesis(Genesis{
+		Topic:         "deje://example.com/doc",
+		HashAlgorithm: util.SHA512,
+	}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "1340", genesis.Hash()[:4])
+	assert.Equal(t, util.SHA512, d.HashAlgorithm)
+	assert.Equal(t, map[string]interface{}{}, genesis.Arguments["permissions"])
+
+	child := addChild(&d, "child", genesis)
+	assert.Equal(t, "1340", child.Hash()[:4])
+
+	// The GENESIS Event is hashed the same way in any Document
+	other := NewDocument()
+	copied := other.NewEvent(GenesisHandler)
+	copied.Arguments = genesis.Arguments
+	assert.Equal(t, genesis.Hash(), copied.Hash())
+}
+
+func TestDocument_CreateGenesis_Invalid(t *testing.T) {
+	tests := []struct {
+		Genesis Genesis
+		Error   string
+	}{
+		{Genesis{}, "GENESIS event has no topic"},
+		{Genesis{Topic: "deje://example.com/", HashAlgorithm: "md5"},
+			"Unknown hash algorithm: 'md5'"},
+		{Genesis{Topic: "deje://example.com/", Permissions: map[string]interface{}{
+			"bad": make(chan int),
+		}}, "json: unsupported type: chan int"},
+	}
+	for _, test := range tests {
+		d := NewDocument()
+		_, err := d.CreateGenesis(test.Genesis, nil)
+		assert.EqualError(t, err, test.Error)
+		assert.Equal(t, "", d.GenesisHash)
+		assert.Len(t, d.Events, 0)
+		assert.Equal(t, util.DefaultHashAlgorithm, d.HashAlgorithm)
+	}
+}
+
+func TestGenesis_Verify(t *testing.T) {
+	key := acceptorKey(1)
+	d := NewDocument()
+	genesis, err := d.CreateGenesis(Genesis{Topic: "deje://example.com/"}, key)
+	if !assert.NoError(t, err) {
+		return
+	}
+	g, err := genesis.GetGenesis()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, acceptorID(key), g.Creator)
+	assert.NoError(t, g.Verify())
+
+	tampered := g
+	tampered.Topic = "deje://example.com/other"
+	unserializable := g
+	unserializable.Permissions = map[string]interface{}{"bad": make(chan int)}
+	tests := []struct {
+		Genesis Genesis
+		Error   string
+	}{
+		{tampered, "Bad GENESIS signature"},
+		{unserializable, "Bad GENESIS signature"},
+		{Genesis{Creator: g.Creator, Signature: "zz"}, "Bad GENESIS signature"},
+		{Genesis{Creator: acceptorID(acceptorKey(2)), Signature: g.Signature},
+			"Bad GENESIS signature"},
+		{Genesis{Topic: g.Topic}, "Bad creator key: ''"},
+		{Genesis{Creator: "creator key"}, "Bad creator key: 'creator key'"},
+	}
+	for _, test := range tests {
+		assert.EqualError(t, test.Genesis.Verify(), test.Error)
+	}
+
+	// Signing needs the Genesis to serialize
+	other := NewDocument()
+	_, err = other.CreateGenesis(Genesis{
+		Topic:       "deje://example.com/",
+		Permissions: map[string]interface{}{"bad": make(chan int)},
+	}, key)
+	assert.EqualError(t, err, "json: unsupported type: chan int")
+}
+
+func TestEvent_GetGenesis(t *testing.T) {
+	d := NewDocument()
+	tests := []struct {
+		Handler   string
+		Parent    string
+		Arguments map[string]interface{}
+		Error     string
+	}{
+		{"SET", "", nil, "Not a GENESIS event"},
+		{GenesisHandler, "parent", nil, "GENESIS event must not have a parent"},
+		{GenesisHandler, "", map[string]interface{}{"topic": 5}, "Bad GENESIS arguments"},
+		{GenesisHandler, "", map[string]interface{}{"topic": ""}, "GENESIS event has no topic"},
+		{GenesisHandler, "", map[string]interface{}{"topic": "x"}, "Unknown hash algorithm: ''"},
+		{GenesisHandler, "", map[string]interface{}{
+			"topic":          "x",
+			"hash_algorithm": "sha1",
+		}, ""},
+	}
+	for _, test := range tests {
+		ev := d.NewEvent(test.Handler)
+		ev.ParentHash = test.Parent
+		if test.Arguments != nil {
+			ev.Arguments = test.Arguments
+		}
+		g, err := ev.GetGenesis()
+		if test.Error != "" {
+			assert.EqualError(t, err, test.Error)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, Genesis{Topic: "x", HashAlgorithm: util.SHA1}, g)
+	}
+
+	// Bad GENESIS Events can't be applied, and are hashed like any other
+	ev := d.NewEvent(GenesisHandler)
+	ev.Arguments["hash_algorithm"] = "md5"
+	assert.EqualError(t, ev.ApplyTo(d.State), "GENESIS event has no topic")
+	assert.Equal(t, "1220", ev.Hash()[:4])
+}
+
+func TestDocument_SetGenesis(t *testing.T) {
+	source := NewDocument()
+	genesis, err := source.CreateGenesis(Genesis{
+		Topic:         "deje://example.com/",
+		HashAlgorithm: util.SHA1,
+	}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	d := NewDocument()
+	assert.EqualError(t, d.SetGenesis(genesis.Hash()), "Genesis event not found")
+	not_genesis := addChild(&d, "SET", nil)
+	assert.EqualError(t, d.SetGenesis(not_genesis.Hash()), "Not a GENESIS event")
+	assert.Equal(t, "", d.GenesisHash)
+
+	copied := *genesis
+	copied.Doc = &d
+	copied.Register()
+	assert.NoError(t, d.SetGenesis(copied.Hash()))
+	assert.Equal(t, genesis.Hash(), d.GenesisHash)
+	assert.Equal(t, util.SHA1, d.HashAlgorithm)
+	assert.NoError(t, d.SetGenesis(copied.Hash()), "Setting again is harmless")
+
+	other_doc := NewDocument()
+	other, err := other_doc.CreateGenesis(Genesis{Topic: "deje://example.com/"}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	other_copy := *other
+	other_copy.Doc = &d
+	other_copy.Register()
+	assert.EqualError(t, d.SetGenesis(other_copy.Hash()), "Document already has a genesis")
+	assert.Equal(t, genesis.Hash(), d.GenesisHash)
+}
+
+func TestDocument_Genesis_NotFound(t *testing.T) {
+	d := NewDocument()
+	d.GenesisHash = "pinned ahead of time"
+	_, err := d.Genesis()
+	assert.EqualError(t, err, "Genesis event not found")
+}
+
+func TestEvent_DescendsFromGenesis(t *testing.T) {
+	d := NewDocument()
+	unrelated := addChild(&d, "SET", nil)
+	assert.True(t, unrelated.DescendsFromGenesis(), "Anything goes without a genesis")
+
+	genesis, err := d.CreateGenesis(Genesis{Topic: "deje://example.com/"}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	child := d.NewEvent("SET")
+	child.Arguments["path"] = []interface{}{"hello"}
+	child.Arguments["value"] = "world"
+	child.SetParent(*genesis)
+	child.Register()
+	unrelated_child := addChild(&d, "DELETE", unrelated)
+	orphan := d.NewEvent("SET")
+	orphan.ParentHash = "missing"
+
+	assert.True(t, genesis.DescendsFromGenesis())
+	assert.True(t, child.DescendsFromGenesis())
+	assert.False(t, unrelated.DescendsFromGenesis())
+	assert.False(t, unrelated_child.DescendsFromGenesis())
+	assert.False(t, orphan.DescendsFromGenesis())
+
+	assert.NoError(t, child.Goto())
+	assert.Equal(t, map[string]interface{}{"hello": "world"}, d.State.Export())
+	assert.EqualError(t, unrelated_child.Goto(), "Event does not descend from genesis")
+}
+
+func TestDocument_Genesis_Serialization(t *testing.T) {
+	source := NewDocument()
+	genesis, err := source.CreateGenesis(Genesis{
+		Topic:         "deje://example.com/",
+		HashAlgorithm: util.SHA512,
+	}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	child := addChild(&source, "child", genesis)
+
+	var buffer bytes.Buffer
+	if !assert.NoError(t, source.Serialize(&buffer)) {
+		return
+	}
+	serialized := buffer.String()
+	assert.Contains(t, serialized, `"genesis":"`+genesis.Hash()+`"`)
+
+	dest := NewDocument()
+	if !assert.NoError(t, dest.Deserialize(&buffer)) {
+		return
+	}
+	assert.Equal(t, genesis.Hash(), dest.GenesisHash)
+	assert.Equal(t, util.SHA512, dest.HashAlgorithm)
+	assert.True(t, dest.Events[child.Hash()].DescendsFromGenesis())
+
+	// Claims a genesis it doesn't have
+	broken := NewDocument()
+	err = broken.Deserialize(bytes.NewBufferString(`{"events":{},"genesis":"missing"}`))
+	assert.EqualError(t, err, "Genesis event not found")
+}
@@ -0,0 +1,58 @@
+package document
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/DJDNS/go-deje/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// The golden corpus is plain JSON, so that other DEJE implementations
+// can check their hashing against exactly the same data. It lives with
+// serial_tester, which hands it out with "serial_tester golden". Legacy is
+// what util.LegacySHA1 hashes: the old, field order serialization.
+type goldenEvent struct {
+	Description string                        `json:"description"`
+	Event       json.RawMessage               `json:"event"`
+	Canonical   string                        `json:"canonical"`
+	Legacy      string                        `json:"legacy"`
+	Hashes      map[util.HashAlgorithm]string `json:"hashes"`
+}
+
+func TestEvent_Hash_Golden(t *testing.T) {
+	file, err := os.Open("../serial_tester/golden_events.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var corpus []goldenEvent
+	if err := json.NewDecoder(file).Decode(&corpus); err != nil {
+		t.Fatal(err)
+	}
+	if len(corpus) == 0 {
+		t.Fatal("Golden corpus is empty")
+	}
+
+	for _, golden := range corpus {
+		var ev Event
+		if !assert.NoError(t, json.Unmarshal(golden.Event, &ev), golden.Description) {
+			continue
+		}
+		canonical, err := util.CanonicalJSON(ev)
+		assert.NoError(t, err, golden.Description)
+		assert.Equal(t, golden.Canonical, string(canonical), golden.Description)
+		legacy, err := json.Marshal(ev)
+		assert.NoError(t, err, golden.Description)
+		assert.Equal(t, golden.Legacy, string(legacy), golden.Description)
+		for algorithm, hash := range golden.Hashes {
+			got, err := util.HashObjectWith(algorithm, ev)
+			assert.NoError(t, err, golden.Description)
+			assert.Equal(t, hash, got, golden.Description+" ("+string(algorithm)+")")
+			assert.True(t, util.VerifyHash(hash, ev), golden.Description)
+		}
+		assert.Equal(t, golden.Hashes[util.DefaultHashAlgorithm], ev.Hash(), golden.Description)
+	}
+}
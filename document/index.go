@@ -0,0 +1,113 @@
+package document
+
+// What we know about where an Event sits in history. Since an Event's
+// hash covers its ParentHash, this never changes for a given hash, so
+// it's safe to remember for as long as the Events it refers to are
+// registered.
+type indexEntry struct {
+	parent string
+	depth  int
+
+	// An ancestor further back, for skipping ahead. Following jumps
+	// and parents as appropriate reaches any ancestor in O(log depth)
+	// steps. See Myers, "An applicative random-access stack" (1983).
+	jump string
+}
+
+// Find (or work out, and remember) the indexEntry for an Event, which
+// doesn't have to be registered itself, as long as its ancestors are.
+// Returns false if its history is incomplete.
+func (doc *Document) indexEvent(e *Event, hash string) (*indexEntry, bool) {
+	if doc.index == nil {
+		doc.index = make(map[string]*indexEntry)
+	}
+	if entry, ok := doc.index[hash]; ok {
+		return entry, true
+	}
+
+	// Walk back to something already indexed, or a root
+	type step struct {
+		hash  string
+		event *Event
+	}
+	path := []step{{hash, e}}
+	for current := e.ParentHash; current != ""; {
+		if _, ok := doc.index[current]; ok {
+			break
+		}
+		ev, ok := doc.Events[current]
+		if !ok {
+			return nil, false
+		}
+		path = append(path, step{current, ev})
+		current = ev.ParentHash
+	}
+
+	// Fill in from the oldest
+	var entry *indexEntry
+	for i := len(path) - 1; i >= 0; i-- {
+		entry = doc.newIndexEntry(path[i].hash, path[i].event.ParentHash)
+		doc.index[path[i].hash] = entry
+	}
+	return entry, true
+}
+
+// Must only be called once the parent is indexed.
+func (doc *Document) newIndexEntry(hash, parent_hash string) *indexEntry {
+	if parent_hash == "" {
+		return &indexEntry{parent: "", depth: 0, jump: hash}
+	}
+	parent := doc.index[parent_hash]
+	jump := doc.index[parent.jump]
+	entry := &indexEntry{
+		parent: parent_hash,
+		depth:  parent.depth + 1,
+		jump:   parent_hash,
+	}
+	if parent.depth-jump.depth == jump.depth-doc.index[jump.jump].depth {
+		entry.jump = jump.jump
+	}
+	return entry
+}
+
+// Get the hash of the ancestor of an indexed Event at the given depth,
+// which must not be deeper than the Event itself.
+func (doc *Document) ancestorAt(hash string, depth int) string {
+	entry := doc.index[hash]
+	for entry.depth > depth {
+		if doc.index[entry.jump].depth >= depth {
+			hash = entry.jump
+		} else {
+			hash = entry.parent
+		}
+		entry = doc.index[hash]
+	}
+	return hash
+}
+
+// Get the hash of the most recent common ancestor of two indexed
+// Events, or "" if they don't have one.
+func (doc *Document) commonAncestor(a, b string) string {
+	depth_a, depth_b := doc.index[a].depth, doc.index[b].depth
+	if depth_a > depth_b {
+		a = doc.ancestorAt(a, depth_b)
+	} else {
+		b = doc.ancestorAt(b, depth_a)
+	}
+
+	// Jumps only depend on depth, so a and b jump to the same depth.
+	// If they land in different places, the answer is further back.
+	for a != b {
+		entry_a, entry_b := doc.index[a], doc.index[b]
+		if entry_a.depth == 0 {
+			// Different roots
+			return ""
+		}
+		if entry_a.jump != entry_b.jump {
+			a, b = entry_a.jump, entry_b.jump
+		} else {
+			a, b = entry_a.parent, entry_b.parent
+		}
+	}
+	return a
+}
@@ -0,0 +1,148 @@
+package document
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The slow, obvious way to find a common ancestor.
+func naiveCommonAncestor(A, B *Event) *Event {
+	history_a, _ := A.GetHistory()
+	history_b, _ := B.GetHistory()
+	var ancestor *Event
+	for i := 0; i < len(history_a) && i < len(history_b); i++ {
+		if history_a[i].Hash() != history_b[i].Hash() {
+			break
+		}
+		ancestor = history_a[i]
+	}
+	return ancestor
+}
+
+func TestEvent_GetCommonAncestor_RandomTree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	d := NewDocument()
+
+	// Mostly long chains, with the occasional fork or new root
+	events := make([]*Event, 0, 2000)
+	for i := 0; i < cap(events); i++ {
+		var parent *Event
+		switch roll := rng.Intn(100); {
+		case i == 0 || roll < 2:
+			parent = nil
+		case roll < 10:
+			parent = events[rng.Intn(len(events))]
+		default:
+			parent = events[len(events)-1]
+		}
+		events = append(events, addChild(&d, strconv.Itoa(i), parent))
+	}
+
+	for i := 0; i < 2000; i++ {
+		A := events[rng.Intn(len(events))]
+		B := events[rng.Intn(len(events))]
+		expected := naiveCommonAncestor(A, B)
+
+		got, err := A.GetCommonAncestor(B)
+		if expected == nil {
+			assert.EqualError(t, err, "No common ancestor")
+			continue
+		}
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected.Hash(), got.Hash())
+		}
+
+		compatible, err := A.CompatibleWith(B)
+		assert.NoError(t, err)
+		assert.Equal(t, expected.Eq(*A) || expected.Eq(*B), compatible)
+	}
+}
+
+func TestEvent_GetCommonAncestor_MissingParentB(t *testing.T) {
+	d := NewDocument()
+	ev_A := addChild(&d, "A", nil)
+	ev_B := d.NewEvent("B")
+	ev_B.ParentHash = "blah blah blah"
+	ev_B.Register()
+
+	_, err := ev_A.GetCommonAncestor(&ev_B)
+	assert.EqualError(t, err, "Bad parent hash")
+}
+
+func TestEvent_GetCommonAncestor_Unregistered(t *testing.T) {
+	d := NewDocument()
+	root := addChild(&d, "root", nil)
+	child := addChild(&d, "child", root)
+	unregistered := d.NewEvent("unregistered")
+	unregistered.SetParent(*child)
+
+	ancestor, err := unregistered.GetCommonAncestor(root)
+	assert.NoError(t, err)
+	assert.Equal(t, root, ancestor)
+	ancestor, err = child.GetCommonAncestor(&unregistered)
+	assert.NoError(t, err)
+	assert.Equal(t, child, ancestor)
+}
+
+func TestEvent_Index_Unregister(t *testing.T) {
+	d := NewDocument()
+	root := addChild(&d, "root", nil)
+	middle := addChild(&d, "middle", root)
+	leaf := addChild(&d, "leaf", middle)
+
+	_, err := leaf.GetCommonAncestor(root)
+	assert.NoError(t, err)
+
+	// The leaf's history is broken now, which must not be remembered
+	middle.Unregister()
+	_, err = leaf.GetCommonAncestor(root)
+	assert.EqualError(t, err, "Bad parent hash")
+	_, err = d.Depth(leaf.Hash())
+	assert.EqualError(t, err, "Could not get parent")
+}
+
+func TestEvent_LongChain(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Long chain takes a while to build")
+	}
+	const length = 100000
+
+	d := NewDocument()
+	root := d.NewEvent("SET")
+	root.Arguments["path"] = []interface{}{"depth"}
+	root.Arguments["value"] = float64(0)
+	root.Register()
+
+	chain := []*Event{&root}
+	fork := addChild(&d, "fork", &root)
+	for i := 1; i < length; i++ {
+		ev := d.NewEvent("SET")
+		ev.Arguments["path"] = []interface{}{"depth"}
+		ev.Arguments["value"] = float64(i)
+		ev.SetParent(*chain[i-1])
+		ev.Register()
+		chain = append(chain, &ev)
+	}
+	tip := chain[length-1]
+
+	history, ok := tip.GetHistory()
+	assert.True(t, ok)
+	assert.Len(t, history, length)
+
+	ancestor, err := tip.GetCommonAncestor(fork)
+	assert.NoError(t, err)
+	assert.Equal(t, &root, ancestor)
+	compatible, err := chain[length/2].CompatibleWith(tip)
+	assert.NoError(t, err)
+	assert.True(t, compatible)
+
+	depth, err := d.Depth(tip.Hash())
+	assert.NoError(t, err)
+	assert.Equal(t, length-1, depth)
+
+	assert.NoError(t, tip.Goto())
+	assert.Equal(t, map[string]interface{}{"depth": float64(length - 1)}, d.State.Export())
+}
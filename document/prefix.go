@@ -0,0 +1,161 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The problems a HashPrefixError can describe.
+var (
+	ErrNoSuchEvent     = errors.New("No such event")
+	ErrAmbiguousPrefix = errors.New("Ambiguous hash prefix")
+)
+
+// How many hashes a HashPrefixError lists, at most.
+const maxListedHashes = 10
+
+// Returned when a hash prefix doesn't pick out exactly one Event. See
+// Document.GetEventByPrefix.
+//
+// To help pick a better prefix, it lists some hashes, as they were
+// when the error happened: the ones that match, if the prefix is
+// ambiguous, or otherwise the ones available.
+type HashPrefixError struct {
+	Problem error // ErrNoSuchEvent or ErrAmbiguousPrefix
+	Prefix  string
+	Hashes  []string // Sorted, and no more than maxListedHashes
+	Count   int      // How many there are in all
+}
+
+func newHashPrefixError(problem error, prefix string, hashes []string) HashPrefixError {
+	listed := hashes
+	if len(listed) > maxListedHashes {
+		listed = listed[:maxListedHashes]
+	}
+	return HashPrefixError{
+		Problem: problem,
+		Prefix:  prefix,
+		Hashes:  append([]string{}, listed...),
+		Count:   len(hashes),
+	}
+}
+
+func (hpe HashPrefixError) Error() string {
+	heading := "Available hashes"
+	if hpe.Problem == ErrAmbiguousPrefix {
+		heading = "Matching hashes"
+	}
+	var hash_list string
+	if len(hpe.Hashes) != 0 {
+		hash_list = "\t" + strings.Join(hpe.Hashes, "\n\t")
+	}
+	if more := hpe.Count - len(hpe.Hashes); more > 0 {
+		hash_list += fmt.Sprintf("\n\t... and %d more", more)
+	}
+	return fmt.Sprintf("%s: '%s'\n\n%s (%d):\n%s",
+		hpe.Problem, hpe.Prefix,
+		heading, hpe.Count, hash_list,
+	)
+}
+
+// So errors.Is works with ErrNoSuchEvent and ErrAmbiguousPrefix.
+func (hpe HashPrefixError) Unwrap() error {
+	return hpe.Problem
+}
+
+// Find an Event by its hash, or by any prefix of its hash that no
+// other Event shares, like git does with commits. Otherwise, returns
+// a HashPrefixError.
+func (doc *Document) GetEventByPrefix(hash_prefix string) (*Event, error) {
+	event, ok := doc.Events[hash_prefix]
+	if ok {
+		return event, nil
+	}
+
+	// Not an exact match - do prefix search. Matches are all together,
+	// since the hashes are sorted.
+	hashes := doc.sortedHashes().hashes
+	start := sort.SearchStrings(hashes, hash_prefix)
+	end := start + sort.Search(len(hashes)-start, func(i int) bool {
+		return !strings.HasPrefix(hashes[start+i], hash_prefix)
+	})
+
+	// Determine return value by number of results
+	switch end - start {
+	case 0:
+		return nil, newHashPrefixError(ErrNoSuchEvent, hash_prefix, hashes)
+	case 1:
+		return doc.Events[hashes[start]], nil
+	default:
+		return nil, newHashPrefixError(ErrAmbiguousPrefix, hash_prefix, hashes[start:end])
+	}
+}
+
+// The hashes of every registered Event, in order. Behind a pointer, so
+// that copies of a Document see the same Events as the original.
+//
+// Changes are only noted as they happen, and worked in the next time
+// the index is used. That way, registering a lot of Events at once
+// costs one sort, rather than a copy of the whole index for each.
+type hashIndex struct {
+	hashes  []string        // Only hashes[:sorted] are in order
+	sorted  int             // How many hashes are in order
+	removed map[string]bool // Hashes to drop on the next update
+}
+
+// Get the hashIndex, building it if necessary. Once built, it's kept
+// up to date by Event.Register and Event.Unregister.
+func (doc *Document) sortedHashes() *hashIndex {
+	if doc.hashes == nil {
+		hashes := make([]string, 0, len(doc.Events))
+		for key := range doc.Events {
+			hashes = append(hashes, key)
+		}
+		sort.Strings(hashes)
+		doc.hashes = &hashIndex{hashes: hashes, sorted: len(hashes)}
+	}
+	doc.hashes.update()
+	return doc.hashes
+}
+
+func (hi *hashIndex) add(hash string) {
+	delete(hi.removed, hash)
+	hi.hashes = append(hi.hashes, hash)
+}
+
+func (hi *hashIndex) remove(hash string) {
+	if hi.removed == nil {
+		hi.removed = make(map[string]bool)
+	}
+	hi.removed[hash] = true
+}
+
+// Merge the added hashes in with the sorted ones, leaving out
+// duplicates and removed hashes.
+func (hi *hashIndex) update() {
+	if hi.sorted == len(hi.hashes) && len(hi.removed) == 0 {
+		return
+	}
+	head, tail := hi.hashes[:hi.sorted], hi.hashes[hi.sorted:]
+	sort.Strings(tail)
+
+	merged := make([]string, 0, len(hi.hashes))
+	for len(head) > 0 || len(tail) > 0 {
+		var next string
+		if len(tail) == 0 || len(head) > 0 && head[0] <= tail[0] {
+			next, head = head[0], head[1:]
+		} else {
+			next, tail = tail[0], tail[1:]
+		}
+		last := len(merged) - 1
+		if hi.removed[next] || last >= 0 && merged[last] == next {
+			continue
+		}
+		merged = append(merged, next)
+	}
+	hi.hashes = merged
+	hi.sorted = len(merged)
+	hi.removed = nil
+}
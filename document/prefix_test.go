@@ -0,0 +1,180 @@
+package document
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEventByPrefix(t *testing.T) {
+	doc_no_events := NewDocument()
+	doc_with_events := NewDocument()
+
+	// Contents don't really matter, just need variety of hashes
+	evA := doc_with_events.NewEvent("A")
+	evA.Register()
+	evB := doc_with_events.NewEvent("B")
+	evB.Register()
+	evC := doc_with_events.NewEvent("C")
+	evC.Register()
+	evD := doc_with_events.NewEvent("Common prefix with evC")
+	evD.Register()
+
+	sorted_hashes := evB.Hash() + "\n\t" +
+		evA.Hash() + "\n\t" +
+		evC.Hash() + "\n\t" +
+		evD.Hash()
+
+	tests := []struct {
+		Doc           *Document
+		HashPrefix    string
+		ExpectedEvent *Event
+		ExpectedError string
+	}{
+		// Empty hash prefix
+		{
+			&doc_no_events, "",
+			nil,
+			"No such event: ''\n\nAvailable hashes (0):\n",
+		},
+		// No such event
+		{
+			&doc_no_events, "foo",
+			nil,
+			"No such event: 'foo'\n\nAvailable hashes (0):\n",
+		},
+		// No such event (where events are available)
+		{
+			&doc_with_events, "foo",
+			nil,
+			"No such event: 'foo'\n\nAvailable hashes (4):\n\t" + sorted_hashes,
+		},
+		// Exact match
+		{
+			&doc_with_events, evC.Hash(),
+			&evC,
+			"",
+		},
+		// Valid prefix
+		{
+			&doc_with_events, "12202a",
+			&evB,
+			"",
+		},
+		// Colliding prefix
+		{
+			&doc_with_events, "1220a",
+			nil,
+			"Ambiguous hash prefix: '1220a'\n\nMatching hashes (2):\n\t" +
+				evC.Hash() + "\n\t" + evD.Hash(),
+		},
+	}
+	for _, test := range tests {
+		event, err := test.Doc.GetEventByPrefix(test.HashPrefix)
+		assert.Equal(t, test.ExpectedEvent, event)
+		if test.ExpectedError == "" {
+			assert.NoError(t, err)
+		} else {
+			if assert.Error(t, err) {
+				assert.Equal(t, test.ExpectedError, err.Error())
+				assert.IsType(t, HashPrefixError{}, err)
+			}
+		}
+	}
+}
+
+func TestGetEventByPrefix_Problems(t *testing.T) {
+	d := NewDocument()
+	_, err := d.GetEventByPrefix("foo")
+	assert.True(t, errors.Is(err, ErrNoSuchEvent))
+	assert.Equal(t, ErrNoSuchEvent, err.(HashPrefixError).Problem)
+
+	// Only one event, so everything matches it
+	evA := d.NewEvent("A")
+	evA.Register()
+	event, err := d.GetEventByPrefix("")
+	assert.NoError(t, err)
+	assert.Equal(t, &evA, event)
+
+	evB := d.NewEvent("B")
+	evB.Register()
+	_, err = d.GetEventByPrefix("")
+	assert.True(t, errors.Is(err, ErrAmbiguousPrefix))
+	assert.Equal(t, []string{evB.Hash(), evA.Hash()}, err.(HashPrefixError).Hashes)
+	assert.Equal(t, 2, err.(HashPrefixError).Count)
+}
+
+func TestGetEventByPrefix_ManyHashes(t *testing.T) {
+	d := NewDocument()
+	for i := 0; i < maxListedHashes+2; i++ {
+		ev := d.NewEvent("SET")
+		ev.Arguments["value"] = i
+		ev.Register()
+	}
+	hashes := d.sortedHashes().hashes
+	_, err := d.GetEventByPrefix("1220")
+	if !assert.Error(t, err) {
+		return
+	}
+	assert.Equal(t, hashes[:maxListedHashes], err.(HashPrefixError).Hashes)
+	assert.Equal(t, maxListedHashes+2, err.(HashPrefixError).Count)
+	assert.Equal(t,
+		"Ambiguous hash prefix: '1220'\n\nMatching hashes (12):\n\t"+
+			strings.Join(hashes[:maxListedHashes], "\n\t")+"\n\t... and 2 more",
+		err.Error())
+
+	// A snapshot, which later Events don't change
+	ev := d.NewEvent("SET")
+	ev.Register()
+	assert.Equal(t, maxListedHashes+2, err.(HashPrefixError).Count)
+}
+
+func TestGetEventByPrefix_Index(t *testing.T) {
+	d := NewDocument()
+	evA := d.NewEvent("A")
+	evA.Register()
+	evB := d.NewEvent("B")
+	evB.Register()
+	_, err := d.GetEventByPrefix("1220")
+	assert.Error(t, err, "Builds the index")
+
+	// Kept up to date afterwards, including in copies
+	copied := d
+	evC := d.NewEvent("C")
+	evC.Register()
+	evC.Register()
+	evB.Unregister()
+	assert.Equal(t, []string{evA.Hash(), evC.Hash()}, copied.sortedHashes().hashes)
+	event, err := copied.GetEventByPrefix(evC.Hash()[:6])
+	assert.NoError(t, err)
+	assert.Equal(t, &evC, event)
+	_, err = d.GetEventByPrefix(evB.Hash()[:6])
+	assert.True(t, errors.Is(err, ErrNoSuchEvent))
+	evB.Unregister() // Not there to remove
+	assert.Len(t, d.sortedHashes().hashes, 2)
+
+	// Changes between updates are worked in together
+	evB.Register()
+	evB.Unregister()
+	evB.Register()
+	evA.Unregister()
+	evA.Register()
+	evC.Unregister()
+	assert.Equal(t, []string{evB.Hash(), evA.Hash()}, d.sortedHashes().hashes)
+	evB.Unregister()
+	evC.Register()
+
+	// Rebuilt from scratch on Deserialize
+	var buf bytes.Buffer
+	if err := d.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewDocument()
+	loaded.sortedHashes()
+	if assert.NoError(t, loaded.Deserialize(&buf)) {
+		assert.Equal(t, []string{evA.Hash(), evC.Hash()}, loaded.sortedHashes().hashes)
+	}
+}
@@ -0,0 +1,69 @@
+package document
+
+import (
+	"errors"
+
+	"github.com/DJDNS/go-deje/state"
+)
+
+// Replay the Events leading up to this one, on top of another Event.
+//
+// This is for when two branches of history compete, and this Event's
+// branch has lost. The Events it has that onto doesn't (going back to
+// the most recent common ancestor, or the very beginning if there is
+// none) are copied, with the same handler and arguments, into a new
+// chain that starts from onto. Somewhat analogous to git rebase.
+//
+// The new Events are returned oldest first, and are not registered.
+// If this Event is already part of onto's history, there is nothing
+// to replay, and the result is empty.
+//
+// Every copy is applied to a scratch copy of onto's state as it is
+// made, and if any of them fail to apply, there is no result. This
+// happens when the branches conflict (for example, one deletes what
+// the other sets inside of), and for handlers that can't be replayed.
+func (e *Event) Rebase(onto *Event) ([]*Event, error) {
+	history, ok := e.GetHistory()
+	if !ok {
+		return nil, errors.New("Could not get history of event to rebase")
+	}
+	onto_history, ok := onto.GetHistory()
+	if !ok {
+		return nil, errors.New("Could not get history of event to rebase onto")
+	}
+
+	// Skip everything up to the last ancestor we share with onto
+	shared := make(map[string]bool, len(onto_history))
+	for _, ev := range onto_history {
+		shared[ev.Hash()] = true
+	}
+	var start int
+	for i, ev := range history {
+		if shared[ev.Hash()] {
+			start = i + 1
+		}
+	}
+
+	ds := state.NewDocumentState()
+	for _, ev := range onto_history {
+		if err := ev.ApplyTo(ds); err != nil {
+			return nil, err
+		}
+	}
+
+	rebased := make([]*Event, 0, len(history)-start)
+	parent := onto
+	for _, ev := range history[start:] {
+		replay := e.Doc.NewEvent(ev.HandlerName)
+		for key, value := range ev.Arguments {
+			replay.Arguments[key] = value
+		}
+		replay.SetParent(*parent)
+		if err := replay.ApplyTo(ds); err != nil {
+			return nil, err
+		}
+		rebased = append(rebased, &replay)
+		parent = &replay
+	}
+	return rebased, nil
+}
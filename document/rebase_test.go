@@ -0,0 +1,89 @@
+package document
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvent_Rebase(t *testing.T) {
+	d := NewDocument()
+	make_event := func(handler string, parent *Event, path ...interface{}) *Event {
+		ev := d.NewEvent(handler)
+		ev.Arguments["path"] = path
+		if handler == "SET" {
+			ev.Arguments["value"] = map[string]interface{}{}
+		}
+		if parent != nil {
+			ev.SetParent(*parent)
+		}
+		ev.Register()
+		return &ev
+	}
+
+	root := make_event("SET", nil, "root")
+	left := make_event("SET", root, "root", "left")
+	right := make_event("SET", root, "root", "right")
+	right_again := make_event("SET", right, "root", "right", "again")
+	delete_root := make_event("DELETE", left, "root")
+	other_root := make_event("SET", nil, "other")
+	custom := make_event("CUSTOM", root)
+	orphan := make_event("SET", nil, "orphan")
+	orphan.Unregister()
+	orphan.ParentHash = "no such parent"
+	orphan.Register()
+	bad_onto := make_event("SET", nil, "no", "such", "path")
+
+	tests := []struct {
+		Description string
+		Branch      *Event
+		Onto        *Event
+		Replayed    []*Event // Originals that should be copied
+		Error       string
+	}{
+		{"Single event fork", right, left, []*Event{right}, ""},
+		{"Multiple event fork", right_again, left, []*Event{right, right_again}, ""},
+		{"Already in history", root, left, []*Event{}, ""},
+		{"Same event", left, left, []*Event{}, ""},
+		{"No common ancestor", other_root, left, []*Event{other_root}, ""},
+		{"Conflict", right, delete_root, nil,
+			"Key not present in map"},
+		{"Unreplayable handler", custom, left, nil,
+			"Custom events are not supported yet"},
+		{"Incomplete branch", orphan, left, nil,
+			"Could not get history of event to rebase"},
+		{"Incomplete onto", left, orphan, nil,
+			"Could not get history of event to rebase onto"},
+		{"Onto does not apply", left, bad_onto, nil,
+			"Key not present in map"},
+	}
+	for _, test := range tests {
+		rebased, err := test.Branch.Rebase(test.Onto)
+		if test.Error != "" {
+			assert.EqualError(t, err, test.Error, test.Description)
+			assert.Nil(t, rebased, test.Description)
+			continue
+		}
+		if !assert.NoError(t, err, test.Description) {
+			continue
+		}
+		if !assert.Len(t, rebased, len(test.Replayed), test.Description) {
+			continue
+		}
+
+		parent := test.Onto
+		for i, ev := range rebased {
+			original := test.Replayed[i]
+			assert.Equal(t, parent.Hash(), ev.ParentHash, test.Description)
+			assert.Equal(t, original.HandlerName, ev.HandlerName, test.Description)
+			assert.Equal(t, original.Arguments, ev.Arguments, test.Description)
+			assert.Equal(t, d, *ev.Doc, test.Description)
+			parent = ev
+		}
+	}
+
+	// Rebased events are not registered
+	rebased, _ := right.Rebase(left)
+	_, ok := d.Events[rebased[0].Hash()]
+	assert.False(t, ok, "Rebased events should not be registered")
+}
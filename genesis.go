@@ -0,0 +1,129 @@
+package deje
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/util"
+)
+
+// Start a new document, with a GENESIS Event for this client's topic,
+// signed with the creator's key, which becomes the document's genesis
+// (see document.Genesis) and its first tip. Fails if the document
+// already has a genesis, or is pinned.
+//
+// Like Do, the Event is returned even if publishing it fails.
+func (sc *SimpleClient) CreateGenesis(key ed25519.PrivateKey, permissions map[string]interface{}) (*document.Event, error) {
+	if sc.IsClosed() {
+		return nil, ErrClosed
+	}
+
+	sc.lock()
+	if sc.pin != "" {
+		sc.unlock()
+		return nil, ErrPinned
+	}
+	doc := sc.GetDoc()
+	genesis, err := doc.CreateGenesis(document.Genesis{
+		Topic:       sc.GetTopic(),
+		Permissions: permissions,
+	}, key)
+	if err != nil {
+		sc.unlock()
+		return nil, err
+	}
+	events_message := sc.eventMessage(*genesis)
+	doc.Timestamps = append(doc.Timestamps, genesis.Hash())
+	sc.reTip()
+	timestamps_message := sc.timestampsMessage()
+	sc.unlock()
+
+	if err := sc.Publish(events_message); err != nil {
+		return genesis, err
+	}
+	return genesis, sc.Publish(timestamps_message)
+}
+
+// Check that a GENESIS Event from a peer could be this document's
+// genesis: it's for this client's topic, and signed by its creator.
+func (sc *SimpleClient) checkGenesis(ev *document.Event) error {
+	genesis, err := ev.GetGenesis()
+	if err != nil {
+		return err
+	}
+	if genesis.Topic != sc.GetTopic() {
+		return errors.New("GENESIS event is for another topic: '" + genesis.Topic + "'")
+	}
+	return genesis.Verify()
+}
+
+// Adopt one of the GENESIS Events that peers have sent, and that
+// passed checkGenesis, as the document's genesis. It's the one that the
+// timestamped history descends from, or if that doesn't settle it, the
+// only one. Otherwise, nothing happens until more Events or timestamps
+// arrive. Once adopted, a genesis is kept.
+//
+// The batch is Events that have arrived, but can't be registered yet,
+// because the genesis decides how they're hashed.
+//
+// Must be called with sc.mutex held, and only if the document doesn't
+// have a genesis yet.
+func (sc *SimpleClient) adoptGenesis(batch []*document.Event) {
+	doc := sc.GetDoc()
+	chosen := tracedGenesis(doc, sc.geneses, batch)
+	if chosen == nil && len(sc.geneses) == 1 {
+		chosen = sc.geneses[0]
+	}
+	if chosen != nil {
+		// Already checked, so this can't fail
+		doc.SetGenesis(chosen.Hash())
+		sc.geneses = nil
+	}
+}
+
+// Find which of the candidate GENESIS Events the earliest timestamp
+// that leads to one of them descends from, or nil if none do. Each
+// candidate gets its own guess at the batch's hashes, made with its
+// hash algorithm.
+func tracedGenesis(doc *document.Document, candidates []*document.Event, batch []*document.Event) *document.Event {
+	parents := make([]map[string]string, len(candidates))
+	for i, candidate := range candidates {
+		// Only candidates that passed GetGenesis get here
+		genesis, _ := candidate.GetGenesis()
+		parents[i] = make(map[string]string, len(doc.Events)+len(batch))
+		for hash, ev := range doc.Events {
+			parents[i][hash] = ev.ParentHash
+		}
+		for _, ev := range batch {
+			// Events come from JSON, so they can always be hashed
+			hash, _ := util.HashObjectWith(genesis.HashAlgorithm, *ev)
+			parents[i][hash] = ev.ParentHash
+		}
+	}
+
+	for _, timestamp := range doc.Timestamps {
+		for i, candidate := range candidates {
+			if traceRoot(parents[i], timestamp) == candidate.Hash() {
+				return candidate
+			}
+		}
+	}
+	return nil
+}
+
+// Follow parents back from a hash, to the oldest known ancestor.
+// Gives up (returning "") on cycles.
+func traceRoot(parents map[string]string, hash string) string {
+	for steps := 0; steps <= len(parents); steps++ {
+		parent, ok := parents[hash]
+		if !ok {
+			return ""
+		}
+		if parent == "" {
+			return hash
+		}
+		hash = parent
+	}
+	return ""
+}
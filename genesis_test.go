@@ -0,0 +1,295 @@
+package deje
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// The key that test documents are created with.
+var creatorKey = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0xc0}, ed25519.SeedSize))
+
+func TestSimpleClient_CreateGenesis(t *testing.T) {
+	lts := setupLoopback(t, 2)
+	topic := "deje://loopback/genesis"
+	writer := NewSimpleClientWithTransport(topic, lts[0], nil)
+	reader := NewSimpleClientWithTransport(topic, lts[1], nil)
+	for _, sc := range []*SimpleClient{writer, reader} {
+		if err := sc.Connect(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	genesis, err := writer.CreateGenesis(creatorKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := genesis.GetGenesis()
+	assert.NoError(t, err)
+	assert.Equal(t, topic, info.Topic)
+	assert.Equal(t, hex.EncodeToString(creatorKey.Public().(ed25519.PublicKey)), info.Creator)
+	assert.NoError(t, info.Verify())
+	assert.Equal(t, genesis.Hash(), writer.GetTip().Hash())
+
+	_, err = writer.CreateGenesis(creatorKey, nil)
+	assert.EqualError(t, err, "Document already has a genesis")
+
+	event, err := writer.Set([]interface{}{"hello"}, "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, genesis.Hash(), event.ParentHash)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*timeout)
+	defer cancel()
+	if err := reader.WaitForTip(ctx, event.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	reader.WithDocument(func(doc *document.Document) {
+		assert.Equal(t, genesis.Hash(), doc.GenesisHash)
+	})
+	assert.Equal(t, map[string]interface{}{"hello": "world"}, reader.Export())
+
+	assert.NoError(t, writer.Close())
+	_, err = writer.CreateGenesis(creatorKey, nil)
+	assert.Equal(t, ErrClosed, err)
+}
+
+// Deliver events to a SimpleClient as if they came from a peer.
+func receiveEvents(sc *SimpleClient, events ...document.Event) error {
+	serialized := make([]interface{}, len(events))
+	for i, ev := range events {
+		if err := util.CloneMarshal(ev, &serialized[i]); err != nil {
+			return err
+		}
+	}
+	return sc.onRcv(map[string]interface{}{
+		"type":   "02-publish-events",
+		"events": serialized,
+	})
+}
+
+// Create a GENESIS Event in a scratch document, signed if a key is
+// given.
+func newGenesis(t *testing.T, g document.Genesis, key ed25519.PrivateKey) document.Event {
+	doc := document.NewDocument()
+	genesis, err := doc.CreateGenesis(g, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *genesis
+}
+
+func TestSimpleClient_AdoptGenesis(t *testing.T) {
+	topic := "deje://loopback/adopt"
+	bad_genesis := document.NewEvent(document.GenesisHandler)
+	other_topic := newGenesis(t, document.Genesis{Topic: "deje://loopback/other"}, creatorKey)
+	unsigned := newGenesis(t, document.Genesis{Topic: topic, Creator: "someone else"}, nil)
+	genesis := newGenesis(t, document.Genesis{Topic: topic, HashAlgorithm: util.SHA1}, creatorKey)
+	broken := document.NewEvent(document.GenesisHandler)
+	broken.Arguments["hash_algorithm"] = "sha1"
+
+	tests := []struct {
+		Description string
+		Pinned      string
+		Event       document.Event
+		Adopted     string
+		Error       string
+	}{
+		{"Invalid", "", bad_genesis, "", "GENESIS event has no topic"},
+		{"Wrong topic", "", other_topic, "",
+			"GENESIS event is for another topic: 'deje://loopback/other'"},
+		{"Unsigned", "", unsigned, "", "Bad creator key: 'someone else'"},
+		{"Another genesis is pinned", genesis.Hash(), unsigned, genesis.Hash(), ""},
+		{"Pinned", genesis.Hash(), genesis, genesis.Hash(), ""},
+		{"Pinned, but broken", broken.Hash(), broken, broken.Hash(),
+			"GENESIS event has no topic"},
+		{"The only candidate", "", genesis, genesis.Hash(), ""},
+	}
+	for _, test := range tests {
+		sc := NewSimpleClientWithTransport(topic, NewLoopbackHub().NewTransport(), nil)
+		sub := sc.Subscribe(NotifyError, 10)
+		sc.WithDocument(func(doc *document.Document) {
+			doc.GenesisHash = test.Pinned
+		})
+
+		assert.NoError(t, receiveEvents(sc, test.Event), test.Description)
+		sc.WithDocument(func(doc *document.Document) {
+			assert.Equal(t, test.Adopted, doc.GenesisHash, test.Description)
+		})
+		if test.Error != "" {
+			n := <-sub.C
+			assert.EqualError(t, n.Err, test.Error, test.Description)
+		}
+		assert.Equal(t, 0, len(sub.C), test.Description)
+		sub.Close()
+	}
+}
+
+// A GENESIS Event that comes after its children, in the same batch,
+// still decides how they're hashed.
+func TestSimpleClient_AdoptGenesis_Batch(t *testing.T) {
+	topic := "deje://loopback/adopt-batch"
+	source := document.NewDocument()
+	genesis, err := source.CreateGenesis(document.Genesis{
+		Topic:         topic,
+		HashAlgorithm: util.SHA512,
+	}, creatorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := source.NewEvent("SET")
+	child.Arguments["path"] = []interface{}{"hello"}
+	child.Arguments["value"] = "world"
+	child.SetParent(*genesis)
+	child.Register()
+
+	sc := NewSimpleClientWithTransport(topic, NewLoopbackHub().NewTransport(), nil)
+	if err := receiveEvents(sc, child, *genesis); err != nil {
+		t.Fatal(err)
+	}
+	sc.WithDocument(func(doc *document.Document) {
+		assert.Equal(t, genesis.Hash(), doc.GenesisHash)
+		assert.Contains(t, doc.Events, child.Hash())
+		assert.Len(t, doc.Events, 2)
+	})
+}
+
+// When there's more than one candidate, the timestamps decide.
+func TestSimpleClient_AdoptGenesis_Timestamps(t *testing.T) {
+	topic := "deje://loopback/adopt-timestamps"
+	source := document.NewDocument()
+	genesis, err := source.CreateGenesis(document.Genesis{
+		Topic:         topic,
+		HashAlgorithm: util.SHA512,
+	}, creatorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := source.NewEvent("SET")
+	child.Arguments["path"] = []interface{}{"hello"}
+	child.Arguments["value"] = "world"
+	child.SetParent(*genesis)
+	child.Register()
+	impostor_key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{9}, ed25519.SeedSize))
+	impostor := newGenesis(t, document.Genesis{Topic: topic}, impostor_key)
+
+	sc := NewSimpleClientWithTransport(topic, NewLoopbackHub().NewTransport(), nil)
+	if err := receiveEvents(sc, impostor, *genesis); err != nil {
+		t.Fatal(err)
+	}
+	sc.WithDocument(func(doc *document.Document) {
+		assert.Equal(t, "", doc.GenesisHash, "No way to tell yet")
+	})
+
+	// Timestamps for Events we haven't got yet don't help
+	err = sc.onRcv(map[string]interface{}{
+		"type":       "02-publish-timestamps",
+		"timestamps": []interface{}{"unknown", child.Hash()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.WithDocument(func(doc *document.Document) {
+		assert.Equal(t, "", doc.GenesisHash)
+	})
+
+	// Once they turn up, they're hashed the right way
+	if err := receiveEvents(sc, child); err != nil {
+		t.Fatal(err)
+	}
+	sc.WithDocument(func(doc *document.Document) {
+		assert.Equal(t, genesis.Hash(), doc.GenesisHash)
+		assert.Contains(t, doc.Events, child.Hash())
+	})
+}
+
+// A peer that joins late gets the whole document in one batch.
+func TestSimpleClient_AdoptGenesis_LateJoin(t *testing.T) {
+	hub := NewLoopbackHub()
+	topic := "deje://loopback/adopt-late"
+	writer := NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+	if err := writer.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	writer.WithDocument(func(doc *document.Document) {
+		doc.HashAlgorithm = util.SHA512
+	})
+	if _, err := writer.CreateGenesis(creatorKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	var last *document.Event
+	for i := 0; i < 5; i++ {
+		var err error
+		last, err = writer.Set([]interface{}{"count"}, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader := NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+	if err := reader.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*timeout)
+	defer cancel()
+	if err := reader.WaitForTip(ctx, last.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	reader.WithDocument(func(doc *document.Document) {
+		assert.Len(t, doc.Events, 6)
+		for hash := range doc.Events {
+			assert.Equal(t, "1340", hash[:4])
+		}
+	})
+	assert.Equal(t, map[string]interface{}{"count": 4.0}, reader.Export())
+}
+
+func TestTraceRoot(t *testing.T) {
+	parents := map[string]string{
+		"root":  "",
+		"child": "root",
+		"a":     "b",
+		"b":     "a",
+	}
+	assert.Equal(t, "root", traceRoot(parents, "child"))
+	assert.Equal(t, "", traceRoot(parents, "missing"))
+	assert.Equal(t, "", traceRoot(parents, "a"), "Cycles give up")
+}
+
+func TestSimpleClient_AdoptGenesis_Do(t *testing.T) {
+	topic := "deje://loopback/adopt-do"
+	source := document.NewDocument()
+	genesis, err := source.CreateGenesis(document.Genesis{
+		Topic:         topic,
+		HashAlgorithm: util.SHA1,
+	}, creatorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc := NewSimpleClientWithTransport(topic, NewLoopbackHub().NewTransport(), nil)
+	if err := receiveEvents(sc, *genesis); err != nil {
+		t.Fatal(err)
+	}
+	sc.WithDocument(func(doc *document.Document) {
+		assert.Equal(t, genesis.Hash(), doc.GenesisHash)
+		assert.Equal(t, util.SHA1, doc.HashAlgorithm)
+	})
+	assert.Nil(t, sc.GetTip(), "Nothing has been timestamped yet")
+
+	// Builds on the genesis, since there's no tip, and is hashed the
+	// genesis's way
+	event, err := sc.Set([]interface{}{"hello"}, "world")
+	if assert.NoError(t, err) {
+		assert.Equal(t, genesis.Hash(), event.ParentHash)
+		assert.Equal(t, "1114", event.Hash()[:4])
+		assert.Equal(t, event.Hash(), sc.GetTip().Hash())
+	}
+}
@@ -0,0 +1,169 @@
+package deje
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/util"
+)
+
+// What a Handler serves at its root: everything Document.Serialize
+// writes, plus the tip and the exported state. Document.Deserialize
+// ignores the extras, so anything that can load a serialized Document
+// can load this.
+type DocumentSnapshot struct {
+	*document.Document
+
+	// The hash of the tip, or "" if there isn't one.
+	Tip string `json:"tip"`
+
+	State interface{} `json:"state"`
+}
+
+// Serves a SimpleClient's document over HTTP, so it can be fetched
+// without joining its topic (see FetchDocument and OpenMulti):
+//
+//	GET /               A DocumentSnapshot
+//	GET /events/<hash>  A single Event, by its hash, or any prefix of
+//	                    it that no other Event shares
+//
+// The snapshot's ETag is a hash of its contents, so clients can poll
+// cheaply with If-None-Match.
+type Handler struct {
+	sc *SimpleClient
+}
+
+func NewHandler(sc *SimpleClient) *Handler {
+	return &Handler{sc}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case path == "":
+		h.serveSnapshot(w, r)
+	case strings.HasPrefix(path, "events/"):
+		h.serveEvent(w, strings.TrimPrefix(path, "events/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	sc := h.sc
+	sc.lock()
+	snapshot := DocumentSnapshot{
+		Document: sc.GetDoc(),
+		State:    sc.GetDoc().State.Export(),
+	}
+	if sc.Tip != nil {
+		snapshot.Tip = sc.Tip.Hash()
+	}
+	body, err := json.Marshal(snapshot)
+	sc.unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	digest := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(digest[:]) + `"`
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match == etag || match == "*" {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, body)
+}
+
+func (h *Handler) serveEvent(w http.ResponseWriter, hash string) {
+	sc := h.sc
+	sc.lock()
+	var body []byte
+	event, err := sc.GetDoc().GetEventByPrefix(hash)
+	if err == nil {
+		body, err = json.Marshal(event)
+	}
+	sc.unlock()
+	switch {
+	case errors.Is(err, document.ErrNoSuchEvent):
+		http.Error(w, "No such event", http.StatusNotFound)
+	case errors.Is(err, document.ErrAmbiguousPrefix):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(w, body)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// Load a Document from the root of a Handler, or from anything else
+// that serves the output of Document.Serialize.
+//
+// If client is nil, http.DefaultClient is used.
+func FetchDocument(ctx context.Context, client *http.Client, url string) (*document.Document, error) {
+	doc := document.NewDocument()
+	err := fetch(ctx, client, url, func(response *http.Response) error {
+		return doc.Deserialize(response.Body)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Fetch a single Event from a Handler, given the Handler's URL. Fails
+// if the Event doesn't match the hash.
+//
+// If client is nil, http.DefaultClient is used.
+func FetchEvent(ctx context.Context, client *http.Client, url, hash string) (*document.Event, error) {
+	event := document.NewEvent("")
+	url = strings.TrimSuffix(url, "/") + "/events/" + hash
+	err := fetch(ctx, client, url, func(response *http.Response) error {
+		return json.NewDecoder(response.Body).Decode(&event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !util.VerifyHash(hash, event) {
+		return nil, errors.New("Event does not match its hash: '" + hash + "'")
+	}
+	return &event, nil
+}
+
+// GET a URL, and decode the response body if the request succeeds.
+func fetch(ctx context.Context, client *http.Client, url string, decode func(*http.Response) error) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.New("Bad response from '" + url + "': " + response.Status)
+	}
+	return decode(response)
+}
@@ -0,0 +1,235 @@
+package deje
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/stretchr/testify/assert"
+)
+
+// Serve a SimpleClient holding a copy of doc.
+func setupHandler(t *testing.T, doc *document.Document) (*SimpleClient, *httptest.Server) {
+	sc := NewSimpleClientWithTransport("deje://example.com/http", NewLoopbackHub().NewTransport(), nil)
+	sc.loadDocument(doc)
+	sc.ReTip()
+	return sc, httptest.NewServer(NewHandler(sc))
+}
+
+func get(t *testing.T, url string, header http.Header) (*http.Response, string) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, string(body)
+}
+
+func TestHandler_Snapshot(t *testing.T) {
+	doc, tip := multiDocument(t, "deje://example.com/http", "value")
+	sc, server := setupHandler(t, doc)
+	defer server.Close()
+
+	response, body := get(t, server.URL, nil)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+	etag := response.Header.Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{64}"$`, etag)
+
+	var snapshot map[string]interface{}
+	if assert.NoError(t, json.Unmarshal([]byte(body), &snapshot)) {
+		assert.Equal(t, tip, snapshot["tip"])
+		assert.Equal(t, map[string]interface{}{"value": "value"}, snapshot["state"])
+		assert.Equal(t, doc.GenesisHash, snapshot["genesis"])
+		assert.Len(t, snapshot["events"], 2)
+		assert.Len(t, snapshot["timestamps"], 2)
+	}
+
+	// Polling
+	response, body = get(t, server.URL, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, response.StatusCode)
+	assert.Equal(t, "", body)
+	response, _ = get(t, server.URL, http.Header{"If-None-Match": {"*"}})
+	assert.Equal(t, http.StatusNotModified, response.StatusCode)
+
+	// Changes show up, even if the tip stays put
+	side := sc.GetDoc().NewEvent("SET")
+	side.Arguments["path"] = []interface{}{"value"}
+	side.Arguments["value"] = "side"
+	side.ParentHash = doc.GenesisHash
+	register(sc, &side)
+	assert.Equal(t, tip, sc.GetTip().Hash())
+	response, _ = get(t, server.URL, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.NotEqual(t, etag, response.Header.Get("ETag"))
+	etag = response.Header.Get("ETag")
+
+	if _, err := sc.Set([]interface{}{"value"}, "changed"); err != nil {
+		t.Fatal(err)
+	}
+	response, _ = get(t, server.URL, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.NotEqual(t, etag, response.Header.Get("ETag"))
+
+	// No tip yet
+	empty := NewSimpleClientWithTransport("deje://example.com/empty", NewLoopbackHub().NewTransport(), nil)
+	empty_server := httptest.NewServer(NewHandler(empty))
+	defer empty_server.Close()
+	response, body = get(t, empty_server.URL, nil)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Regexp(t, `^"[0-9a-f]{64}"$`, response.Header.Get("ETag"))
+	assert.Contains(t, body, `"tip":""`)
+}
+
+// Events can be asked for by a prefix of their hash, like in GetEvent.
+func TestHandler_Event(t *testing.T) {
+	doc, tip := multiDocument(t, "deje://example.com/http", "value")
+	_, server := setupHandler(t, doc)
+	defer server.Close()
+
+	for _, hash := range []string{tip, tip[:10]} {
+		response, body := get(t, server.URL+"/events/"+hash, nil)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var event document.Event
+		if assert.NoError(t, json.Unmarshal([]byte(body), &event)) {
+			assert.Equal(t, tip, event.Hash())
+		}
+	}
+
+	// Every hash starts with the same multihash prefix
+	response, body := get(t, server.URL+"/events/1220", nil)
+	assert.Equal(t, http.StatusConflict, response.StatusCode)
+	assert.Contains(t, body, "Ambiguous hash prefix: '1220'")
+}
+
+func TestHandler_Errors(t *testing.T) {
+	doc, _ := multiDocument(t, "deje://example.com/http", "value")
+	_, server := setupHandler(t, doc)
+	defer server.Close()
+
+	response, _ := get(t, server.URL+"/events/missing", nil)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	response, _ = get(t, server.URL+"/other", nil)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	response, err := http.Post(server.URL, "application/json", nil)
+	if assert.NoError(t, err) {
+		response.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+		assert.Equal(t, "GET, HEAD", response.Header.Get("Allow"))
+	}
+	response, err = http.Head(server.URL)
+	if assert.NoError(t, err) {
+		response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+}
+
+func TestHandler_Unserializable(t *testing.T) {
+	doc, _ := multiDocument(t, "deje://example.com/http", "value")
+	sc, server := setupHandler(t, doc)
+	defer server.Close()
+
+	// Only possible with local tampering, since everything else came
+	// from JSON
+	sc.lock()
+	sc.GetDoc().Events["bad"] = &document.Event{
+		HandlerName: "SET",
+		Arguments:   map[string]interface{}{"value": math.NaN()},
+	}
+	sc.unlock()
+
+	response, body := get(t, server.URL+"/events/bad", nil)
+	assert.Equal(t, http.StatusInternalServerError, response.StatusCode)
+	assert.Contains(t, body, "unsupported value: NaN")
+	response, body = get(t, server.URL, nil)
+	assert.Equal(t, http.StatusInternalServerError, response.StatusCode)
+	assert.Contains(t, body, "unsupported value: NaN")
+}
+
+func TestFetchDocument(t *testing.T) {
+	doc, tip := multiDocument(t, "deje://example.com/http", "value")
+	_, server := setupHandler(t, doc)
+	defer server.Close()
+
+	// Mounted somewhere other than the root
+	mux := http.NewServeMux()
+	mux.Handle("/docs/", http.StripPrefix("/docs", server.Config.Handler))
+	mounted := httptest.NewServer(mux)
+	defer mounted.Close()
+
+	for _, url := range []string{server.URL, mounted.URL + "/docs/"} {
+		fetched, err := FetchDocument(context.Background(), nil, url)
+		if !assert.NoError(t, err, url) {
+			continue
+		}
+		assert.Equal(t, doc.GenesisHash, fetched.GenesisHash)
+		assert.Equal(t, doc.Timestamps, fetched.Timestamps)
+		assert.Len(t, fetched.Events, len(doc.Events))
+		assert.Contains(t, fetched.Events, tip)
+	}
+
+	_, err := FetchDocument(context.Background(), nil, server.URL+"/nope")
+	assert.EqualError(t, err, "Bad response from '"+server.URL+"/nope': 404 Not Found")
+	_, err = FetchDocument(context.Background(), nil, "http://%zz/")
+	assert.Error(t, err)
+}
+
+func TestFetchEvent(t *testing.T) {
+	doc, tip := multiDocument(t, "deje://example.com/http", "value")
+	_, server := setupHandler(t, doc)
+	defer server.Close()
+
+	event, err := FetchEvent(context.Background(), server.Client(), server.URL+"/", tip)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "SET", event.HandlerName)
+		assert.Equal(t, doc.GenesisHash, event.ParentHash)
+		assert.Equal(t, "value", event.Arguments["value"])
+	}
+
+	_, err = FetchEvent(context.Background(), nil, server.URL, "missing")
+	assert.EqualError(t, err,
+		"Bad response from '"+server.URL+"/events/missing': 404 Not Found")
+
+	// Doesn't take a server's word for it
+	liar := serveBody(`{"parent":"","handler":"SET","args":{}}`, http.StatusOK)
+	defer liar.Close()
+	_, err = FetchEvent(context.Background(), nil, liar.URL, tip)
+	assert.EqualError(t, err, "Event does not match its hash: '"+tip+"'")
+	garbage := serveBody("{", http.StatusOK)
+	defer garbage.Close()
+	_, err = FetchEvent(context.Background(), nil, garbage.URL, tip)
+	assert.EqualError(t, err, "unexpected EOF")
+}
+
+func TestOpenMulti_Handler(t *testing.T) {
+	doc, tip := multiDocument(t, "deje://example.com/http", "value")
+	_, server := setupHandler(t, doc)
+	defer server.Close()
+	other := serveDocument(t, doc)
+	defer other.Close()
+
+	sc, dissent, err := OpenMulti(context.Background(),
+		[]string{server.URL, other.URL}, MultiOptions{})
+	if assert.NoError(t, err) {
+		defer sc.Close()
+		assert.Len(t, dissent, 0)
+		assert.Equal(t, tip, sc.GetTip().Hash())
+	}
+}
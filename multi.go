@@ -0,0 +1,226 @@
+package deje
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/state"
+	"github.com/DJDNS/go-deje/timestamps"
+)
+
+// Settings for OpenMulti. The zero value is fine.
+type MultiOptions struct {
+	// How many sources must agree on the tip. If zero, a majority of
+	// them must.
+	Agree int
+
+	Logger   *log.Logger
+	Callback state.OnPrimitiveCallback
+
+	// Used for http:// and https:// sources. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+// A place OpenMulti got a copy of the document from, and what it found
+// there.
+type Source struct {
+	URL string
+
+	// The hash of the tip, or "" if the source's document has no tip.
+	Tip string
+
+	// Why the document couldn't be fetched, if it couldn't.
+	Err error
+
+	client *SimpleClient
+	doc    *document.Document
+}
+
+// Returned by OpenMulti when not enough sources agree on a tip.
+type DisagreementError struct {
+	Agree   int
+	Sources []Source
+}
+
+func (e *DisagreementError) Error() string {
+	return "Fewer than " + strconv.Itoa(e.Agree) + " of " +
+		strconv.Itoa(len(e.Sources)) + " sources agree on the tip"
+}
+
+// Like Open, but bootstraps the document from several sources, and
+// only accepts it if enough of them agree on the tip (see
+// MultiOptions.Agree). This way, no single source can feed us a bogus
+// history.
+//
+// Sources can be deje:// or dejes:// URLs, which are opened and synced
+// (see Sync), or http:// and https:// URLs serving the output of
+// document.Document.Serialize, such as a Handler. Routers with no other peers on them
+// never answer, so they hold things up until ctx is done, and then
+// count as failures.
+//
+// The returned SimpleClient stays connected to the first agreeing
+// deje:// source. If only HTTP sources agree, it isn't connected to
+// anything, and has the agreed document, with the genesis's topic.
+// That's an error if the document has no genesis. Either way, the sources that failed or disagreed are
+// returned too. If not enough sources agree, the error is a
+// *DisagreementError.
+func OpenMulti(ctx context.Context, urls []string, opts MultiOptions) (*SimpleClient, []Source, error) {
+	agree := opts.Agree
+	if agree == 0 {
+		agree = len(urls)/2 + 1
+	}
+
+	sources := make([]Source, len(urls))
+	done := make(chan struct{})
+	for i, source_url := range urls {
+		sources[i].URL = source_url
+		go func(source *Source) {
+			source.fetch(ctx, opts)
+			done <- struct{}{}
+		}(&sources[i])
+	}
+	for range urls {
+		<-done
+	}
+
+	// The most popular tip wins, with ties going to whichever source
+	// comes first
+	counts := make(map[string]int)
+	var winner string
+	for _, source := range sources {
+		if source.Err != nil {
+			continue
+		}
+		counts[source.Tip]++
+		if counts[source.Tip] > counts[winner] {
+			winner = source.Tip
+		}
+	}
+
+	var client *SimpleClient
+	var agreed *document.Document
+	var dissent []Source
+	for _, source := range sources {
+		if source.Err != nil || source.Tip != winner {
+			dissent = append(dissent, source)
+		} else if client == nil && source.client != nil {
+			client = source.client
+			continue
+		} else if agreed == nil && source.doc != nil {
+			agreed = source.doc
+		}
+		if source.client != nil {
+			source.client.Close()
+		}
+	}
+
+	if counts[winner] < agree {
+		if client != nil {
+			client.Close()
+		}
+		return nil, dissent, &DisagreementError{agree, sources}
+	}
+	if client == nil {
+		topic, err := genesisTopic(agreed)
+		if err != nil {
+			return nil, dissent, err
+		}
+		client = NewSimpleClient(topic, opts.Logger)
+		client.loadDocument(agreed)
+	}
+
+	// Play through the document again, for the callback's benefit
+	client.SetPrimitiveCallback(opts.Callback)
+	client.ReTip()
+	return client, dissent, nil
+}
+
+// Fill in Tip, or Err, by fetching the document from the source's URL.
+func (source *Source) fetch(ctx context.Context, opts MultiOptions) {
+	parsed, err := url.Parse(source.URL)
+	if err != nil {
+		source.Err = err
+		return
+	}
+
+	switch parsed.Scheme {
+	case "deje", "dejes":
+		u, err := ParseDejeURL(source.URL)
+		if err != nil {
+			source.Err = err
+			return
+		}
+		// No callback, since we may not keep this one
+		sc, err := openURL(u, opts.Logger, nil)
+		if err != nil {
+			source.Err = err
+			return
+		}
+		if err := sc.Sync(ctx); err != nil {
+			sc.Close()
+			source.Err = err
+			return
+		}
+		source.client = sc
+		if tip := sc.GetTip(); tip != nil {
+			source.Tip = tip.Hash()
+		}
+	case "http", "https":
+		doc, err := FetchDocument(ctx, opts.HTTPClient, source.URL)
+		if err != nil {
+			source.Err = err
+			return
+		}
+		// PeerTimestampServices never fail
+		tt := timestamps.NewTimestampTracker(doc, timestamps.NewPeerTimestampService(doc))
+		tip, _ := tt.FindLatest()
+		source.doc = doc
+		if tip != nil {
+			source.Tip = tip.Hash()
+		}
+	default:
+		source.Err = errors.New("Unsupported source URL: '" + source.URL + "'")
+	}
+}
+
+// The topic named by the document's genesis.
+func genesisTopic(doc *document.Document) (string, error) {
+	genesis, err := doc.Genesis()
+	if err != nil {
+		return "", err
+	}
+	g, err := genesis.GetGenesis()
+	if err != nil {
+		return "", err
+	}
+	return g.Topic, nil
+}
+
+// Copy source's contents into the document. Call ReTip afterwards.
+func (sc *SimpleClient) loadDocument(source *document.Document) {
+	sc.lock()
+	defer sc.unlock()
+
+	doc := sc.GetDoc()
+	for _, ev := range source.Events {
+		copied := *ev
+		copied.Doc = doc
+		copied.Register()
+	}
+	// The genesis lists the acceptors, so it goes before the votes
+	if source.GenesisHash != "" {
+		doc.SetGenesis(source.GenesisHash)
+	}
+	for _, by_acceptor := range source.Votes {
+		for _, vote := range by_acceptor {
+			doc.AddVote(vote)
+		}
+	}
+	doc.Timestamps = append([]string{}, source.Timestamps...)
+}
@@ -0,0 +1,320 @@
+package deje
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/state"
+	"github.com/stretchr/testify/assert"
+)
+
+// A document with a genesis and one timestamped edit, which sets
+// "value" to the given string. Documents made with different values
+// are competing forks. Returns the document and the edit's hash.
+func multiDocument(t *testing.T, topic, value string) (*document.Document, string) {
+	doc := document.NewDocument()
+	genesis, err := doc.CreateGenesis(document.Genesis{Topic: topic}, creatorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := doc.NewEvent("SET")
+	event.Arguments["path"] = []interface{}{"value"}
+	event.Arguments["value"] = value
+	event.SetParent(*genesis)
+	event.Register()
+	doc.Timestamps = []string{genesis.Hash(), event.Hash()}
+	return &doc, event.Hash()
+}
+
+// Serve a document the way OpenMulti expects to find it.
+func serveDocument(t *testing.T, doc *document.Document) *httptest.Server {
+	var buffer bytes.Buffer
+	if err := doc.Serialize(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	return serveBody(buffer.String(), http.StatusOK)
+}
+
+func serveBody(body string, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+}
+
+func sourceURLs(sources []Source) []string {
+	urls := make([]string, len(sources))
+	for i, source := range sources {
+		urls[i] = source.URL
+	}
+	return urls
+}
+
+func TestOpenMulti_HTTP(t *testing.T) {
+	topic := "deje://example.com/multi"
+	good_doc, good_tip := multiDocument(t, topic, "good")
+	good := serveDocument(t, good_doc)
+	defer good.Close()
+	fork_doc, fork_tip := multiDocument(t, topic, "fork")
+	fork := serveDocument(t, fork_doc)
+	defer fork.Close()
+
+	var primitives int
+	callback := func(state.Primitive) { primitives++ }
+	sc, dissent, err := OpenMulti(context.Background(),
+		[]string{good.URL, fork.URL, good.URL},
+		MultiOptions{Callback: callback},
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer sc.Close()
+
+	if assert.Len(t, dissent, 1) {
+		assert.Equal(t, fork.URL, dissent[0].URL)
+		assert.Equal(t, fork_tip, dissent[0].Tip)
+		assert.NoError(t, dissent[0].Err)
+	}
+	assert.Equal(t, topic, sc.GetTopic())
+	assert.Equal(t, good_tip, sc.GetTip().Hash())
+	assert.Equal(t, map[string]interface{}{"value": "good"}, sc.Export())
+	assert.NotEqual(t, 0, primitives, "Callback was called")
+	assert.Equal(t, StateDisconnected, sc.ConnectionState())
+}
+
+func TestOpenMulti_Disagreement(t *testing.T) {
+	topic := "deje://example.com/multi"
+	good_doc, good_tip := multiDocument(t, topic, "good")
+	good := serveDocument(t, good_doc)
+	defer good.Close()
+	fork_doc, fork_tip := multiDocument(t, topic, "fork")
+	fork := serveDocument(t, fork_doc)
+	defer fork.Close()
+	missing := serveBody("Not here", http.StatusNotFound)
+	defer missing.Close()
+	garbage := serveBody("{", http.StatusOK)
+	defer garbage.Close()
+
+	urls := []string{good.URL, fork.URL, missing.URL, garbage.URL, "ftp://example.com/"}
+	sc, dissent, err := OpenMulti(context.Background(), urls, MultiOptions{})
+	assert.Nil(t, sc)
+	assert.EqualError(t, err, "Fewer than 3 of 5 sources agree on the tip")
+	if d_err, ok := err.(*DisagreementError); assert.True(t, ok) {
+		assert.Equal(t, 3, d_err.Agree)
+		assert.Equal(t, urls, sourceURLs(d_err.Sources))
+		assert.Equal(t, good_tip, d_err.Sources[0].Tip)
+	}
+
+	// The first tip wins ties
+	assert.Equal(t, urls[1:], sourceURLs(dissent))
+	if !assert.Len(t, dissent, 4) {
+		return
+	}
+	assert.Equal(t, fork_tip, dissent[0].Tip)
+	assert.EqualError(t, dissent[1].Err,
+		"Bad response from '"+missing.URL+"': 404 Not Found")
+	assert.EqualError(t, dissent[2].Err, "unexpected EOF")
+	assert.EqualError(t, dissent[3].Err, "Unsupported source URL: 'ftp://example.com/'")
+
+	// Settling for less
+	sc, dissent, err = OpenMulti(context.Background(), urls, MultiOptions{Agree: 1})
+	if assert.NoError(t, err) {
+		defer sc.Close()
+		assert.Equal(t, good_tip, sc.GetTip().Hash())
+		assert.Len(t, dissent, 4)
+	}
+
+	// Unreachable and malformed URLs fail like any other source
+	_, dissent, err = OpenMulti(context.Background(),
+		[]string{"http://127.0.0.1:1/", "http://%zz/"}, MultiOptions{})
+	assert.Error(t, err)
+	if assert.Len(t, dissent, 2) {
+		assert.Error(t, dissent[0].Err)
+		assert.Error(t, dissent[1].Err)
+	}
+}
+
+func TestOpenMulti_Schemes(t *testing.T) {
+	urls := []string{
+		"dejes://127.0.0.1:1/multi",
+		"deje://example.com/multi?timestamps=bogus",
+		"deje://%zz/multi",
+		"example.com/multi",
+	}
+	_, dissent, err := OpenMulti(context.Background(), urls, MultiOptions{})
+	assert.Error(t, err)
+	if !assert.Len(t, dissent, 4) {
+		return
+	}
+	if assert.Error(t, dissent[0].Err, "Dialed, and failed") {
+		assert.NotContains(t, dissent[0].Err.Error(), "Unsupported source URL")
+	}
+	assert.EqualError(t, dissent[1].Err, "Unknown timestamp service: 'bogus'")
+	assert.Error(t, dissent[2].Err)
+	assert.EqualError(t, dissent[3].Err, "Unsupported source URL: 'example.com/multi'")
+}
+
+func TestOpenMulti_NoGenesis(t *testing.T) {
+	doc := document.NewDocument()
+	event := doc.NewEvent("SET")
+	event.Arguments["path"] = []interface{}{"value"}
+	event.Arguments["value"] = "orphan"
+	event.Register()
+	doc.Timestamps = []string{event.Hash()}
+	server := serveDocument(t, &doc)
+	defer server.Close()
+
+	// They agree, but on a document that doesn't say what it's for
+	sc, dissent, err := OpenMulti(context.Background(),
+		[]string{server.URL, server.URL}, MultiOptions{})
+	assert.Nil(t, sc)
+	assert.Empty(t, dissent)
+	assert.EqualError(t, err, "Document has no genesis")
+
+	// Or whose genesis isn't one
+	doc.GenesisHash = event.Hash()
+	_, err = genesisTopic(&doc)
+	assert.EqualError(t, err, "Not a GENESIS event")
+}
+
+func TestOpenMulti_Votes(t *testing.T) {
+	topic := "deje://example.com/multi"
+	keys, ids := acceptorKeys(1)
+	doc := document.NewDocument()
+	genesis, err := doc.CreateGenesis(document.Genesis{
+		Topic:       topic,
+		Permissions: map[string]interface{}{"acceptors": ids},
+	}, creatorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Timestamps = []string{genesis.Hash()}
+	if err := doc.AddVote(document.NewVote(genesis.Hash(), keys[0])); err != nil {
+		t.Fatal(err)
+	}
+	server := serveDocument(t, &doc)
+	defer server.Close()
+
+	// Votes come along with the document, and count once it has its
+	// genesis
+	sc, _, err := OpenMulti(context.Background(), []string{server.URL}, MultiOptions{})
+	if assert.NoError(t, err) {
+		defer sc.Close()
+		assert.True(t, sc.HasQuorum(genesis.Hash()))
+	}
+}
+
+func TestOpenMulti_Router(t *testing.T) {
+	server_addr, server_closer := setupServer()
+	defer server_closer()
+	deje_url := strings.Replace(server_addr, "ws://", "deje://", 1) + "/multi"
+	_, topic, err := GetRouterAndTopic(deje_url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A peer on the router, to answer with its copy of the document
+	good_doc, good_tip := multiDocument(t, topic, "good")
+	peer := NewSimpleClient(topic, nil)
+	peer.loadDocument(good_doc)
+	peer.ReTip()
+	if err := peer.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	good := serveDocument(t, good_doc)
+	defer good.Close()
+	fork_doc, _ := multiDocument(t, topic, "fork")
+	fork := serveDocument(t, fork_doc)
+	defer fork.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*timeout)
+	defer cancel()
+	urls := []string{fork.URL, deje_url, good.URL, "deje://127.0.0.1:1/multi"}
+	sc, dissent, err := OpenMulti(ctx, urls, MultiOptions{Agree: 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer sc.Close()
+
+	assert.Equal(t, []string{fork.URL, urls[3]}, sourceURLs(dissent))
+	assert.Error(t, dissent[1].Err)
+	assert.Equal(t, StateConnected, sc.ConnectionState())
+	assert.Equal(t, topic, sc.GetTopic())
+	assert.Equal(t, good_tip, sc.GetTip().Hash())
+	assert.Equal(t, map[string]interface{}{"value": "good"}, sc.Export())
+
+	// Still connected, so it keeps up with the peer
+	event, err := peer.Set([]interface{}{"value"}, "newer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NoError(t, sc.WaitForTip(ctx, event.Hash())) {
+		assert.Equal(t, map[string]interface{}{"value": "newer"}, sc.Export())
+	}
+
+	// Routers that disagree, or aren't needed, are let go
+	sc2, dissent, err := OpenMulti(ctx, []string{good.URL, fork.URL, deje_url},
+		MultiOptions{Agree: 1})
+	if assert.NoError(t, err) {
+		defer sc2.Close()
+		assert.Equal(t, StateDisconnected, sc2.ConnectionState())
+		assert.Len(t, dissent, 2)
+		assert.True(t, dissent[1].client.IsClosed())
+	}
+}
+
+func TestOpenMulti_RouterFailures(t *testing.T) {
+	server_addr, server_closer := setupServer()
+	defer server_closer()
+	deje_url := strings.Replace(server_addr, "ws://", "deje://", 1) + "/multi"
+	_, topic, err := GetRouterAndTopic(deje_url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	good_doc, good_tip := multiDocument(t, topic, "good")
+	peer := NewSimpleClient(topic, nil)
+	peer.loadDocument(good_doc)
+	peer.ReTip()
+	if err := peer.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	fork_doc, fork_tip := multiDocument(t, topic, "fork")
+	fork := serveDocument(t, fork_doc)
+	defer fork.Close()
+
+	// Nobody answers on this topic, so syncing never finishes
+	ctx, cancel := context.WithTimeout(context.Background(), 2*timeout)
+	defer cancel()
+	lonely_url := strings.Replace(server_addr, "ws://", "deje://", 1) + "/lonely"
+	sc, dissent, err := OpenMulti(ctx, []string{lonely_url, fork.URL}, MultiOptions{Agree: 1})
+	if assert.NoError(t, err) {
+		defer sc.Close()
+		assert.Equal(t, fork_tip, sc.GetTip().Hash())
+	}
+	if assert.Len(t, dissent, 1) {
+		assert.Equal(t, lonely_url, dissent[0].URL)
+		assert.Equal(t, context.DeadlineExceeded, dissent[0].Err)
+	}
+
+	// A router that disagrees with everyone else is let go, even
+	// though its tip wins the tie
+	ctx, cancel = context.WithTimeout(context.Background(), 20*timeout)
+	defer cancel()
+	sc, dissent, err = OpenMulti(ctx, []string{deje_url, fork.URL}, MultiOptions{})
+	assert.Nil(t, sc)
+	assert.EqualError(t, err, "Fewer than 2 of 2 sources agree on the tip")
+	if d_err, ok := err.(*DisagreementError); assert.True(t, ok) {
+		assert.Equal(t, good_tip, d_err.Sources[0].Tip)
+		assert.True(t, d_err.Sources[0].client.IsClosed())
+	}
+	assert.Equal(t, []string{fork.URL}, sourceURLs(dissent))
+}
@@ -0,0 +1,62 @@
+package deje
+
+import (
+	"errors"
+
+	"github.com/DJDNS/go-deje/document"
+)
+
+// Returned when trying to edit a pinned document. See Pin.
+var ErrPinned = errors.New("Document is pinned")
+
+// Pin the document to an Event, given its hash or an unambiguous
+// prefix of it, instead of following the latest tip. This makes the
+// document read-only: edits fail with ErrPinned. Events, timestamps
+// and votes are still synced with peers, though, so a pinned client
+// keeps a full copy of the document.
+//
+// If the Event hasn't arrived yet, there is no tip until it does (see
+// WaitForTip). Once found, a prefix stays pinned to the same Event,
+// even if later Events make it ambiguous. Fails if the prefix is
+// already ambiguous.
+func (sc *SimpleClient) Pin(hash_prefix string) error {
+	sc.lock()
+	defer sc.unlock()
+	_, err := sc.GetDoc().GetEventByPrefix(hash_prefix)
+	if err != nil && !errors.Is(err, document.ErrNoSuchEvent) {
+		return err
+	}
+	sc.pin = hash_prefix
+	sc.pinned = nil
+	sc.reTip()
+	return nil
+}
+
+// Go back to following the latest tip.
+func (sc *SimpleClient) Unpin() {
+	sc.lock()
+	defer sc.unlock()
+	sc.pin = ""
+	sc.pinned = nil
+	sc.reTip()
+}
+
+// The hash prefix given to Pin, or "" if the document isn't pinned.
+func (sc *SimpleClient) Pinned() string {
+	sc.lock()
+	defer sc.unlock()
+	return sc.pin
+}
+
+// The pinned Event, or nil if it hasn't arrived yet. Must be called
+// with sc.mutex held.
+func (sc *SimpleClient) findPinned() *document.Event {
+	if sc.pinned == nil {
+		event, err := sc.GetDoc().GetEventByPrefix(sc.pin)
+		if err != nil {
+			return nil
+		}
+		sc.pinned = event
+	}
+	return sc.pinned
+}
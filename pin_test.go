@@ -0,0 +1,160 @@
+package deje
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimpleClient_Pin(t *testing.T) {
+	sc := NewSimpleClientWithTransport("deje://loopback/pin", NewLoopbackHub().NewTransport(), nil)
+	first, err := sc.Set([]interface{}{"value"}, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sc.Set([]interface{}{"value"}, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", sc.Pinned())
+
+	prefix := first.Hash()[:10]
+	if !assert.NoError(t, sc.Pin(prefix)) {
+		return
+	}
+	assert.Equal(t, prefix, sc.Pinned())
+	assert.Equal(t, first.Hash(), sc.GetTip().Hash())
+	assert.Equal(t, map[string]interface{}{"value": "first"}, sc.Export())
+
+	// Read-only
+	_, err = sc.Set([]interface{}{"value"}, "third")
+	assert.Equal(t, ErrPinned, err)
+	assert.Equal(t, ErrPinned, sc.Promote(*second))
+	_, err = sc.CreateGenesis(creatorKey, nil)
+	assert.Equal(t, ErrPinned, err)
+	assert.Len(t, sc.GetDoc().Events, 2)
+
+	// Still pinned after the timestamps change
+	sc.GetDoc().Timestamps = append(sc.GetDoc().Timestamps, second.Hash())
+	sc.ReTip()
+	assert.Equal(t, first.Hash(), sc.GetTip().Hash())
+
+	// Ambiguous prefixes are refused, and leave the pin alone
+	assert.True(t, errors.Is(sc.Pin(""), document.ErrAmbiguousPrefix))
+	assert.Equal(t, prefix, sc.Pinned())
+
+	// Events that haven't arrived yet mean no tip
+	assert.NoError(t, sc.Pin("missing"))
+	assert.Nil(t, sc.GetTip())
+	assert.Equal(t, map[string]interface{}{}, sc.Export())
+
+	sc.Unpin()
+	assert.Equal(t, "", sc.Pinned())
+	assert.Equal(t, second.Hash(), sc.GetTip().Hash())
+	_, err = sc.Set([]interface{}{"value"}, "third")
+	assert.NoError(t, err)
+}
+
+func TestSimpleClient_Pin_Syncs(t *testing.T) {
+	topic := "deje://loopback/pin"
+	hub := NewLoopbackHub()
+	writer := NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+	reader := NewSimpleClientWithTransport(topic, hub.NewTransport(), nil)
+	for _, sc := range []*SimpleClient{writer, reader} {
+		if err := sc.Connect(""); err != nil {
+			t.Fatal(err)
+		}
+		defer sc.Close()
+	}
+	// Let the (empty) answers to each other's requests for timestamps
+	// die down, so they don't clobber the new ones
+	<-time.After(timeout)
+
+	// Find out the hash in advance, with a scratch document
+	scratch := document.NewDocument()
+	expected := scratch.NewEvent("SET")
+	expected.Arguments["path"] = []interface{}{"value"}
+	expected.Arguments["value"] = "first"
+	if err := reader.Pin(expected.Hash()[:10]); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := writer.Set([]interface{}{"value"}, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Equal(t, expected.Hash(), first.Hash()) {
+		return
+	}
+	second, err := writer.Set([]interface{}{"value"}, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*timeout)
+	defer cancel()
+	if err := reader.WaitForTip(ctx, first.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	err = reader.waitUntil(ctx, func() bool {
+		_, ok := reader.GetDoc().Events[second.Hash()]
+		return ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, first.Hash(), reader.GetTip().Hash())
+	assert.Equal(t, map[string]interface{}{"value": "first"}, reader.Export())
+	assert.Equal(t, second.Hash(), writer.GetTip().Hash())
+}
+
+func TestOpen_Pinned(t *testing.T) {
+	server_addr, server_closer := setupServer()
+	defer server_closer()
+	deje_url := strings.Replace(server_addr, "ws://", "deje://", 1) + "/pin"
+	_, topic, err := GetRouterAndTopic(deje_url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, tip := multiDocument(t, topic, "pinned")
+	peer := NewSimpleClient(topic, nil)
+	peer.loadDocument(doc)
+	peer.ReTip()
+	if err := peer.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	sc, err := Open(deje_url+"#"+tip[:10], nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	assert.Equal(t, tip[:10], sc.Pinned())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*timeout)
+	defer cancel()
+	if assert.NoError(t, sc.Sync(ctx)) {
+		assert.Equal(t, tip, sc.GetTip().Hash())
+		assert.Equal(t, map[string]interface{}{"value": "pinned"}, sc.Export())
+	}
+
+	// The peer moves on, but we stay put
+	event, err := peer.Set([]interface{}{"value"}, "newer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sc.waitUntil(ctx, func() bool {
+		_, ok := sc.GetDoc().Events[event.Hash()]
+		return ok
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, tip, sc.GetTip().Hash())
+	}
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	_ "embed"
 	"encoding/json"
 	"errors"
 	"io"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/DJDNS/go-deje/document"
 	"github.com/DJDNS/go-deje/util"
 )
 
+// Events, with their serializations and hashes under every algorithm,
+// for other implementations to check themselves against.
+//
+//go:embed golden_events.json
+var goldenEvents []byte
+
 func deserializeDocument(r io.Reader) (interface{}, error) {
 	doc := document.NewDocument()
 	err := doc.Deserialize(r)
@@ -39,8 +47,11 @@ func formatPretty4(object interface{}) ([]byte, error) {
 func formatCompact(object interface{}) ([]byte, error) {
 	return json.Marshal(object)
 }
-func formatHash(object interface{}) ([]byte, error) {
-	hash, err := util.HashObject(object)
+func formatCanonical(object interface{}) ([]byte, error) {
+	return util.CanonicalJSON(object)
+}
+func formatHashWith(algorithm util.HashAlgorithm, object interface{}) ([]byte, error) {
+	hash, err := util.HashObjectWith(algorithm, object)
 	return []byte(hash), err
 }
 func serializeOutput(format string, object interface{}, w io.Writer) error {
@@ -52,10 +63,19 @@ func serializeOutput(format string, object interface{}, w io.Writer) error {
 		buf, err = formatPretty4(object)
 	case "compact":
 		buf, err = formatCompact(object)
+	case "canonical":
+		buf, err = formatCanonical(object)
 	case "hash":
-		buf, err = formatHash(object)
+		// Bare SHA-1, as this format has always been
+		buf, err = formatHashWith(util.LegacySHA1, object)
 	default:
-		err = errors.New("No such format: " + format)
+		// Specific algorithms, like "hash-sha2-256"
+		if strings.HasPrefix(format, "hash-") {
+			algorithm := util.HashAlgorithm(strings.TrimPrefix(format, "hash-"))
+			buf, err = formatHashWith(algorithm, object)
+		} else {
+			err = errors.New("No such format: " + format)
+		}
 	}
 	if err != nil {
 		return err
@@ -66,6 +86,12 @@ func serializeOutput(format string, object interface{}, w io.Writer) error {
 
 func main() {
 	args := os.Args
+	if len(args) == 2 && args[1] == "golden" {
+		if _, err := os.Stdout.Write(goldenEvents); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if len(args) < 3 {
 		log.Fatal("Insufficient arguments")
 	}
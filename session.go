@@ -0,0 +1,247 @@
+package deje
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// Hosts many documents over a single Transport connection.
+//
+// Each document is an ordinary SimpleClient, with its own Document,
+// TimestampTracker and callbacks. They just share the connection,
+// which the Session keeps alive on their behalf: if it drops, every
+// document goes through its usual reconnect logic, and the first one
+// to try reconnects the shared Transport for all of them.
+type Session struct {
+	transport Transport
+	logger    *log.Logger
+
+	// Held while connecting the shared Transport, which can take a
+	// while, so that only one document dials at a time.
+	dialing sync.Mutex
+
+	mutex     sync.Mutex
+	url       string
+	started   bool // Connect has been called
+	connected bool
+	closed    bool
+	docs      map[string]*sessionTransport
+}
+
+func NewSession(transport Transport, logger *log.Logger) *Session {
+	s := &Session{
+		transport: transport,
+		logger:    logger,
+		docs:      make(map[string]*sessionTransport),
+	}
+	transport.SetDisconnectCallback(s.onDisconnect)
+	return s
+}
+
+// Connect the shared Transport, and every document opened so far.
+// Documents opened after this are connected right away.
+func (s *Session) Connect(url string) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrClosed
+	}
+	s.url = url
+	s.started = true
+	s.mutex.Unlock()
+
+	if err := s.ensureConnected(url); err != nil {
+		return err
+	}
+	for _, sc := range s.Documents() {
+		if err := sc.Connect(url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start following a topic, returning its SimpleClient. If the topic is
+// already open, the existing SimpleClient is returned.
+//
+// Closing the SimpleClient unsubscribes from the topic, and removes it
+// from the Session, without affecting the shared connection.
+func (s *Session) Open(topic string) (*SimpleClient, error) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	if existing, ok := s.docs[topic]; ok {
+		s.mutex.Unlock()
+		return existing.sc, nil
+	}
+	st := &sessionTransport{session: s, topic: topic}
+	st.sc = NewSimpleClientWithTransport(topic, st, s.logger)
+	s.docs[topic] = st
+	started, url := s.started, s.url
+	s.mutex.Unlock()
+
+	if started {
+		if err := st.sc.Connect(url); err != nil {
+			st.sc.Close()
+			return nil, err
+		}
+	}
+	return st.sc, nil
+}
+
+// Get the SimpleClient for an open topic, or nil.
+func (s *Session) Document(topic string) *SimpleClient {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if st, ok := s.docs[topic]; ok {
+		return st.sc
+	}
+	return nil
+}
+
+// All open documents, sorted by topic.
+func (s *Session) Documents() []*SimpleClient {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	topics := make([]string, 0, len(s.docs))
+	for topic := range s.docs {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	docs := make([]*SimpleClient, len(topics))
+	for i, topic := range topics {
+		docs[i] = s.docs[topic].sc
+	}
+	return docs
+}
+
+// Stop following a topic. Equivalent to closing its SimpleClient.
+// Closing a topic that isn't open is not an error.
+func (s *Session) CloseDocument(topic string) error {
+	if sc := s.Document(topic); sc != nil {
+		return sc.Close()
+	}
+	return nil
+}
+
+// Close every document, then the shared connection. After this, Open
+// and Connect return ErrClosed, as does calling Close again.
+func (s *Session) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrClosed
+	}
+	s.closed = true
+	s.mutex.Unlock()
+
+	for _, sc := range s.Documents() {
+		if err := sc.Close(); err != nil && s.logger != nil {
+			s.logger.Println(err)
+		}
+	}
+
+	s.dialing.Lock()
+	defer s.dialing.Unlock()
+	s.mutex.Lock()
+	s.connected = false
+	s.mutex.Unlock()
+	return s.transport.Close()
+}
+
+// Connect the shared Transport, unless it already is. The Session
+// stays usable while it dials; Close waits for the dial to finish.
+func (s *Session) ensureConnected(url string) error {
+	s.dialing.Lock()
+	defer s.dialing.Unlock()
+
+	s.mutex.Lock()
+	closed, connected := s.closed, s.connected
+	s.mutex.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if connected {
+		return nil
+	}
+	if err := s.transport.Connect(url); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.connected = true
+	s.mutex.Unlock()
+	return nil
+}
+
+// Pass a lost connection on to every document.
+func (s *Session) onDisconnect(err error) {
+	s.mutex.Lock()
+	s.connected = false
+	callbacks := make([]OnDisconnectCallback, 0, len(s.docs))
+	for _, st := range s.docs {
+		st.mutex.Lock()
+		if st.onDisconnect != nil {
+			callbacks = append(callbacks, st.onDisconnect)
+		}
+		st.mutex.Unlock()
+	}
+	s.mutex.Unlock()
+
+	for _, callback := range callbacks {
+		callback(err)
+	}
+}
+
+// The Transport each document in a Session talks through. Everything
+// goes to the shared Transport, except that closing only detaches the
+// document from the Session.
+type sessionTransport struct {
+	session *Session
+	topic   string
+	sc      *SimpleClient
+
+	// Not session.mutex, since Open holds that while setting it up
+	mutex        sync.Mutex
+	onDisconnect OnDisconnectCallback
+}
+
+func (st *sessionTransport) Connect(url string) error {
+	return st.session.ensureConnected(url)
+}
+
+func (st *sessionTransport) Publish(topic string, event interface{}) error {
+	return st.session.transport.Publish(topic, event)
+}
+
+func (st *sessionTransport) Subscribe(topic string, handler TransportHandler) error {
+	return st.session.transport.Subscribe(topic, handler)
+}
+
+func (st *sessionTransport) Unsubscribe(topic string) error {
+	return st.session.transport.Unsubscribe(topic)
+}
+
+func (st *sessionTransport) Close() error {
+	s := st.session
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.docs[st.topic] == st {
+		delete(s.docs, st.topic)
+	}
+	return nil
+}
+
+func (st *sessionTransport) SessionID() string {
+	return st.session.transport.SessionID()
+}
+
+func (st *sessionTransport) SetDisconnectCallback(callback OnDisconnectCallback) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	st.onDisconnect = callback
+}
@@ -0,0 +1,246 @@
+package deje
+
+import (
+	"context"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var sessionTopics = []string{
+	"deje://loopback/zone/a",
+	"deje://loopback/zone/b",
+	"deje://loopback/zone/c",
+}
+
+func setupSessions(t *testing.T, hub *LoopbackHub, num int) []*Session {
+	sessions := make([]*Session, num)
+	for i := range sessions {
+		sessions[i] = NewSession(hub.NewTransport(), nil)
+		for _, topic := range sessionTopics {
+			if _, err := sessions[i].Open(topic); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := sessions[i].Connect(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sessions
+}
+
+func TestSession_Open(t *testing.T) {
+	session := NewSession(NewLoopbackHub().NewTransport(), nil)
+	sc, err := session.Open(sessionTopics[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, sessionTopics[0], sc.GetTopic())
+
+	again, err := session.Open(sessionTopics[0])
+	assert.NoError(t, err)
+	assert.True(t, sc == again, "Same topic, same SimpleClient")
+
+	assert.True(t, sc == session.Document(sessionTopics[0]))
+	assert.Nil(t, session.Document(sessionTopics[1]))
+	assert.Equal(t, []*SimpleClient{sc}, session.Documents())
+}
+
+func TestSession_Independent(t *testing.T) {
+	hub := NewLoopbackHub()
+	sessions := setupSessions(t, hub, 2)
+	<-time.After(timeout)
+
+	// Each document gets its own content
+	for i, topic := range sessionTopics {
+		writer := sessions[0].Document(topic)
+		event := writer.GetDoc().NewEvent("SET")
+		event.Arguments["path"] = []interface{}{"zone"}
+		event.Arguments["value"] = float64(i)
+		register(writer, &event)
+		if err := writer.Promote(event); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*timeout)
+		err := sessions[1].Document(topic).WaitForTip(ctx, event.Hash())
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, topic := range sessionTopics {
+		assert.Equal(t,
+			map[string]interface{}{"zone": float64(i)},
+			sessions[1].Document(topic).Export(),
+		)
+	}
+
+	// One connection per session, shared by all documents
+	assert.Equal(t, 2, len(hub.transports))
+}
+
+func TestSession_CloseDocument(t *testing.T) {
+	hub := NewLoopbackHub()
+	sessions := setupSessions(t, hub, 1)
+	types := listenLoopback(t, hub, sessionTopics[0])
+	<-time.After(timeout)
+
+	sc := sessions[0].Document(sessionTopics[0])
+	assert.NoError(t, sessions[0].CloseDocument(sessionTopics[0]))
+	assert.NoError(t, sessions[0].CloseDocument(sessionTopics[0]), "Already closed")
+	assert.True(t, sc.IsClosed())
+	assert.Nil(t, sessions[0].Document(sessionTopics[0]))
+	assert.Equal(t, 2, len(sessions[0].Documents()))
+
+	// Shared connection is still up for everyone else
+	assert.NotEqual(t, "", sessions[0].Document(sessionTopics[1]).client.transport.SessionID())
+
+	// And the closed document no longer answers
+	other := hub.NewTransport()
+	if err := other.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	other.Publish(sessionTopics[0], map[string]interface{}{"type": "02-request-events"})
+	expectTypes(t, types, "02-request-events")
+	select {
+	case evtype := <-types:
+		t.Fatalf("Closed document is still talking: %s", evtype)
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	// Reopening gets a fresh document
+	reopened, err := sessions[0].Open(sessionTopics[0])
+	if assert.NoError(t, err) {
+		assert.False(t, reopened == sc)
+		assert.Equal(t, StateConnected, reopened.ConnectionState())
+	}
+}
+
+func TestSession_Reconnect(t *testing.T) {
+	hub := NewLoopbackHub()
+	ft := &flakyTransport{LoopbackTransport: hub.NewTransport()}
+	session := NewSession(ft, nil)
+	all_states := make([]chan ConnectionState, len(sessionTopics))
+	for i, topic := range sessionTopics {
+		sc, err := session.Open(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc.MinReconnectDelay = time.Millisecond
+		all_states[i] = recordStates(sc)
+	}
+	if err := session.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+
+	ft.Drop()
+	for _, states := range all_states {
+		expectStates(t, states,
+			StateConnected,
+			StateDisconnected,
+			StateReconnecting,
+			StateConnected,
+		)
+	}
+	assert.Equal(t, 2, len(ft.Attempts()), "Reconnected once for everyone")
+}
+
+func TestSession_Close(t *testing.T) {
+	hub := NewLoopbackHub()
+	sessions := setupSessions(t, hub, 1)
+	docs := sessions[0].Documents()
+
+	assert.NoError(t, sessions[0].Close())
+	for _, sc := range docs {
+		assert.True(t, sc.IsClosed())
+	}
+	assert.Equal(t, 0, len(sessions[0].Documents()))
+	assert.Equal(t, 0, len(hub.transports))
+
+	assert.Equal(t, ErrClosed, sessions[0].Close())
+	assert.Equal(t, ErrClosed, sessions[0].Connect(""))
+	_, err := sessions[0].Open(sessionTopics[0])
+	assert.Equal(t, ErrClosed, err)
+}
+
+// A slow dial doesn't hold up the rest of the Session.
+func TestSession_Connect_Slow(t *testing.T) {
+	dialing, release := make(chan struct{}), make(chan struct{})
+	ht := &hookTransport{LoopbackTransport: NewLoopbackHub().NewTransport()}
+	ht.OnConnect = func() {
+		close(dialing)
+		<-release
+	}
+	session := NewSession(ht, nil)
+	sc, err := session.Open(sessionTopics[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan error)
+	go func() { connected <- session.Connect("") }()
+	<-dialing
+
+	looked_up := make(chan []*SimpleClient)
+	go func() { looked_up <- session.Documents() }()
+	select {
+	case docs := <-looked_up:
+		assert.Equal(t, []*SimpleClient{sc}, docs)
+	case <-time.After(timeout):
+		t.Fatal("Documents waited for the dial")
+	}
+
+	close(release)
+	assert.NoError(t, <-connected)
+	assert.Equal(t, StateConnected, sc.ConnectionState())
+	assert.NoError(t, session.Close())
+}
+
+// A Transport that connects, but won't subscribe or unsubscribe.
+type stubbornTransport struct {
+	*LoopbackTransport
+}
+
+func (st stubbornTransport) Subscribe(topic string, handler TransportHandler) error {
+	return errors.New("Stubborn transport")
+}
+
+func (st stubbornTransport) Unsubscribe(topic string) error {
+	return errors.New("Stubborn transport")
+}
+
+func TestSession_Connect_Errors(t *testing.T) {
+	hub := NewLoopbackHub()
+
+	// Unreachable, then reachable
+	ft := &flakyTransport{LoopbackTransport: hub.NewTransport(), Failures: 1}
+	session := NewSession(ft, nil)
+	if _, err := session.Open(sessionTopics[0]); err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualError(t, session.Connect(""), "Flaky connection")
+	assert.NoError(t, session.Connect(""))
+	assert.Equal(t, StateConnected, session.Document(sessionTopics[0]).ConnectionState())
+	assert.NoError(t, session.Close())
+	assert.Equal(t, ErrClosed, session.ensureConnected(""))
+
+	// Connected, but documents can't subscribe
+	buffer := new(syncBuffer)
+	logger := log.New(buffer, "session_test: ", 0)
+	session = NewSession(stubbornTransport{hub.NewTransport()}, logger)
+	if _, err := session.Open(sessionTopics[0]); err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualError(t, session.Connect(""), "Stubborn transport")
+	sc, err := session.Open(sessionTopics[1])
+	assert.Nil(t, sc)
+	assert.EqualError(t, err, "Stubborn transport")
+	assert.Nil(t, session.Document(sessionTopics[1]), "Failed documents aren't kept")
+
+	// Closing goes ahead anyway
+	assert.NoError(t, session.Close())
+	assert.Equal(t, "session_test: Stubborn transport\n", buffer.String())
+}
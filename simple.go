@@ -1,9 +1,12 @@
 package deje
 
 import (
+	"crypto/ed25519"
 	"errors"
 	"log"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/DJDNS/go-deje/document"
 	"github.com/DJDNS/go-deje/state"
@@ -13,72 +16,261 @@ import (
 
 // Wraps the low-level capabilities of the basic Client to provide
 // an easier, more useful API to downstream code.
+//
+// A SimpleClient is safe for use from multiple goroutines. Messages
+// from the network are handled on the transport's goroutine, so the
+// document is only ever touched with sc.mutex held.
 type SimpleClient struct {
+	// The current tip. Only safe to read directly when not connected,
+	// otherwise use GetTip.
 	Tip *document.Event
 
+	// Reconnect automatically when the connection is lost, waiting
+	// MinReconnectDelay before the first attempt, and doubling the
+	// delay after each failure up to MaxReconnectDelay. Set these
+	// before calling Connect.
+	AutoReconnect     bool
+	MinReconnectDelay time.Duration
+	MaxReconnectDelay time.Duration
+
+	// How long Close waits, while reconnecting, for one last attempt
+	// to get through and send what's been held back.
+	CloseTimeout time.Duration
+
+	// When one of our own edits loses out to a competing fork, replay
+	// it on top of the winning tip, instead of just letting it go. See
+	// NotifyConflict.
+	AutoRebase bool
+
+	// If this is one of the document's acceptors, vote for every new
+	// tip (see Vote). Set this before calling Connect.
+	AcceptorKey ed25519.PrivateKey
+
 	client *Client
 	tt     timestamps.TimestampTracker
 	logger *log.Logger
 
-	onReTipCallback OnReTipCallback
+	// Guards the document, Tip, tt, and the fields below. Callbacks
+	// are queued while it is held, and run by sc.unlock().
+	mutex               sync.Mutex
+	onReTipCallback     OnReTipCallback
+	onPrimitiveCallback state.OnPrimitiveCallback
+	pending             []func()
+	subscriptions       []*Subscription
+	local               map[string]bool // Hashes of our own edits
+	pin                 string          // See Pin
+	pinned              *document.Event
+	geneses             []*document.Event // Candidates for adoptGenesis
+	heldVotes           []document.Vote   // See rcvVotes
+	vote                string            // The Event we last voted for, see voteForTip
+
+	// Closed and replaced by sc.unlock() whenever dirty is set, to wake
+	// up anything waiting for the document to change.
+	changed            chan struct{}
+	dirty              bool
+	timestampsReceived int
+
+	connMutex                 sync.Mutex
+	url                       string
+	connState                 ConnectionState
+	closed                    bool
+	closing                   chan struct{} // Closed by Close
+	reconnected               chan struct{} // Closed when reconnect returns
+	outbox                    []interface{}
+	onConnectionStateCallback OnConnectionStateCallback
 }
 
 // Unless you want to manually specify router URL and topic separately,
 // you should probably use Open() instead of NewSimpleClient().
 func NewSimpleClient(topic string, logger *log.Logger) *SimpleClient {
-	raw_client := NewClient(topic)
+	return NewSimpleClientWithTransport(topic, NewWamp1Transport(), logger)
+}
+
+// Like NewSimpleClient, but communicates over the given Transport.
+func NewSimpleClientWithTransport(topic string, transport Transport, logger *log.Logger) *SimpleClient {
+	raw_client := NewClientWithTransport(topic, transport)
 	doc := raw_client.Doc
 	simple_client := &SimpleClient{
-		nil,
-		&raw_client,
-		timestamps.NewTimestampTracker(doc, timestamps.NewPeerTimestampService(doc)),
-		logger,
-		nil,
+		AutoReconnect:     true,
+		MinReconnectDelay: DefaultMinReconnectDelay,
+		MaxReconnectDelay: DefaultMaxReconnectDelay,
+		CloseTimeout:      DefaultCloseTimeout,
+
+		client:  &raw_client,
+		tt:      timestamps.NewTimestampTracker(doc, timestamps.NewPeerTimestampService(doc)),
+		logger:  logger,
+		changed: make(chan struct{}),
+		local:   make(map[string]bool),
+		closing: make(chan struct{}),
 	}
 	raw_client.SetEventCallback(func(event interface{}) {
 		err := simple_client.onRcv(event)
 		if err != nil {
 			simple_client.Log(err)
+			simple_client.lock()
+			simple_client.notify(Notification{Kind: NotifyError, Err: err})
+			simple_client.unlock()
 		}
 	})
+	raw_client.SetDisconnectCallback(simple_client.onDisconnect)
+	doc.State.SetPrimitiveCallback(simple_client.queuePrimitive)
 	return simple_client
 }
 
 // The preferred way to create SimpleClients. Handles the Connect() call, and
-// uses GetRouterAndTopic() to turn a single deje://... URL into a router URL
-// and topic.
+// uses a DejeURL to turn a single deje://... or dejes://... URL into a
+// router URL and topic.
+//
+// If the URL selects a realm, the client connects with WAMP v2.
+// Otherwise, it uses WAMP v1. The URL also picks the timestamp service
+// (see DejeURL.Timestamps), and may pin the document to an Event (see
+// DejeURL.At and Pin).
+//
+// The document starts out empty. Use Sync to wait for it to be filled
+// in by peers.
 func Open(deje_url string, logger *log.Logger, cb state.OnPrimitiveCallback) (*SimpleClient, error) {
-	router, topic, err := GetRouterAndTopic(deje_url)
+	u, err := ParseDejeURL(deje_url)
 	if err != nil {
 		return nil, err
 	}
+	return openURL(u, logger, cb)
+}
 
-	sc := NewSimpleClient(topic, logger)
+// Like Open, with the URL already parsed.
+func openURL(u *DejeURL, logger *log.Logger, cb state.OnPrimitiveCallback) (*SimpleClient, error) {
+	var transport Transport
+	if u.Realm != "" {
+		transport = NewWamp2Transport(u.Realm)
+	} else {
+		transport = NewWamp1Transport()
+	}
+
+	sc := NewSimpleClientWithTransport(u.Topic(), transport, logger)
+	sc.SetTimestampService(u.TimestampService(sc.GetDoc()))
+	if u.At != "" {
+		sc.Pin(u.At) // Can't fail, the document is still empty
+	}
 	sc.SetPrimitiveCallback(cb)
-	if err = sc.Connect(router); err != nil {
+	if err := sc.Connect(u.Router()); err != nil {
 		return nil, err
 	}
 	return sc, nil
 }
 
+func (sc *SimpleClient) lock() {
+	sc.mutex.Lock()
+}
+
+// Release sc.mutex, then run any callbacks queued while it was held.
+// This way, callbacks see a consistent document, and are free to call
+// back into the SimpleClient.
+func (sc *SimpleClient) unlock() {
+	if sc.dirty {
+		close(sc.changed)
+		sc.changed = make(chan struct{})
+		sc.dirty = false
+	}
+	pending := sc.pending
+	sc.pending = nil
+	sc.mutex.Unlock()
+
+	for _, callback := range pending {
+		callback()
+	}
+}
+
+// Must be called with sc.mutex held, which is true whenever the
+// document state changes through the SimpleClient.
+func (sc *SimpleClient) queuePrimitive(p state.Primitive) {
+	sc.notify(Notification{Kind: NotifyPrimitive, Primitive: p})
+	if callback := sc.onPrimitiveCallback; callback != nil {
+		sc.pending = append(sc.pending, func() { callback(p) })
+	}
+}
+
+// Must be called with sc.mutex held.
 func (sc *SimpleClient) rcvEventList(parent map[string]interface{}, key string) error {
 	doc := sc.GetDoc()
 	events, ok := parent[key].([]interface{})
 	if !ok {
 		return errors.New("Message with bad '" + key + "' param")
 	}
+	batch := make([]*document.Event, 0, len(events))
 	for _, serial_event := range events {
 		doc_ev := doc.NewEvent("")
 		err := util.CloneMarshal(serial_event, &doc_ev)
 		if err != nil {
 			return err
 		}
-		doc_ev.Register()
+		batch = append(batch, &doc_ev)
+	}
+
+	// GENESIS Events first, since the genesis decides how the rest are
+	// hashed, even if it comes later in the batch
+	var rest []*document.Event
+	for _, doc_ev := range batch {
+		if doc_ev.HandlerName != document.GenesisHandler {
+			rest = append(rest, doc_ev)
+			continue
+		}
+		sc.registerEvent(doc_ev)
+
+		// Not fatal, the event just doesn't count
+		var err error
+		if doc.GenesisHash == "" {
+			err = sc.checkGenesis(doc_ev)
+			if err == nil {
+				sc.geneses = append(sc.geneses, doc_ev)
+			}
+		} else if doc.GenesisHash == doc_ev.Hash() {
+			// Set ahead of time, so it's trusted as is
+			err = doc.SetGenesis(doc_ev.Hash())
+		}
+		if err != nil {
+			sc.Log(err)
+			sc.notify(Notification{Kind: NotifyError, Err: err})
+		}
+	}
+	if doc.GenesisHash == "" {
+		sc.adoptGenesis(rest)
+	}
+	for _, doc_ev := range rest {
+		sc.registerEvent(doc_ev)
+	}
+	sc.rekey()
+	sc.releaseVotes()
+
+	// Events usually arrive just ahead of the timestamps that refer to
+	// them. Until then, there's no tip to find.
+	if len(doc.Timestamps) > 0 {
+		sc.reTip()
 	}
-	sc.ReTip()
 	return nil
 }
 
+// Register an Event from a peer, and tell subscribers if it's new.
+//
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) registerEvent(ev *document.Event) {
+	_, known := sc.GetDoc().Events[ev.Hash()]
+	ev.Register()
+	if !known {
+		sc.notify(Notification{Kind: NotifyEvent, Event: ev})
+	}
+}
+
+// Events are hashed with our own algorithm when they arrive, but
+// without a genesis to say otherwise, the document may use another one
+// (like util.LegacySHA1). If the peer's hashes say so, use theirs.
+//
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) rekey() {
+	doc := sc.GetDoc()
+	if doc.GenesisHash == "" {
+		doc.Rekey()
+	}
+}
+
 func (sc *SimpleClient) onRcv(event interface{}) error {
 	map_ev, ok := event.(map[string]interface{})
 	if !ok {
@@ -89,14 +281,20 @@ func (sc *SimpleClient) onRcv(event interface{}) error {
 		return errors.New("Message with no 'type' param")
 	}
 
+	sc.lock()
+	defer sc.unlock()
+
 	doc := sc.GetDoc()
 	switch evtype {
 	case "02-request-events":
-		sc.PublishEvents()
+		sc.Publish(sc.eventsMessage())
 	case "02-publish-events":
 		return sc.rcvEventList(map_ev, "events")
 	case "02-request-timestamps":
-		sc.PublishTimestamps()
+		sc.Publish(sc.timestampsMessage())
+		if len(doc.Votes) > 0 {
+			sc.Publish(votesMessage(sc.getVotes()...))
+		}
 	case "02-publish-timestamps":
 		ts, ok := map_ev["timestamps"].([]interface{})
 		if !ok {
@@ -111,6 +309,13 @@ func (sc *SimpleClient) onRcv(event interface{}) error {
 			ts_strings[i] = ts_string
 		}
 		doc.Timestamps = ts_strings
+		sc.timestampsReceived++
+		sc.dirty = true
+		if doc.GenesisHash == "" {
+			// They may settle which genesis is the real one
+			sc.adoptGenesis(nil)
+		}
+		sc.rekey()
 
 		var unfamiliar bool
 		for _, ts_string := range doc.Timestamps {
@@ -121,8 +326,11 @@ func (sc *SimpleClient) onRcv(event interface{}) error {
 		if unfamiliar {
 			sc.RequestEvents()
 		} else {
-			sc.ReTip()
+			sc.reTip()
 		}
+		sc.releaseVotes()
+	case "02-publish-votes":
+		return sc.rcvVotes(map_ev)
 	case "log":
 		// Do nothing
 	default:
@@ -132,11 +340,23 @@ func (sc *SimpleClient) onRcv(event interface{}) error {
 }
 
 // Connect and immediately request timestamps.
+//
+// If the connection is lost later, and AutoReconnect is set, the same
+// URL is used to reconnect.
 func (sc *SimpleClient) Connect(url string) error {
+	sc.connMutex.Lock()
+	if sc.closed {
+		sc.connMutex.Unlock()
+		return ErrClosed
+	}
+	sc.url = url
+	sc.connMutex.Unlock()
+
 	err := sc.client.Connect(url)
 	if err != nil {
 		return err
 	}
+	sc.setConnectionState(StateConnected)
 	return sc.RequestTimestamps()
 }
 
@@ -147,10 +367,22 @@ func (sc *SimpleClient) Log(args ...interface{}) {
 }
 
 func (sc *SimpleClient) ReTip() {
-	var err error
-	sc.Tip, err = sc.tt.FindLatest()
-	if err != nil {
-		sc.Log(err)
+	sc.lock()
+	defer sc.unlock()
+	sc.reTip()
+}
+
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) reTip() {
+	sc.dirty = true
+	if sc.pin != "" {
+		sc.Tip = sc.findPinned()
+	} else {
+		var err error
+		sc.Tip, err = sc.tt.FindLatest()
+		if err != nil {
+			sc.Log(err)
+		}
 	}
 
 	if sc.Tip != nil {
@@ -158,9 +390,21 @@ func (sc *SimpleClient) ReTip() {
 	} else {
 		sc.GetDoc().State.Reset()
 	}
+	if sc.pin == "" {
+		if sc.checkConflicts() {
+			// Rebased, which means there's a new tip
+			sc.reTip()
+			return
+		}
+		if sc.voteForTip() {
+			return
+		}
+	}
 
-	if sc.onReTipCallback != nil {
-		sc.onReTipCallback(sc.Tip)
+	sc.notify(Notification{Kind: NotifyTip, Tip: sc.Tip})
+	if callback := sc.onReTipCallback; callback != nil {
+		tip := sc.Tip
+		sc.pending = append(sc.pending, func() { callback(tip) })
 	}
 }
 
@@ -171,6 +415,14 @@ func (sc *SimpleClient) RequestEvents() error {
 }
 
 func (sc *SimpleClient) PublishEvents() error {
+	sc.lock()
+	message := sc.eventsMessage()
+	sc.unlock()
+	return sc.Publish(message)
+}
+
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) eventsMessage() map[string]interface{} {
 	doc := sc.GetDoc()
 	hashes := make([]string, len(doc.Events))
 	events := make([]*document.Event, len(doc.Events))
@@ -186,10 +438,10 @@ func (sc *SimpleClient) PublishEvents() error {
 		events[i] = doc.Events[hash]
 	}
 
-	return sc.Publish(map[string]interface{}{
+	return map[string]interface{}{
 		"type":   "02-publish-events",
 		"events": events,
-	})
+	}
 }
 
 func (sc *SimpleClient) RequestTimestamps() error {
@@ -199,32 +451,125 @@ func (sc *SimpleClient) RequestTimestamps() error {
 }
 
 func (sc *SimpleClient) PublishTimestamps() error {
-	return sc.Publish(map[string]interface{}{
+	sc.lock()
+	message := sc.timestampsMessage()
+	sc.unlock()
+	return sc.Publish(message)
+}
+
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) timestampsMessage() map[string]interface{} {
+	return map[string]interface{}{
 		"type":       "02-publish-timestamps",
-		"timestamps": sc.GetDoc().Timestamps,
-	})
+		"timestamps": sc.getTimestamps(),
+	}
 }
 
+// Publish a message to the topic. While reconnecting, messages are
+// held back, and sent once the connection is back.
 func (sc *SimpleClient) Publish(data interface{}) error {
+	sc.connMutex.Lock()
+	defer sc.connMutex.Unlock()
+	if sc.closed {
+		return ErrClosed
+	}
+	if sc.connState == StateReconnecting {
+		return sc.queueOutbound(data)
+	}
 	return sc.client.Publish(data)
 }
 
-// Navigate the Document to an Event, and promote it as the tip.
-func (sc *SimpleClient) Promote(ev document.Event) error {
+// Publish a single Event, along with any ancestors that peers may not
+// have yet. This is much cheaper than PublishEvents, which sends every
+// Event in the document.
+//
+// Timestamped ancestors are assumed to be known already, since they
+// were published when they were promoted.
+func (sc *SimpleClient) PublishEvent(ev document.Event) error {
+	sc.lock()
+	message := sc.eventMessage(ev)
+	sc.unlock()
+	return sc.Publish(message)
+}
+
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) eventMessage(ev document.Event) map[string]interface{} {
 	doc := sc.GetDoc()
+	timestamped := make(map[string]bool, len(doc.Timestamps))
+	for _, hash := range doc.Timestamps {
+		timestamped[hash] = true
+	}
+
+	// Walk back to the nearest timestamped ancestor, then provide
+	// events oldest first, so parents arrive before their children.
+	events := []*document.Event{&ev}
+	for current := &ev; current.ParentHash != ""; {
+		parent, ok := doc.Events[current.ParentHash]
+		if !ok || timestamped[parent.Hash()] {
+			break
+		}
+		events = append([]*document.Event{parent}, events...)
+		current = parent
+	}
+
+	return map[string]interface{}{
+		"type":   "02-publish-events",
+		"events": events,
+	}
+}
+
+// Navigate the Document to an Event, and promote it as the tip. Fails
+// with ErrPinned if the document is pinned.
+//
+// The Event is published first (see PublishEvent), then the new
+// timestamps, so peers don't have to ask for it.
+func (sc *SimpleClient) Promote(ev document.Event) error {
+	if sc.IsClosed() {
+		return ErrClosed
+	}
 
+	sc.lock()
+	if sc.pin != "" {
+		sc.unlock()
+		return ErrPinned
+	}
 	if err := ev.Goto(); err != nil {
+		sc.unlock()
 		return err
 	}
-
+	events_message := sc.eventMessage(ev)
+	doc := sc.GetDoc()
 	doc.Timestamps = append(doc.Timestamps, ev.Hash())
-	sc.ReTip()
-	return sc.PublishTimestamps()
+	sc.addLocal(&ev)
+	sc.reTip()
+	timestamps_message := sc.timestampsMessage()
+	sc.unlock()
+
+	if err := sc.Publish(events_message); err != nil {
+		return err
+	}
+	return sc.Publish(timestamps_message)
 }
 
 // Set a callback for when primitives are applied to the document state.
+//
+// The callback is called after the SimpleClient is done changing the
+// document, so it's safe to call other SimpleClient methods from it.
+// It still runs on the network goroutine, though, so a slow callback
+// holds up everything else. Consider Subscribe instead.
 func (sc *SimpleClient) SetPrimitiveCallback(c state.OnPrimitiveCallback) {
-	sc.GetDoc().State.SetPrimitiveCallback(c)
+	sc.lock()
+	defer sc.unlock()
+	sc.onPrimitiveCallback = c
+}
+
+// Replace where the document's timestamps come from, which is a
+// timestamps.PeerTimestampService by default, and reanalyze the tip.
+func (sc *SimpleClient) SetTimestampService(service timestamps.TimestampService) {
+	sc.lock()
+	defer sc.unlock()
+	sc.tt = timestamps.NewTimestampTracker(sc.GetDoc(), service)
+	sc.reTip()
 }
 
 // An optional callback to be called whenever we reanalyze which event is tip.
@@ -233,20 +578,82 @@ type OnReTipCallback func(*document.Event)
 // Set a callback for when we reanalyze which event is tip. This may be called
 // multiple times with the same result tip.
 func (sc *SimpleClient) SetRetipCallback(c OnReTipCallback) {
+	sc.lock()
+	defer sc.unlock()
 	sc.onReTipCallback = c
 }
 
 // Get the Document object owned by this Client.
+//
+// The Document is not safe to use while the client is connected,
+// since messages from the network change it on another goroutine.
+// Use WithDocument instead.
 func (sc *SimpleClient) GetDoc() *document.Document {
 	return sc.client.Doc
 }
 
+// Run a function with exclusive access to the Document. It must not
+// call any SimpleClient methods, and must not keep doc around after
+// returning.
+func (sc *SimpleClient) WithDocument(fn func(doc *document.Document)) {
+	sc.lock()
+	defer sc.unlock()
+	fn(sc.GetDoc())
+	sc.dirty = true
+}
+
+// Get the current tip, or nil if there isn't one.
+func (sc *SimpleClient) GetTip() *document.Event {
+	sc.lock()
+	defer sc.unlock()
+	return sc.Tip
+}
+
+// Get an Event by its hash, or by any unambiguous prefix of it. See
+// document.Document.GetEventByPrefix.
+func (sc *SimpleClient) GetEvent(hash_prefix string) (*document.Event, error) {
+	sc.lock()
+	defer sc.unlock()
+	return sc.GetDoc().GetEventByPrefix(hash_prefix)
+}
+
+// Expand an abbreviated hash, if it's a prefix of exactly one known
+// Event. Otherwise, it's returned as is, since it may be the hash of
+// an Event that hasn't arrived yet, unless it's ambiguous.
+//
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) expandHash(hash_prefix string) (string, error) {
+	event, err := sc.GetDoc().GetEventByPrefix(hash_prefix)
+	if errors.Is(err, document.ErrNoSuchEvent) {
+		return hash_prefix, nil
+	} else if err != nil {
+		return "", err
+	}
+	return event.Hash(), nil
+}
+
+// Get a copy of the document's timestamps.
+func (sc *SimpleClient) GetTimestamps() []string {
+	sc.lock()
+	defer sc.unlock()
+	return sc.getTimestamps()
+}
+
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) getTimestamps() []string {
+	return append([]string{}, sc.GetDoc().Timestamps...)
+}
+
 // Get the Topic of the underlying Client.
 func (sc *SimpleClient) GetTopic() string {
 	return sc.client.Topic
 }
 
 // Return the current contents of the document.
+//
+// This is a deep copy, so it won't change as the document does.
 func (sc *SimpleClient) Export() interface{} {
-	return sc.client.Doc.State.Export()
+	sc.lock()
+	defer sc.unlock()
+	return sc.GetDoc().State.Export()
 }
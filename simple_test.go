@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"errors"
 	"log"
+	"net"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,6 +19,40 @@ import (
 
 var timeout = 50 * time.Millisecond
 
+// A log destination that's safe to read while a client is logging.
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func (b *syncBuffer) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.buffer.Reset()
+}
+
+// Register events while holding the client's lock, since the document
+// may be in use by the client's network goroutine.
+func register(sc *SimpleClient, events ...*document.Event) {
+	sc.WithDocument(func(*document.Document) {
+		for _, event := range events {
+			event.Register()
+		}
+	})
+}
+
 func TestSimpleClient_NewSimpleClient(t *testing.T) {
 	topic := "http://example.com/deje/some-doc"
 	sc := NewSimpleClient(topic, nil)
@@ -27,28 +64,30 @@ func TestSimpleClient_NewSimpleClient(t *testing.T) {
 func TestSimpleClient_Open_BadUrl(t *testing.T) {
 	_, err := Open("localhost:8080", nil, nil)
 	if assert.Error(t, err, "Open should have failed, due to bad URL") {
-		assert.Equal(t, err.Error(), "URL does not start with 'deje://': 'localhost:8080'")
+		assert.Equal(t, err.Error(), "URL does not start with 'deje://' or 'dejes://': 'localhost:8080'")
 	}
 }
 
 func TestSimpleClient_Open_NoSuchHost(t *testing.T) {
 	_, err := Open("deje://no-such-host:8080/", nil, nil)
 	if assert.Error(t, err, "Open should have failed, due to unreachable host") {
-		assert.Equal(t, err.Error(), "Error connecting to websocket server: websocket.Dial ws://no-such-host:8080/ws: dial tcp: lookup no-such-host: no such host")
+		// The wording depends on the Go version and the resolver
+		_, dial_err := net.Dial("tcp", "no-such-host:8080")
+		assert.Equal(t, err.Error(), "Error connecting to websocket server: websocket.Dial ws://no-such-host:8080/ws: "+dial_err.Error())
 	}
 }
 
 func TestSimpleClient_Open(t *testing.T) {
-	buffer := new(bytes.Buffer)
+	buffer := new(syncBuffer)
 	logger := log.New(buffer, "deje.SimpleClient: ", 0)
 	server_addr, server_closer := setupServer()
 	defer server_closer()
 
-	var got_a_primitive bool
+	got_a_primitive := make(chan bool, 10)
 	handler := func(primitive state.Primitive) {
 		_, ok := primitive.(*state.SetPrimitive)
 		assert.True(t, ok, "Got a SetPrimitive")
-		got_a_primitive = true
+		got_a_primitive <- true
 	}
 
 	url := strings.Replace(server_addr, "ws://", "deje://", 1) + "/some/topic"
@@ -66,7 +105,7 @@ func TestSimpleClient_Open(t *testing.T) {
 	event := sc.GetDoc().NewEvent("SET")
 	event.Arguments["path"] = []interface{}{"message"}
 	event.Arguments["value"] = "Karma incremented"
-	event.Register() // But do not Goto() yet
+	register(sc, &event) // But do not Goto() yet
 
 	// raw_client used to trigger Goto over the network
 	raw_client := NewClient(topic)
@@ -91,7 +130,7 @@ func TestSimpleClient_Open(t *testing.T) {
 	)
 
 	// Confirm that OnPrimitiveCallback was called
-	assert.True(t, got_a_primitive, "Recvd a primitive, callback was called")
+	assert.NotEqual(t, 0, len(got_a_primitive), "Recvd a primitive, callback was called")
 }
 
 func TestSimpleClient_Connect(t *testing.T) {
@@ -158,7 +197,7 @@ func TestSimpleClient_ReTip_Fail(t *testing.T) {
 type simpleProtoTest struct {
 	Topic      string
 	Simple     []*SimpleClient
-	Logs       []*bytes.Buffer
+	Logs       []*syncBuffer
 	Listener   Client
 	EventsRcvd chan interface{}
 	Closer     func()
@@ -168,7 +207,7 @@ func setupSimpleProtocolTest(t *testing.T, num_simple int) simpleProtoTest {
 	var spt simpleProtoTest
 	spt.Topic = "http://example.com/deje/some-doc"
 	spt.Simple = make([]*SimpleClient, num_simple)
-	spt.Logs = make([]*bytes.Buffer, num_simple)
+	spt.Logs = make([]*syncBuffer, num_simple)
 	spt.Listener = NewClient(spt.Topic)
 	server_addr, server_closer := setupServer()
 	spt.Closer = server_closer
@@ -176,7 +215,7 @@ func setupSimpleProtocolTest(t *testing.T, num_simple int) simpleProtoTest {
 	// Use this order to ignore any RequestTip() called during Connect()
 	spt.EventsRcvd = make(chan interface{}, 10)
 	for i := 0; i < num_simple; i++ {
-		buffer := new(bytes.Buffer)
+		buffer := new(syncBuffer)
 		logger := log.New(buffer, "deje.SimpleClient: ", 0)
 
 		spt.Logs[i] = buffer
@@ -185,15 +224,21 @@ func setupSimpleProtocolTest(t *testing.T, num_simple int) simpleProtoTest {
 			t.Fatal(err)
 		}
 	}
+	listening := make(chan struct{})
+	spt.Listener.SetEventCallback(func(event interface{}) {
+		select {
+		case <-listening:
+			spt.EventsRcvd <- event
+		default:
+		}
+	})
 	if err := spt.Listener.Connect(server_addr); err != nil {
 		t.Fatal(err)
 	}
 
 	// Make sure all connect fully, THEN start listening
 	<-time.After(50 * time.Millisecond)
-	spt.Listener.SetEventCallback(func(event interface{}) {
-		spt.EventsRcvd <- event
-	})
+	close(listening)
 
 	return spt
 }
@@ -363,17 +408,18 @@ func TestSimpleClient_ResetStateForNilTimestamps(t *testing.T) {
 	spt := setupSimpleProtocolTest(t, 2)
 	defer spt.Closer()
 
-	doc := spt.Simple[0].GetDoc()
-	event := doc.NewEvent("SET")
+	event := spt.Simple[0].GetDoc().NewEvent("SET")
 	event.Arguments["path"] = []interface{}{"foo"}
 	event.Arguments["value"] = "bar"
-	event.Register()
-	doc.Timestamps = []string{event.Hash()}
+	spt.Simple[0].WithDocument(func(doc *document.Document) {
+		event.Register()
+		doc.Timestamps = []string{event.Hash()}
+	})
 	spt.Simple[0].ReTip()
 
 	// Confirm that the tip is set for both clients
-	assert.Equal(t, &event, spt.Simple[0].Tip)
-	assert.Equal(t, (*document.Event)(nil), spt.Simple[1].Tip)
+	assert.Equal(t, &event, spt.Simple[0].GetTip())
+	assert.Equal(t, (*document.Event)(nil), spt.Simple[1].GetTip())
 
 	// A little setup to make sure we're also getting the proper primitive
 	primitives := make(chan state.Primitive, 10)
@@ -438,18 +484,64 @@ func TestSimpleClient_PublishEvents(t *testing.T) {
 	})
 }
 
+func TestSimpleClient_PublishEvent(t *testing.T) {
+	spt := setupSimpleProtocolTest(t, 1)
+	defer spt.Closer()
+
+	doc := spt.Simple[0].GetDoc()
+	eventA := doc.NewEvent("A")
+	eventB := doc.NewEvent("B")
+	eventB.SetParent(eventA)
+	eventC := doc.NewEvent("C")
+	eventC.SetParent(eventB)
+	orphan := doc.NewEvent("orphan")
+	orphan.ParentHash = "no such parent"
+	register(spt.Simple[0], &eventA, &eventB, &eventC)
+	spt.Simple[0].WithDocument(func(doc *document.Document) {
+		doc.Timestamps = []string{eventA.Hash()}
+	})
+
+	serial := func(ev document.Event) interface{} {
+		return map[string]interface{}{
+			"parent":  ev.ParentHash,
+			"handler": ev.HandlerName,
+			"args":    map[string]interface{}{},
+		}
+	}
+	tests := []struct {
+		Event    document.Event
+		Expected []interface{}
+	}{
+		// Stops at timestamped ancestor
+		{eventC, []interface{}{serial(eventB), serial(eventC)}},
+		// Stops at unknown ancestor
+		{orphan, []interface{}{serial(orphan)}},
+		// Timestamped events are still published themselves
+		{eventA, []interface{}{serial(eventA)}},
+	}
+	for _, test := range tests {
+		if err := spt.Simple[0].PublishEvent(test.Event); err != nil {
+			t.Fatal(err)
+		}
+		spt.Expect(t, []interface{}{
+			map[string]interface{}{
+				"type":   "02-publish-events",
+				"events": test.Expected,
+			},
+		})
+	}
+}
+
 func TestSimpleClient_EventCycle(t *testing.T) {
 	spt := setupSimpleProtocolTest(t, 2)
 	defer spt.Closer()
 
 	doc1 := spt.Simple[0].GetDoc()
-	doc2 := spt.Simple[1].GetDoc()
 
 	first_event := doc1.NewEvent("first")
 	first_event.Arguments["nonce"] = "00"
-	first_event.Register()
 	second_event := doc1.NewEvent("second")
-	second_event.Register()
+	register(spt.Simple[0], &first_event, &second_event)
 
 	assert.True(t,
 		first_event.Hash() < second_event.Hash(),
@@ -485,13 +577,15 @@ func TestSimpleClient_EventCycle(t *testing.T) {
 	})
 
 	// Ensure that events were copied over
-	if !assert.Equal(t, 2, len(doc2.Events)) {
-		t.FailNow()
-	}
-	assert.Equal(t,
-		doc2.Events[first_event.Hash()].HandlerName,
-		first_event.HandlerName,
-	)
+	spt.Simple[1].WithDocument(func(doc2 *document.Document) {
+		if !assert.Equal(t, 2, len(doc2.Events)) {
+			return
+		}
+		assert.Equal(t,
+			doc2.Events[first_event.Hash()].HandlerName,
+			first_event.HandlerName,
+		)
+	})
 }
 
 func TestSimpleClient_RequestTimestamps(t *testing.T) {
@@ -522,8 +616,9 @@ func TestSimpleClient_PublishTimestamps(t *testing.T) {
 		},
 	})
 
-	doc := spt.Simple[0].GetDoc()
-	doc.Timestamps = append(doc.Timestamps, "a hash", "another hash")
+	spt.Simple[0].WithDocument(func(doc *document.Document) {
+		doc.Timestamps = append(doc.Timestamps, "a hash", "another hash")
+	})
 	if err := spt.Simple[0].PublishTimestamps(); err != nil {
 		t.Fatal(err)
 	}
@@ -539,16 +634,16 @@ func TestSimpleClient_TimestampCycle(t *testing.T) {
 	spt := setupSimpleProtocolTest(t, 2)
 	defer spt.Closer()
 
-	doc0 := spt.Simple[0].GetDoc()
 	doc1 := spt.Simple[1].GetDoc()
 
 	evFirst := doc1.NewEvent("first")
-	evFirst.Register()
 	evSecond := doc1.NewEvent("second")
-	evSecond.Register()
-
 	expected_timestamps := []string{evFirst.Hash(), evSecond.Hash()}
-	doc1.Timestamps = expected_timestamps
+	spt.Simple[1].WithDocument(func(doc1 *document.Document) {
+		evFirst.Register()
+		evSecond.Register()
+		doc1.Timestamps = expected_timestamps
+	})
 
 	// First time, events are foreign to doc0
 	if err := spt.Simple[0].RequestTimestamps(); err != nil {
@@ -569,21 +664,21 @@ func TestSimpleClient_TimestampCycle(t *testing.T) {
 			"type": "02-publish-events",
 			"events": []interface{}{
 				map[string]interface{}{
-					"handler": "second",
 					"parent":  "",
 					"args":    map[string]interface{}{},
+					"handler": "first",
 				},
 				map[string]interface{}{
+					"handler": "second",
 					"parent":  "",
 					"args":    map[string]interface{}{},
-					"handler": "first",
 				},
 			},
 		},
 	})
 
-	assert.Equal(t, expected_timestamps, doc0.Timestamps)
-	assert.Equal(t, expected_timestamps, doc1.Timestamps)
+	assert.Equal(t, expected_timestamps, spt.Simple[0].GetTimestamps())
+	assert.Equal(t, expected_timestamps, spt.Simple[1].GetTimestamps())
 
 	// Second time, events are known to doc0, no need to request events
 	if err := spt.Simple[0].RequestTimestamps(); err != nil {
@@ -604,29 +699,19 @@ func TestSimpleClient_Promote(t *testing.T) {
 	spt := setupSimpleProtocolTest(t, 2)
 	defer spt.Closer()
 
-	doc1 := spt.Simple[0].GetDoc()
-	doc2 := spt.Simple[1].GetDoc()
-
-	event := doc1.NewEvent("SET")
+	event := spt.Simple[0].GetDoc().NewEvent("SET")
 	if err := spt.Simple[0].Promote(event); err == nil {
 		t.Fatal("Should fail if we can't navigate to event!")
 	}
 
 	event.Arguments["path"] = []interface{}{"bar"}
 	event.Arguments["value"] = "baz"
-	event.Register()
+	register(spt.Simple[0], &event)
 
 	if err := spt.Simple[0].Promote(event); err != nil {
 		t.Fatal(err)
 	}
 	spt.Expect(t, []interface{}{
-		map[string]interface{}{
-			"type":       "02-publish-timestamps",
-			"timestamps": []interface{}{event.Hash()},
-		},
-		map[string]interface{}{
-			"type": "02-request-events",
-		},
 		map[string]interface{}{
 			"type": "02-publish-events",
 			"events": []interface{}{
@@ -637,13 +722,19 @@ func TestSimpleClient_Promote(t *testing.T) {
 				},
 			},
 		},
+		map[string]interface{}{
+			"type":       "02-publish-timestamps",
+			"timestamps": []interface{}{event.Hash()},
+		},
 	})
 
 	hash := event.Hash()
-	assert.Equal(t, spt.Simple[0].Tip.Hash(), hash)
-	assert.Equal(t, spt.Simple[1].Tip.Hash(), hash)
-	assert.Equal(t, doc1.Events[hash].Hash(), hash)
-	assert.Equal(t, doc2.Events[hash].Hash(), hash)
+	for _, sc := range spt.Simple {
+		assert.Equal(t, sc.GetTip().Hash(), hash)
+		sc.WithDocument(func(doc *document.Document) {
+			assert.Equal(t, doc.Events[hash].Hash(), hash)
+		})
+	}
 
 	expected_export := map[string]interface{}{
 		"bar": "baz",
@@ -652,8 +743,8 @@ func TestSimpleClient_Promote(t *testing.T) {
 	assert.Equal(t, spt.Simple[1].Export(), expected_export)
 
 	expected_timestamps := []string{event.Hash()}
-	assert.Equal(t, doc1.Timestamps, expected_timestamps)
-	assert.Equal(t, doc2.Timestamps, expected_timestamps)
+	assert.Equal(t, spt.Simple[0].GetTimestamps(), expected_timestamps)
+	assert.Equal(t, spt.Simple[1].GetTimestamps(), expected_timestamps)
 }
 
 func TestSimpleClient_SetPrimitiveCallback(t *testing.T) {
@@ -673,39 +764,36 @@ func TestSimpleClient_SetPrimitiveCallback(t *testing.T) {
 		"first":  "thing",
 		"second": "thang",
 	}
-	eventA.Register()
+	register(spt.Simple[0], &eventA)
 
 	eventB := doc.NewEvent("DELETE")
 	eventB.Arguments["path"] = []interface{}{"items", "second"}
 	eventB.SetParent(eventA)
-	eventB.Register()
+	register(spt.Simple[0], &eventB)
 
 	if err := spt.Simple[0].Promote(eventB); err != nil {
 		t.Fatal(err)
 	}
 	spt.Expect(t, []interface{}{
-		map[string]interface{}{
-			"type":       "02-publish-timestamps",
-			"timestamps": []interface{}{eventB.Hash()},
-		},
-		map[string]interface{}{
-			"type": "02-request-events",
-		},
 		map[string]interface{}{
 			"type": "02-publish-events",
 			"events": []interface{}{
-				map[string]interface{}{
-					"handler": "DELETE",
-					"parent":  eventA.Hash(),
-					"args":    eventB.Arguments,
-				},
 				map[string]interface{}{
 					"handler": "SET",
 					"parent":  "",
 					"args":    eventA.Arguments,
 				},
+				map[string]interface{}{
+					"handler": "DELETE",
+					"parent":  eventA.Hash(),
+					"args":    eventB.Arguments,
+				},
 			},
 		},
+		map[string]interface{}{
+			"type":       "02-publish-timestamps",
+			"timestamps": []interface{}{eventB.Hash()},
+		},
 	})
 
 	expected_primitives := []state.Primitive{
@@ -781,18 +869,11 @@ func TestSimpleClient_SetRetipCallback(t *testing.T) {
 	event := spt.Simple[1].GetDoc().NewEvent("SET")
 	event.Arguments["path"] = []interface{}{"foo"}
 	event.Arguments["value"] = "bar"
-	event.Register()
+	register(spt.Simple[1], &event)
 
 	// Start a flow of syncronization, observe tips that are callback'd
 	spt.Simple[1].Promote(event)
 	spt.Expect(t, []interface{}{
-		map[string]interface{}{
-			"type":       "02-publish-timestamps",
-			"timestamps": []interface{}{event.Hash()},
-		},
-		map[string]interface{}{
-			"type": "02-request-events",
-		},
 		map[string]interface{}{
 			"type": "02-publish-events",
 			"events": []interface{}{
@@ -803,6 +884,10 @@ func TestSimpleClient_SetRetipCallback(t *testing.T) {
 				},
 			},
 		},
+		map[string]interface{}{
+			"type":       "02-publish-timestamps",
+			"timestamps": []interface{}{event.Hash()},
+		},
 	})
 	select {
 	case tip := <-tips:
@@ -859,3 +944,71 @@ func TestSimpleClient_Export(t *testing.T) {
 		t.Fatalf("Expected %#v, got %#v", expected, exported)
 	}
 }
+
+func TestSimpleClient_Concurrent(t *testing.T) {
+	lts := setupLoopback(t, 2)
+	topic := "deje://loopback/concurrent"
+	writer := NewSimpleClientWithTransport(topic, lts[0], nil)
+	reader := NewSimpleClientWithTransport(topic, lts[1], nil)
+
+	// Callbacks are free to call back into the client
+	exports := make(chan interface{}, 100)
+	reader.SetRetipCallback(func(*document.Event) {
+		select {
+		case exports <- reader.Export():
+		default:
+		}
+	})
+	reader.SetPrimitiveCallback(func(state.Primitive) {
+		reader.GetTip()
+	})
+	for _, sc := range []*SimpleClient{writer, reader} {
+		if err := sc.Connect(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				reader.Export()
+				reader.GetTip()
+				reader.GetTimestamps()
+				reader.PublishEvents()
+			}
+		}()
+	}
+
+	var parent *document.Event
+	for i := 0; i < 10; i++ {
+		event := writer.GetDoc().NewEvent("SET")
+		event.Arguments["path"] = []interface{}{"count"}
+		event.Arguments["value"] = strconv.Itoa(i)
+		if parent != nil {
+			event.SetParent(*parent)
+		}
+		register(writer, &event)
+		if err := writer.Promote(event); err != nil {
+			t.Fatal(err)
+		}
+		parent = &event
+	}
+
+	waitFor(t, "reader to catch up", func() bool {
+		tip := reader.GetTip()
+		return tip != nil && tip.Hash() == parent.Hash()
+	})
+	close(done)
+	wg.Wait()
+	assert.Equal(t, map[string]interface{}{"count": "9"}, reader.Export())
+	assert.NotEqual(t, 0, len(exports))
+}
@@ -58,13 +58,13 @@ func TestTraverse(t *testing.T) {
 		t.Fatal(err)
 	}
 	if child.Export() != "chain" {
-		t.Fatal("Expected chain string, got %v", child.Export())
+		t.Fatalf("Expected chain string, got %v", child.Export())
 	}
 	child, err = Traverse(root, []interface{}{"deep", 2, "stored"})
 	if err != nil {
 		t.Fatal(err)
 	}
 	if child.Export() != "stuff" {
-		t.Fatal("Expected stuff string, got %v", child.Export())
+		t.Fatalf("Expected stuff string, got %v", child.Export())
 	}
 }
@@ -76,7 +76,7 @@ func TestMapContainer_SetChild(t *testing.T) {
 		"some_key": 0,
 	}
 	if !reflect.DeepEqual(c.Export(), expected) {
-		t.Fatal("Expected %#v, got %#v", expected, c.Export())
+		t.Fatalf("Expected %#v, got %#v", expected, c.Export())
 	}
 }
 
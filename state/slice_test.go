@@ -109,7 +109,7 @@ func TestSliceContainer_SetChild(t *testing.T) {
 		nil, nil, nil, nil, nil, 89,
 	}
 	if !reflect.DeepEqual(c.Export(), expected) {
-		t.Fatal("Expected %#v, got %#v", expected, c.Export())
+		t.Fatalf("Expected %#v, got %#v", expected, c.Export())
 	}
 }
 
@@ -88,14 +88,14 @@ func TestDocumentState_Apply(t *testing.T) {
 		t.Fatal(err)
 	}
 	if len(primitives_applied) != 1 {
-		t.Fatal(
+		t.Fatalf(
 			"Expected 1 primitive to be broadast, got %d",
 			len(primitives_applied),
 		)
 	}
 	recvd_p := <-primitives_applied
 	if !reflect.DeepEqual(recvd_p, primitive) {
-		t.Fatal("Expected %#v, got %#v", primitive, recvd_p)
+		t.Fatalf("Expected %#v, got %#v", primitive, recvd_p)
 	}
 }
 func TestDocumentState_Apply_BadPrimitive(t *testing.T) {
@@ -113,7 +113,7 @@ func TestDocumentState_Apply_BadPrimitive(t *testing.T) {
 		t.Fatal("ds.Apply should fail if underlying Apply fails")
 	}
 	if len(primitives_applied) != 0 {
-		t.Fatal(
+		t.Fatalf(
 			"Expected 0 primitives to be broadast, got %d",
 			len(primitives_applied),
 		)
@@ -146,6 +146,6 @@ func TestDocumentState_Export(t *testing.T) {
 	}
 	exported := ds.Export()
 	if !reflect.DeepEqual(exported, expected) {
-		t.Fatal("Expected %#v, got %#v", expected, exported)
+		t.Fatalf("Expected %#v, got %#v", expected, exported)
 	}
 }
@@ -0,0 +1,140 @@
+package deje
+
+import (
+	"sync/atomic"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/state"
+)
+
+// The kinds of notification a Subscription can receive. Combine them
+// with | to subscribe to several at once.
+type NotificationKind int
+
+const (
+	// The tip was reanalyzed. Like OnReTipCallback, this may happen
+	// several times with the same tip.
+	NotifyTip NotificationKind = 1 << iota
+
+	// A primitive was applied to the document state.
+	NotifyPrimitive
+
+	// An event we didn't already have arrived from the network.
+	NotifyEvent
+
+	// A message from the network could not be handled.
+	NotifyError
+
+	// One of our own edits lost out to a competing fork. Event is the
+	// edit. If it was rebased (see SimpleClient.AutoRebase), Rebased
+	// is its replacement, otherwise Err says why not.
+	NotifyConflict
+
+	NotifyAll = NotifyTip | NotifyPrimitive | NotifyEvent | NotifyError | NotifyConflict
+)
+
+// Something that happened to a SimpleClient. Only the fields matching
+// Kind are set.
+type Notification struct {
+	Kind      NotificationKind
+	Tip       *document.Event
+	Primitive state.Primitive
+	Event     *document.Event
+	Rebased   *document.Event
+	Err       error
+}
+
+// A stream of notifications from a SimpleClient, delivered on C.
+//
+// Notifications are never allowed to hold up the SimpleClient. If C's
+// buffer is full when one is sent, it is dropped, and counted by
+// Dropped. Consumers that can't afford to miss anything should use a
+// generous buffer, and treat a change in Dropped as a cue to resync
+// from the SimpleClient directly (GetTip, Export, and so on).
+//
+// C is closed when the Subscription or the SimpleClient is closed.
+type Subscription struct {
+	C <-chan Notification
+
+	sc      *SimpleClient
+	c       chan Notification
+	mask    NotificationKind
+	dropped uint64
+
+	// Guarded by sc.mutex
+	closed bool
+}
+
+// Subscribe to the kinds of notification selected by mask, buffering
+// up to buffer of them (at least 1). There can be any number of
+// Subscriptions at once.
+func (sc *SimpleClient) Subscribe(mask NotificationKind, buffer int) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	c := make(chan Notification, buffer)
+	sub := &Subscription{
+		C:    c,
+		sc:   sc,
+		c:    c,
+		mask: mask,
+	}
+
+	sc.lock()
+	defer sc.unlock()
+	if sc.IsClosed() {
+		sub.closed = true
+		close(c)
+		return sub
+	}
+	sc.subscriptions = append(sc.subscriptions, sub)
+	return sub
+}
+
+// Stop receiving notifications, and close C. Closing more than once
+// is harmless.
+func (sub *Subscription) Close() {
+	sc := sub.sc
+	sc.lock()
+	defer sc.unlock()
+	if sub.closed {
+		return
+	}
+	for i, other := range sc.subscriptions {
+		if other == sub {
+			sc.subscriptions = append(sc.subscriptions[:i], sc.subscriptions[i+1:]...)
+			break
+		}
+	}
+	sub.closed = true
+	close(sub.c)
+}
+
+// How many notifications have been dropped because C was full.
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// Send a notification to every interested Subscription, without
+// blocking. Must be called with sc.mutex held.
+func (sc *SimpleClient) notify(n Notification) {
+	for _, sub := range sc.subscriptions {
+		if sub.mask&n.Kind == 0 {
+			continue
+		}
+		select {
+		case sub.c <- n:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
+
+// Close every Subscription. Must be called with sc.mutex held.
+func (sc *SimpleClient) closeSubscriptions() {
+	for _, sub := range sc.subscriptions {
+		sub.closed = true
+		close(sub.c)
+	}
+	sc.subscriptions = nil
+}
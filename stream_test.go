@@ -0,0 +1,127 @@
+package deje
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DJDNS/go-deje/state"
+	"github.com/stretchr/testify/assert"
+)
+
+// Read notifications until one satisfies done, returning everything
+// read along the way.
+func collectUntil(t *testing.T, sub *Subscription, done func(Notification) bool) []Notification {
+	var got []Notification
+	for {
+		select {
+		case n, ok := <-sub.C:
+			if !ok {
+				t.Fatal("Subscription closed early")
+			}
+			got = append(got, n)
+			if done(n) {
+				return got
+			}
+		case <-time.After(20 * timeout):
+			t.Fatalf("Timed out after %d notifications", len(got))
+		}
+	}
+}
+
+func TestSimpleClient_Subscribe(t *testing.T) {
+	lts := setupLoopback(t, 3)
+	topic := "deje://loopback/subscribe"
+	writer := NewSimpleClientWithTransport(topic, lts[0], nil)
+	reader := NewSimpleClientWithTransport(topic, lts[1], nil)
+	sub := reader.Subscribe(NotifyAll, 100)
+	for _, sc := range []*SimpleClient{writer, reader} {
+		if err := sc.Connect(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tip := promoteChain(t, writer, 1)
+	got := collectUntil(t, sub, func(n Notification) bool {
+		return n.Kind == NotifyTip && n.Tip != nil && n.Tip.Hash() == tip.Hash()
+	})
+
+	var saw_event, saw_primitive bool
+	for _, n := range got {
+		switch n.Kind {
+		case NotifyEvent:
+			saw_event = saw_event || n.Event.Hash() == tip.Hash()
+		case NotifyPrimitive:
+			_, ok := n.Primitive.(*state.SetPrimitive)
+			saw_primitive = saw_primitive || ok
+		}
+	}
+	assert.True(t, saw_event, "Should have been notified of the event")
+	assert.True(t, saw_primitive, "Should have been notified of the primitive")
+
+	// Protocol errors
+	lts[2].Publish(topic, map[string]interface{}{"type": "bogus"})
+	got = collectUntil(t, sub, func(n Notification) bool {
+		return n.Kind == NotifyError
+	})
+	assert.EqualError(t, got[len(got)-1].Err, "Unfamiliar message type: 'bogus'")
+}
+
+func TestSimpleClient_Subscribe_Mask(t *testing.T) {
+	lts := setupLoopback(t, 1)
+	sc := NewSimpleClientWithTransport("deje://loopback/mask", lts[0], nil)
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	tips := sc.Subscribe(NotifyTip, 10)
+	primitives := sc.Subscribe(NotifyPrimitive, 10)
+
+	promoteChain(t, sc, 1)
+	assert.Len(t, tips.C, 1)
+	for len(primitives.C) > 0 {
+		assert.Equal(t, NotifyPrimitive, (<-primitives.C).Kind)
+	}
+}
+
+func TestSimpleClient_Subscribe_Dropped(t *testing.T) {
+	sc := NewSimpleClient("deje://example.com/dropped", nil)
+	slow := sc.Subscribe(NotifyTip, 0) // Rounded up to 1
+	fast := sc.Subscribe(NotifyTip, 10)
+
+	// Never blocks, no matter how far behind slow gets
+	for i := 0; i < 3; i++ {
+		sc.ReTip()
+	}
+	assert.Len(t, slow.C, 1)
+	assert.Equal(t, uint64(2), slow.Dropped())
+	assert.Len(t, fast.C, 3)
+	assert.Equal(t, uint64(0), fast.Dropped())
+}
+
+func TestSimpleClient_Subscribe_Close(t *testing.T) {
+	lts := setupLoopback(t, 1)
+	sc := NewSimpleClientWithTransport("deje://loopback/sub-close", lts[0], nil)
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	early := sc.Subscribe(NotifyAll, 10)
+	late := sc.Subscribe(NotifyTip, 10)
+
+	early.Close()
+	early.Close()
+	_, ok := <-early.C
+	assert.False(t, ok, "Closed subscription should have closed channel")
+
+	// Closed subscriptions are left out
+	sc.ReTip()
+	assert.Len(t, late.C, 1)
+
+	assert.NoError(t, sc.Close())
+	<-late.C
+	_, ok = <-late.C
+	assert.False(t, ok, "Closing client should close subscriptions")
+	late.Close()
+
+	after := sc.Subscribe(NotifyAll, 10)
+	_, ok = <-after.C
+	assert.False(t, ok, "Subscribing to closed client should give closed channel")
+}
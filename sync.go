@@ -0,0 +1,78 @@
+package deje
+
+import "context"
+
+// Ask peers for their timestamps, and block until an answer arrives
+// and every timestamped event (and all of its ancestors) has been
+// received. Returns early with ctx.Err() if ctx is done first, or
+// ErrClosed if the client is closed.
+//
+// If there are no peers to answer, this waits until ctx is done.
+func (sc *SimpleClient) Sync(ctx context.Context) error {
+	sc.lock()
+	start := sc.timestampsReceived
+	sc.unlock()
+
+	if err := sc.RequestTimestamps(); err != nil {
+		return err
+	}
+	return sc.waitUntil(ctx, func() bool {
+		return sc.timestampsReceived > start && sc.hasHistory()
+	})
+}
+
+// Block until the tip is the event with the given hash, which may be
+// abbreviated (see GetEvent). Returns early with ctx.Err() if ctx is
+// done first, or ErrClosed if the client is closed.
+func (sc *SimpleClient) WaitForTip(ctx context.Context, hash string) error {
+	return sc.waitUntil(ctx, func() bool {
+		expanded, err := sc.expandHash(hash)
+		return err == nil && sc.Tip != nil && sc.Tip.Hash() == expanded
+	})
+}
+
+// Block until enough of the document's acceptors have voted for the
+// event with the given hash (see HasQuorum). Returns early with
+// ctx.Err() if ctx is done first, or ErrClosed if the client is
+// closed.
+func (sc *SimpleClient) WaitForQuorum(ctx context.Context, hash string) error {
+	return sc.waitUntil(ctx, func() bool {
+		return sc.hasQuorum(hash)
+	})
+}
+
+// Block until condition is true. It is called with sc.mutex held, once
+// to start with, and again every time the document changes.
+func (sc *SimpleClient) waitUntil(ctx context.Context, condition func() bool) error {
+	for {
+		sc.lock()
+		done := condition()
+		changed := sc.changed
+		sc.unlock()
+		if done {
+			return nil
+		}
+		if sc.IsClosed() {
+			return ErrClosed
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Whether we have every timestamped event, and all of their ancestors.
+// Must be called with sc.mutex held.
+func (sc *SimpleClient) hasHistory() bool {
+	doc := sc.GetDoc()
+	for _, hash := range doc.Timestamps {
+		event, ok := doc.Events[hash]
+		if !ok || !event.HasHistory() {
+			return false
+		}
+	}
+	return true
+}
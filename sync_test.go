@@ -0,0 +1,184 @@
+package deje
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/stretchr/testify/assert"
+)
+
+// Build a chain of SET events on the writer, and promote the last one.
+func promoteChain(t *testing.T, writer *SimpleClient, length int) *document.Event {
+	var parent *document.Event
+	for i := 0; i < length; i++ {
+		event := writer.GetDoc().NewEvent("SET")
+		event.Arguments["path"] = []interface{}{"depth"}
+		event.Arguments["value"] = float64(i)
+		if parent != nil {
+			event.SetParent(*parent)
+		}
+		register(writer, &event)
+		parent = &event
+	}
+	if err := writer.Promote(*parent); err != nil {
+		t.Fatal(err)
+	}
+	return parent
+}
+
+func TestSimpleClient_Sync(t *testing.T) {
+	lts := setupLoopback(t, 2)
+	topic := "deje://loopback/sync"
+	writer := NewSimpleClientWithTransport(topic, lts[0], nil)
+	if err := writer.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	tip := promoteChain(t, writer, 3)
+
+	reader := NewSimpleClientWithTransport(topic, lts[1], nil)
+	if err := reader.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*timeout)
+	defer cancel()
+	if err := reader.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// No sleeping required
+	if assert.NotNil(t, reader.GetTip()) {
+		assert.Equal(t, tip.Hash(), reader.GetTip().Hash())
+	}
+	assert.Equal(t, map[string]interface{}{"depth": float64(2)}, reader.Export())
+}
+
+func TestSimpleClient_Sync_NoPeers(t *testing.T) {
+	lts := setupLoopback(t, 1)
+	sc := NewSimpleClientWithTransport("deje://loopback/lonely", lts[0], nil)
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, sc.Sync(ctx))
+}
+
+func TestSimpleClient_Sync_Closed(t *testing.T) {
+	lts := setupLoopback(t, 1)
+	sc := NewSimpleClientWithTransport("deje://loopback/closed", lts[0], nil)
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, sc.Close())
+	assert.Equal(t, ErrClosed, sc.Sync(context.Background()))
+}
+
+func TestSimpleClient_WaitForTip(t *testing.T) {
+	lts := setupLoopback(t, 2)
+	topic := "deje://loopback/wait-for-tip"
+	writer := NewSimpleClientWithTransport(topic, lts[0], nil)
+	reader := NewSimpleClientWithTransport(topic, lts[1], nil)
+	for _, sc := range []*SimpleClient{writer, reader} {
+		if err := sc.Connect(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-time.After(timeout)
+
+	// Figure out the hash ahead of time, so we can wait before promoting
+	event := writer.GetDoc().NewEvent("SET")
+	event.Arguments["path"] = []interface{}{"hello"}
+	event.Arguments["value"] = "world"
+	hash := event.Hash()
+
+	result := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*timeout)
+		defer cancel()
+		result <- reader.WaitForTip(ctx, hash)
+	}()
+
+	register(writer, &event)
+	if err := writer.Promote(event); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, <-result)
+	assert.Equal(t, map[string]interface{}{"hello": "world"}, reader.Export())
+
+	// Already there, returns immediately
+	assert.NoError(t, writer.WaitForTip(context.Background(), hash))
+}
+
+func TestSimpleClient_WaitForTip_Fail(t *testing.T) {
+	lts := setupLoopback(t, 1)
+	sc := NewSimpleClientWithTransport("deje://loopback/wait-fail", lts[0], nil)
+	if err := sc.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, sc.WaitForTip(ctx, "no such hash"))
+
+	// Closing wakes up waiters
+	result := make(chan error, 1)
+	go func() {
+		result <- sc.WaitForTip(context.Background(), "no such hash")
+	}()
+	<-time.After(5 * time.Millisecond)
+	assert.NoError(t, sc.Close())
+	select {
+	case err := <-result:
+		assert.Equal(t, ErrClosed, err)
+	case <-time.After(timeout):
+		t.Fatal("WaitForTip did not notice Close")
+	}
+}
+
+// Without a genesis to say so, the reader has to work out from the
+// writer's hashes that the document uses util.LegacySHA1.
+func TestSimpleClient_Sync_Legacy(t *testing.T) {
+	file, err := os.Open("document/testdata/legacy_document.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	legacy := document.NewDocument()
+	if err := legacy.Deserialize(file); err != nil {
+		t.Fatal(err)
+	}
+	tip := "b31d4d6a1dda5c7120a4b0953d15694a6a811f24"
+	legacy.Timestamps = []string{tip}
+
+	lts := setupLoopback(t, 2)
+	topic := "deje://loopback/legacy"
+	writer := NewSimpleClientWithTransport(topic, lts[0], nil)
+	writer.loadDocument(&legacy)
+	writer.ReTip()
+	if err := writer.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewSimpleClientWithTransport(topic, lts[1], nil)
+	if err := reader.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*timeout)
+	defer cancel()
+	if err := reader.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.NotNil(t, reader.GetTip()) {
+		assert.Equal(t, tip, reader.GetTip().Hash())
+	}
+	assert.Equal(t, map[string]interface{}{"key2": "value2"}, reader.Export())
+	reader.lock()
+	defer reader.unlock()
+	for key := range legacy.Events {
+		assert.Contains(t, reader.GetDoc().Events, key)
+	}
+}
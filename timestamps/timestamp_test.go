@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/util"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -30,27 +31,41 @@ func TestNewSTS(t *testing.T) {
 	}
 }
 func TestSTS_GetTimestamps(t *testing.T) {
-	doc := document.NewDocument()
-	sts := NewSortingTimestampService(doc)
-
-	// The given values are event "types".
-	// Hashes will be different than these string literals.
-	for _, evhash := range []string{"123", "456", "789"} {
-		q := doc.NewEvent(evhash)
-		q.Register()
+	tests := []struct {
+		Algorithm util.HashAlgorithm
+		Expected  []string
+	}{
+		// As before hashes described themselves
+		{util.LegacySHA1, []string{
+			"2303adf72049c8f0d2dd3c38d47775f9e0b0458d",
+			"5d0d0d82f38428c33802403af6fdf27e82fcd4bc",
+			"f35ae012679b73922225d21834bf962f2c8f1145",
+		}},
+		{util.SHA256, []string{
+			"12208ff9fdef9583cddbd2973af6beee943935d5ec28dc154a0605ae6d5be7557345",
+			"1220c17d15eae296c595f0d53cdfa1655f646d25892204065b1019a8d0105e89529d",
+			"1220c5b8134a460dc3b048c4a3ff862339c3feb25be9ecd0bc72e1d38a645e63126c",
+		}},
 	}
+	for _, test := range tests {
+		doc := document.NewDocument()
+		doc.HashAlgorithm = test.Algorithm
+		sts := NewSortingTimestampService(doc)
 
-	timestamps, err := sts.GetTimestamps()
-	if err != nil {
-		t.Fatal(err)
-	}
-	expected_timestamps := []string{
-		"2303adf72049c8f0d2dd3c38d47775f9e0b0458d",
-		"5d0d0d82f38428c33802403af6fdf27e82fcd4bc",
-		"f35ae012679b73922225d21834bf962f2c8f1145",
-	}
-	if !reflect.DeepEqual(timestamps, expected_timestamps) {
-		t.Fatalf("Expected %#v, got %#v", expected_timestamps, timestamps)
+		// The given values are event "types".
+		// Hashes will be different than these string literals.
+		for _, evhash := range []string{"123", "456", "789"} {
+			q := doc.NewEvent(evhash)
+			q.Register()
+		}
+
+		timestamps, err := sts.GetTimestamps()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(timestamps, test.Expected) {
+			t.Fatalf("Expected %#v, got %#v", test.Expected, timestamps)
+		}
 	}
 }
 
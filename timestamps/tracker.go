@@ -1,6 +1,10 @@
 package timestamps
 
-import "github.com/DJDNS/go-deje/document"
+import (
+	"sort"
+
+	"github.com/DJDNS/go-deje/document"
+)
 
 type TimestampTracker struct {
 	Doc     *document.Document
@@ -19,17 +23,22 @@ func NewTimestampTracker(doc *document.Document, service TimestampService) Times
 }
 
 // Iterate until tip is found. Returns tip
+//
+// Timestamps with more votes from the Doc's acceptors go first, so
+// among competing forks, the one with the most votes wins. None of the
+// TimestampServices know about blocks yet, so every timestamp counts
+// as being in the same block, and the service's order breaks ties.
 func (tt *TimestampTracker) FindLatest() (*document.Event, error) {
 	timestamps, err := tt.Service.GetTimestamps()
 	if err != nil {
 		return nil, err
 	}
-	tt.timestamps = timestamps
+	tt.timestamps = tt.byVotes(timestamps)
 	tt.tip = ""
 
 	for _, ts := range tt.timestamps {
 		event, ok := tt.Doc.Events[ts]
-		if !ok {
+		if !ok || !event.DescendsFromGenesis() {
 			continue
 		}
 
@@ -42,6 +51,19 @@ func (tt *TimestampTracker) FindLatest() (*document.Event, error) {
 	return tt.Doc.Events[tt.tip], nil
 }
 
+// Sort a copy of the timestamps by vote count, most first.
+func (tt *TimestampTracker) byVotes(timestamps []string) []string {
+	sorted := append([]string{}, timestamps...)
+	counts := make(map[string]int, len(sorted))
+	for _, ts := range sorted {
+		counts[ts] = tt.Doc.VoteCount(ts)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return counts[sorted[i]] > counts[sorted[j]]
+	})
+	return sorted
+}
+
 func (tt *TimestampTracker) CompatibleWithTip(event *document.Event) bool {
 	if event == nil {
 		return false
@@ -1,6 +1,9 @@
 package timestamps
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
 	"errors"
 	"testing"
 
@@ -74,6 +77,71 @@ func tsbuilderNormal() TimestampTracker {
 
 	return NewTimestampTracker(&doc, service)
 }
+func tsbuilderGenesis() TimestampTracker {
+	doc := document.NewDocument()
+	service := NewPeerTimestampService(&doc)
+
+	setupEvents(doc)
+	setupGenesis(&doc)
+
+	return NewTimestampTracker(&doc, service)
+}
+
+// Adopt a genesis, and build on it. Returns the child.
+func setupGenesis(doc *document.Document) document.Event {
+	genesis, err := doc.CreateGenesis(document.Genesis{Topic: "deje://example.com/"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	child := doc.NewEvent("SET")
+	child.Arguments["path"] = []interface{}{}
+	child.Arguments["value"] = "genesis child"
+	child.SetParent(*genesis)
+	child.Register()
+	return child
+}
+func tsbuilderVotes() TimestampTracker {
+	doc := document.NewDocument()
+	service := NewPeerTimestampService(&doc)
+
+	setupVotes(&doc)
+
+	return NewTimestampTracker(&doc, service)
+}
+
+// Adopt a genesis with two acceptors, and build two competing forks
+// on it, the second of which gets both votes. Returns the forks.
+func setupVotes(doc *document.Document) (document.Event, document.Event) {
+	keys := make([]ed25519.PrivateKey, 2)
+	acceptors := make([]interface{}, len(keys))
+	for i := range keys {
+		seed := bytes.Repeat([]byte{byte(i + 1)}, ed25519.SeedSize)
+		keys[i] = ed25519.NewKeyFromSeed(seed)
+		acceptors[i] = hex.EncodeToString(keys[i].Public().(ed25519.PublicKey))
+	}
+	genesis, err := doc.CreateGenesis(document.Genesis{
+		Topic:       "deje://example.com/",
+		Permissions: map[string]interface{}{"acceptors": acceptors},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	forks := make([]document.Event, 2)
+	for i := range forks {
+		forks[i] = doc.NewEvent("SET")
+		forks[i].Arguments["path"] = []interface{}{}
+		forks[i].Arguments["value"] = i
+		forks[i].SetParent(*genesis)
+		forks[i].Register()
+	}
+	for _, key := range keys {
+		if err := doc.AddVote(document.NewVote(forks[1].Hash(), key)); err != nil {
+			panic(err)
+		}
+	}
+	return forks[0], forks[1]
+}
 func tsbuilderFails() TimestampTracker {
 	doc := document.NewDocument()
 	service := failingTimestampService("tsbuilderFails() service breaks on purpose")
@@ -83,6 +151,10 @@ func tsbuilderFails() TimestampTracker {
 func TestTimestampTracker_FindLatest(t *testing.T) {
 	// For hash info
 	dde := setupEvents(document.NewDocument())
+	genesis_doc := document.NewDocument()
+	genesis_child := setupGenesis(&genesis_doc)
+	votes_doc := document.NewDocument()
+	unvoted, voted := setupVotes(&votes_doc)
 
 	scenarios := []trackerFindLatestScenario{
 		trackerFindLatestScenario{
@@ -127,10 +199,24 @@ func TestTimestampTracker_FindLatest(t *testing.T) {
 			Error:       "",
 			TipHash:     dde.Child.Hash(),
 		},
+		trackerFindLatestScenario{
+			Description: "Events that don't descend from genesis are ignored",
+			Builder:     tsbuilderGenesis,
+			Timestamps:  []string{genesis_child.Hash(), dde.Root.Hash(), dde.Child.Hash()},
+			Error:       "",
+			TipHash:     genesis_child.Hash(),
+		},
+		trackerFindLatestScenario{
+			Description: "Fork with the most votes wins",
+			Builder:     tsbuilderVotes,
+			Timestamps:  []string{unvoted.Hash(), voted.Hash()},
+			Error:       "",
+			TipHash:     voted.Hash(),
+		},
 	}
 	for i, scenario := range scenarios {
 		tracker := scenario.Builder()
-		tracker.Doc.Timestamps = scenario.Timestamps
+		tracker.Doc.Timestamps = append([]string{}, scenario.Timestamps...)
 
 		event, err := tracker.FindLatest()
 		var hash string
@@ -146,6 +232,8 @@ func TestTimestampTracker_FindLatest(t *testing.T) {
 		} else {
 			assert.NoError(t, err, scenario.Description)
 		}
+		assert.Equal(t, scenario.Timestamps, tracker.Doc.Timestamps,
+			"Timestamps are left alone: %s", scenario.Description)
 	}
 }
 
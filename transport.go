@@ -0,0 +1,120 @@
+package deje
+
+import (
+	"sync"
+	"time"
+)
+
+// How long transports wait on the network, for things like dialing,
+// handshakes and replies, unless told otherwise.
+const DefaultTransportTimeout = 10 * time.Second
+
+// A Transport moves JSON-compatible events between peers that share
+// a topic. Client talks to the network exclusively through this
+// interface, so the underlying protocol can be swapped out (for a
+// different WAMP library, an in-process loopback for tests, etc.)
+// without touching any of the higher-level code.
+type Transport interface {
+	// Connect to a URL. What the URL means is up to the implementation.
+	Connect(url string) error
+
+	// Publish an event to all other subscribers of a topic. Can be any
+	// JSON-compatible value. Implementations must not deliver the event
+	// back to the publisher.
+	Publish(topic string, event interface{}) error
+
+	// Call handler for every event published to topic by another peer.
+	Subscribe(topic string, handler TransportHandler) error
+
+	// Stop delivering events for a topic. Unsubscribing from a topic
+	// that isn't subscribed to is not an error.
+	Unsubscribe(topic string) error
+
+	// Shut down the connection. Events will no longer be delivered.
+	Close() error
+
+	// The session ID of the current connection, or "" if there isn't one.
+	SessionID() string
+
+	// Set a callback for when the connection is lost, other than by
+	// calling Close. It may be called from any goroutine.
+	SetDisconnectCallback(callback OnDisconnectCallback)
+}
+
+// Called by a Transport for each event received on a subscribed topic.
+type TransportHandler func(topic string, event interface{})
+
+// Called when a Transport loses its connection unexpectedly.
+type OnDisconnectCallback func(err error)
+
+type queuedEvent struct {
+	Topic string
+	Event interface{}
+}
+
+// An unbounded FIFO of received events, drained by a single goroutine.
+//
+// Transports that receive from several sources use this so that
+// handlers are never called concurrently, and never block the
+// goroutines doing network reads.
+type eventQueue struct {
+	mutex      sync.Mutex
+	cond       *sync.Cond
+	events     []queuedEvent
+	running    bool
+	generation int
+}
+
+func newEventQueue() *eventQueue {
+	q := &eventQueue{}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// Start draining the queue, passing each event to dispatch.
+func (q *eventQueue) Start(dispatch TransportHandler) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.running {
+		return
+	}
+	q.running = true
+	q.generation++
+	go q.run(dispatch, q.generation)
+}
+
+// Stop draining, and discard anything not yet delivered.
+func (q *eventQueue) Stop() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.running = false
+	q.events = nil
+	q.cond.Broadcast()
+}
+
+func (q *eventQueue) Push(topic string, event interface{}) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.running {
+		q.events = append(q.events, queuedEvent{topic, event})
+		q.cond.Signal()
+	}
+}
+
+func (q *eventQueue) run(dispatch TransportHandler, generation int) {
+	for {
+		q.mutex.Lock()
+		for q.running && q.generation == generation && len(q.events) == 0 {
+			q.cond.Wait()
+		}
+		if !q.running || q.generation != generation {
+			q.mutex.Unlock()
+			return
+		}
+		next := q.events[0]
+		q.events = q.events[1:]
+		q.mutex.Unlock()
+
+		dispatch(next.Topic, next.Event)
+	}
+}
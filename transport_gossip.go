@@ -0,0 +1,612 @@
+package deje
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many message IDs a GossipTransport remembers, to avoid
+// delivering or relaying the same message twice.
+const gossipSeenLimit = 4096
+
+// How many frames can wait to be sent to a peer. A peer that falls
+// this far behind is disconnected, rather than holding up the others.
+const gossipQueueLength = 256
+
+// A Transport in which peers dial each other directly over TCP,
+// without any central router.
+//
+// Every message is flooded through the overlay: each node relays
+// messages it has not seen before to all of its other peers, and
+// delivers them locally if it is subscribed to the message's topic.
+// Nodes also tell each other about the peers they know, so a handful
+// of seed addresses is enough for a network to knit itself together.
+type GossipTransport struct {
+	// Address to accept connections from other peers on, like
+	// ":9000". If empty, this node only makes outgoing connections.
+	ListenAddr string
+
+	// Addresses of peers to dial when connecting.
+	Seeds []string
+
+	// Stop dialing peers learned from other nodes past this many
+	// connections. Seeds are always dialed.
+	MaxPeers int
+
+	// How long to wait when dialing, and for peers to read or write a
+	// frame. Idle peers are pinged often enough to stay connected.
+	Timeout time.Duration
+
+	mutex    sync.Mutex
+	nodeId   string
+	listener net.Listener
+	peers    map[string]*gossipPeer
+	handlers map[string]TransportHandler
+	queue    *eventQueue
+	counter  uint64
+	seen     map[string]bool
+	seenList []string
+
+	handshakes int  // How many are underway
+	orphaned   bool // Lost the last peer while handshakes were underway
+
+	onDisconnect OnDisconnectCallback
+}
+
+// A single frame of the gossip wire protocol. Frames are sent as a
+// stream of JSON objects over each TCP connection.
+type gossipFrame struct {
+	Type  string          `json:"type"`
+	Node  string          `json:"node,omitempty"`
+	Conn  string          `json:"conn,omitempty"`
+	Port  string          `json:"port,omitempty"`
+	Peers []string        `json:"peers,omitempty"`
+	Id    string          `json:"id,omitempty"`
+	Topic string          `json:"topic,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+type gossipPeer struct {
+	Node   string
+	Addr   string // Dialable address, or "" if unknown
+	Dialer string // Node ID of whoever opened the connection
+	ConnId string // Chosen by the dialer, unique per connection
+
+	conn     net.Conn
+	encoder  *json.Encoder
+	timeout  time.Duration
+	outbox   chan gossipFrame
+	done     chan struct{}
+	finished chan struct{} // Closed once the writer has hung up
+	once     sync.Once
+}
+
+func newGossipPeer(conn net.Conn, encoder *json.Encoder, timeout time.Duration) *gossipPeer {
+	return &gossipPeer{
+		conn:     conn,
+		encoder:  encoder,
+		timeout:  timeout,
+		outbox:   make(chan gossipFrame, gossipQueueLength),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+}
+
+func (p *gossipPeer) connKey() string {
+	return p.Dialer + "/" + p.ConnId
+}
+
+// Queue a frame to be sent by the peer's writer. Never blocks: fails
+// if the peer is gone, or too far behind.
+func (p *gossipPeer) Send(frame gossipFrame) error {
+	select {
+	case <-p.done:
+		return errors.New("Gossip peer is disconnected")
+	default:
+	}
+	select {
+	case p.outbox <- frame:
+		return nil
+	default:
+		return errors.New("Gossip peer is too far behind")
+	}
+}
+
+// Send queued frames until the peer is closed, pinging it whenever
+// there's nothing to send for a while. Then send whatever is left, and
+// hang up.
+func (p *gossipPeer) write() {
+	defer close(p.finished)
+	defer p.conn.Close()
+	ping := time.NewTicker(p.timeout / 2)
+	defer ping.Stop()
+	for {
+		var frame gossipFrame
+		select {
+		case frame = <-p.outbox:
+		case <-ping.C:
+			frame = gossipFrame{Type: "ping"}
+		case <-p.done:
+			p.flush()
+			return
+		}
+		if err := p.send(frame); err != nil {
+			return
+		}
+	}
+}
+
+func (p *gossipPeer) flush() {
+	for {
+		select {
+		case frame := <-p.outbox:
+			if err := p.send(frame); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *gossipPeer) send(frame gossipFrame) error {
+	p.conn.SetWriteDeadline(time.Now().Add(p.timeout))
+	return p.encoder.Encode(frame)
+}
+
+// Stop accepting frames. The writer hangs up once it has sent the ones
+// already queued, so nothing is lost when a connection is replaced by
+// another to the same node.
+func (p *gossipPeer) close() {
+	p.once.Do(func() { close(p.done) })
+}
+
+func NewGossipTransport(listen_addr string, seeds []string) *GossipTransport {
+	return &GossipTransport{
+		ListenAddr: listen_addr,
+		Seeds:      seeds,
+		MaxPeers:   8,
+		Timeout:    DefaultTransportTimeout,
+		peers:      make(map[string]*gossipPeer),
+		handlers:   make(map[string]TransportHandler),
+		queue:      newEventQueue(),
+		seen:       make(map[string]bool),
+	}
+}
+
+// Start listening (if ListenAddr is set), and dial the seed peers. If
+// url is not empty, it is dialed as an additional seed. Addresses may
+// be given as "host:port" or "tcp://host:port".
+//
+// Failing to reach seeds is only an error when not listening, since
+// such a node would have no way to ever find a peer.
+//
+// Calling Connect again while already connected dials the seeds again,
+// which is how a node that lost all of its peers gets back into the
+// network. In that case, failing to reach any seed is an error unless
+// some other peer has connected to us in the meantime.
+func (gt *GossipTransport) Connect(url string) error {
+	gt.mutex.Lock()
+	running := gt.nodeId != ""
+	if !running {
+		gt.nodeId = newGossipNodeId()
+	}
+	gt.mutex.Unlock()
+	if !running {
+		gt.queue.Start(gt.dispatch)
+	}
+
+	if !running && gt.ListenAddr != "" {
+		listener, err := net.Listen("tcp", gt.ListenAddr)
+		if err != nil {
+			gt.Close()
+			return err
+		}
+		gt.mutex.Lock()
+		gt.listener = listener
+		gt.mutex.Unlock()
+		go gt.accept(listener)
+	}
+
+	seeds := gt.Seeds
+	if url != "" {
+		seeds = append([]string{url}, seeds...)
+	}
+	var dial_err error
+	var connected bool
+	for _, seed := range seeds {
+		if err := gt.dial(strings.TrimPrefix(seed, "tcp://")); err != nil {
+			dial_err = err
+		} else {
+			connected = true
+		}
+	}
+	if dial_err != nil && !connected {
+		if running && gt.NumPeers() == 0 {
+			return dial_err
+		}
+		if !running && gt.ListenAddr == "" {
+			gt.Close()
+			return dial_err
+		}
+	}
+	return nil
+}
+
+func (gt *GossipTransport) Publish(topic string, event interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	gt.mutex.Lock()
+	if gt.nodeId == "" {
+		gt.mutex.Unlock()
+		return errors.New("Gossip transport is not connected")
+	}
+	gt.counter++
+	id := gt.nodeId + "-" + strconv.FormatUint(gt.counter, 10)
+	gt.markSeen(id)
+	gt.mutex.Unlock()
+
+	gt.relay(nil, gossipFrame{
+		Type:  "publish",
+		Id:    id,
+		Topic: topic,
+		Data:  data,
+	})
+	return nil
+}
+
+func (gt *GossipTransport) Subscribe(topic string, handler TransportHandler) error {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	gt.handlers[topic] = handler
+	return nil
+}
+
+// Stop delivering events for a topic. Messages on the topic are still
+// relayed to other peers.
+func (gt *GossipTransport) Unsubscribe(topic string) error {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	delete(gt.handlers, topic)
+	return nil
+}
+
+// Stop listening, and disconnect from all peers. Frames already queued
+// for them are sent first, as long as that takes less than the timeout.
+func (gt *GossipTransport) Close() error {
+	gt.queue.Stop()
+
+	gt.mutex.Lock()
+	listener := gt.listener
+	peers := gt.peers
+	gt.listener = nil
+	gt.peers = make(map[string]*gossipPeer)
+	gt.nodeId = ""
+	gt.mutex.Unlock()
+
+	for _, peer := range peers {
+		peer.close()
+	}
+	timer := time.NewTimer(gt.Timeout)
+	defer timer.Stop()
+	var expired bool
+	for _, peer := range peers {
+		if !expired {
+			select {
+			case <-peer.finished:
+				continue
+			case <-timer.C:
+				expired = true
+			}
+		}
+		peer.conn.Close()
+	}
+	if listener != nil {
+		return listener.Close()
+	}
+	return nil
+}
+
+// The randomly generated ID of this node, or "" if not connected.
+func (gt *GossipTransport) SessionID() string {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	return gt.nodeId
+}
+
+func (gt *GossipTransport) SetDisconnectCallback(callback OnDisconnectCallback) {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	gt.onDisconnect = callback
+}
+
+// The address this node is accepting peers on, or nil if it is not
+// listening. Useful when ListenAddr asks for an arbitrary port.
+func (gt *GossipTransport) Addr() net.Addr {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	if gt.listener == nil {
+		return nil
+	}
+	return gt.listener.Addr()
+}
+
+// The number of peers this node is currently connected to.
+func (gt *GossipTransport) NumPeers() int {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	return len(gt.peers)
+}
+
+func (gt *GossipTransport) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go gt.handshake(conn, "", false)
+	}
+}
+
+func (gt *GossipTransport) dial(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, gt.Timeout)
+	if err != nil {
+		return err
+	}
+	go gt.handshake(conn, addr, true)
+	return nil
+}
+
+// Exchange hello frames, register the peer, and serve the connection.
+func (gt *GossipTransport) handshake(conn net.Conn, addr string, dialed bool) {
+	gt.mutex.Lock()
+	self := gt.nodeId
+	gt.handshakes++
+	gt.mutex.Unlock()
+
+	peer, decoder := gt.greet(conn, self, addr, dialed)
+	if !gt.addPeer(peer) {
+		conn.Close()
+		return
+	}
+
+	go peer.write()
+	peer.Send(gossipFrame{Type: "peers", Peers: gt.peerAddrs(peer)})
+	gt.serve(peer, decoder)
+}
+
+// Exchange hello frames. Returns a nil peer if that fails.
+func (gt *GossipTransport) greet(conn net.Conn, self, addr string, dialed bool) (*gossipPeer, *json.Decoder) {
+	conn.SetDeadline(time.Now().Add(gt.Timeout))
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+	hello := gossipFrame{Type: "hello", Node: self, Port: gt.listenPort()}
+	if dialed {
+		hello.Conn = newGossipNodeId()
+	}
+	if err := encoder.Encode(hello); err != nil {
+		return nil, nil
+	}
+	var reply gossipFrame
+	if err := decoder.Decode(&reply); err != nil || reply.Type != "hello" {
+		return nil, nil
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	peer := newGossipPeer(conn, encoder, gt.Timeout)
+	peer.Node = reply.Node
+	peer.Addr = addr
+	if dialed {
+		peer.Dialer = self
+		peer.ConnId = hello.Conn
+	} else {
+		peer.Dialer = reply.Node
+		peer.ConnId = reply.Conn
+		if reply.Port != "" {
+			host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+			if err == nil {
+				peer.Addr = net.JoinHostPort(host, reply.Port)
+			}
+		}
+	}
+	return peer, decoder
+}
+
+// Register a peer at the end of a handshake, or just end the
+// handshake if the peer is nil. If there's already a connection to the
+// same node (say, both sides dialed each other at once), both ends
+// keep the same connection, based on an ordering they can both compute.
+func (gt *GossipTransport) addPeer(peer *gossipPeer) bool {
+	gt.mutex.Lock()
+	gt.handshakes--
+	added := gt.canAdd(peer)
+	if added {
+		gt.peers[peer.Node] = peer
+	}
+	gt.checkLost()
+	return added
+}
+
+// Must be called with gt.mutex held.
+func (gt *GossipTransport) canAdd(peer *gossipPeer) bool {
+	if peer == nil || gt.nodeId == "" || peer.Node == "" || peer.Node == gt.nodeId {
+		return false
+	}
+	if existing, ok := gt.peers[peer.Node]; ok {
+		if existing.connKey() <= peer.connKey() {
+			return false
+		}
+		existing.close()
+	}
+	return true
+}
+
+func (gt *GossipTransport) removePeer(peer *gossipPeer) {
+	gt.mutex.Lock()
+	if gt.peers[peer.Node] != peer {
+		gt.mutex.Unlock()
+		return
+	}
+	delete(gt.peers, peer.Node)
+	gt.orphaned = len(gt.peers) == 0
+	gt.checkLost()
+}
+
+// Call the disconnect callback if the last peer has gone away, unless
+// that's because of Close. While handshakes are underway, wait for
+// them instead, since the other end may have just replaced its
+// connection to us with a new one.
+//
+// Must be called with gt.mutex held, which it releases.
+func (gt *GossipTransport) checkLost() {
+	if len(gt.peers) != 0 || gt.nodeId == "" {
+		gt.orphaned = false
+	}
+	lost := gt.orphaned && gt.handshakes == 0
+	if lost {
+		gt.orphaned = false
+	}
+	callback := gt.onDisconnect
+	gt.mutex.Unlock()
+
+	if lost && callback != nil {
+		callback(errors.New("Lost connection to all gossip peers"))
+	}
+}
+
+// Dialable addresses of all peers other than the given one.
+func (gt *GossipTransport) peerAddrs(except *gossipPeer) []string {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	addrs := make([]string, 0, len(gt.peers))
+	for _, peer := range gt.peers {
+		if peer != except && peer.Addr != "" {
+			addrs = append(addrs, peer.Addr)
+		}
+	}
+	return addrs
+}
+
+func (gt *GossipTransport) knowsAddr(addr string) bool {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	if gt.listener != nil && gt.listener.Addr().String() == addr {
+		return true
+	}
+	for _, peer := range gt.peers {
+		if peer.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle frames from a peer until it hangs up, or goes quiet for
+// longer than the timeout. Pings only keep the connection alive.
+func (gt *GossipTransport) serve(peer *gossipPeer, decoder *json.Decoder) {
+	defer gt.removePeer(peer)
+	defer peer.close()
+
+	for {
+		var frame gossipFrame
+		peer.conn.SetReadDeadline(time.Now().Add(peer.timeout))
+		if err := decoder.Decode(&frame); err != nil {
+			return
+		}
+		switch frame.Type {
+		case "publish":
+			gt.rcvPublish(peer, frame)
+		case "peers":
+			for _, addr := range frame.Peers {
+				if gt.NumPeers() >= gt.MaxPeers || gt.knowsAddr(addr) {
+					continue
+				}
+				gt.dial(addr)
+			}
+		}
+	}
+}
+
+func (gt *GossipTransport) rcvPublish(from *gossipPeer, frame gossipFrame) {
+	gt.mutex.Lock()
+	if frame.Id == "" || gt.seen[frame.Id] {
+		gt.mutex.Unlock()
+		return
+	}
+	gt.markSeen(frame.Id)
+	gt.mutex.Unlock()
+
+	gt.relay(from, frame)
+
+	var event interface{}
+	if err := json.Unmarshal(frame.Data, &event); err == nil {
+		gt.queue.Push(frame.Topic, event)
+	}
+}
+
+// Send a frame to every peer except the one it came from.
+func (gt *GossipTransport) relay(from *gossipPeer, frame gossipFrame) {
+	gt.mutex.Lock()
+	peers := make([]*gossipPeer, 0, len(gt.peers))
+	for _, peer := range gt.peers {
+		if peer != from {
+			peers = append(peers, peer)
+		}
+	}
+	gt.mutex.Unlock()
+
+	for _, peer := range peers {
+		if err := peer.Send(frame); err != nil {
+			peer.conn.Close()
+		}
+	}
+}
+
+func (gt *GossipTransport) dispatch(topic string, event interface{}) {
+	gt.mutex.Lock()
+	handler := gt.handlers[topic]
+	gt.mutex.Unlock()
+	if handler != nil {
+		handler(topic, event)
+	}
+}
+
+// Remember a message ID, forgetting the oldest ones past the limit.
+// Must be called with gt.mutex held.
+func (gt *GossipTransport) markSeen(id string) {
+	gt.seen[id] = true
+	gt.seenList = append(gt.seenList, id)
+	if len(gt.seenList) > gossipSeenLimit {
+		delete(gt.seen, gt.seenList[0])
+		gt.seenList = gt.seenList[1:]
+	}
+}
+
+func (gt *GossipTransport) listenPort() string {
+	addr := gt.Addr()
+	if addr == nil {
+		return ""
+	}
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+func newGossipNodeId() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
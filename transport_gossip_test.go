@@ -0,0 +1,516 @@
+package deje
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Poll until condition is true, or fail after a generous timeout.
+func waitFor(t *testing.T, description string, condition func() bool) {
+	deadline := time.Now().Add(20 * timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", description)
+		}
+		<-time.After(time.Millisecond)
+	}
+}
+
+// Sets up A <- B <- C, where arrows point from dialer to listener.
+func setupGossipLine(t *testing.T) []*GossipTransport {
+	a := NewGossipTransport("127.0.0.1:0", nil)
+	if err := a.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	b := NewGossipTransport("127.0.0.1:0", []string{a.Addr().String()})
+	if err := b.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	c := NewGossipTransport("", nil)
+	c.MaxPeers = 1 // Don't learn about A
+	if err := c.Connect("tcp://" + b.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "peers to connect", func() bool {
+		return a.NumPeers() == 1 && b.NumPeers() == 2 && c.NumPeers() == 1
+	})
+	return []*GossipTransport{a, b, c}
+}
+
+func closeAll(transports []*GossipTransport) {
+	for _, gt := range transports {
+		gt.Close()
+	}
+}
+
+func TestGossipTransport_Relay(t *testing.T) {
+	gts := setupGossipLine(t)
+	defer closeAll(gts)
+	topic := "deje://gossip/relay"
+
+	received := make([]chan interface{}, len(gts))
+	for i, gt := range gts {
+		ch := make(chan interface{}, 10)
+		received[i] = ch
+		gt.Subscribe(topic, func(topic string, event interface{}) {
+			ch <- event
+		})
+	}
+
+	sent := map[string]interface{}{"hello": "from C"}
+	if err := gts[2].Publish(topic, sent); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{0, 1} {
+		select {
+		case recvd := <-received[i]:
+			assert.Equal(t, sent, recvd)
+		case <-time.After(20 * timeout):
+			t.Fatalf("Node %d timed out", i)
+		}
+	}
+
+	// Nobody gets duplicates, and the publisher gets nothing
+	<-time.After(5 * time.Millisecond)
+	for i := range gts {
+		assert.Equal(t, 0, len(received[i]), "Extra events for node %d", i)
+	}
+}
+
+func TestGossipTransport_PeerExchange(t *testing.T) {
+	a := NewGossipTransport("127.0.0.1:0", nil)
+	b := NewGossipTransport("127.0.0.1:0", nil)
+	c := NewGossipTransport("127.0.0.1:0", nil)
+	defer closeAll([]*GossipTransport{a, b, c})
+
+	if err := a.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	b.Seeds = []string{a.Addr().String()}
+	if err := b.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "B to connect to A", func() bool { return a.NumPeers() == 1 })
+
+	// C only knows about B, but should end up connected to A too
+	c.Seeds = []string{b.Addr().String()}
+	if err := c.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "full mesh", func() bool {
+		return a.NumPeers() == 2 && b.NumPeers() == 2 && c.NumPeers() == 2
+	})
+}
+
+func TestGossipTransport_Connect_Fail(t *testing.T) {
+	gt := NewGossipTransport("", []string{"127.0.0.1:1"})
+	assert.Error(t, gt.Connect(""), "Cannot reach only seed, and not listening")
+	assert.Equal(t, "", gt.SessionID())
+
+	gt = NewGossipTransport("not an address", nil)
+	assert.Error(t, gt.Connect(""), "Cannot listen")
+	assert.Equal(t, "", gt.SessionID())
+
+	// Unreachable seeds are fine if we're listening
+	gt = NewGossipTransport("127.0.0.1:0", []string{"127.0.0.1:1"})
+	assert.NoError(t, gt.Connect(""))
+	assert.NoError(t, gt.Close())
+}
+
+func TestGossipTransport_Close(t *testing.T) {
+	gts := setupGossipLine(t)
+	defer closeAll(gts)
+
+	assert.NotEqual(t, "", gts[1].SessionID())
+	assert.NoError(t, gts[1].Close())
+	assert.Equal(t, "", gts[1].SessionID())
+	assert.Nil(t, gts[1].Addr())
+	if err := gts[1].Publish("topic", "data"); assert.Error(t, err) {
+		assert.Equal(t, "Gossip transport is not connected", err.Error())
+	}
+	waitFor(t, "peers to notice", func() bool {
+		return gts[0].NumPeers() == 0 && gts[2].NumPeers() == 0
+	})
+}
+
+// Whatever was published before Close still goes out.
+func TestGossipTransport_Close_Flush(t *testing.T) {
+	a := NewGossipTransport("127.0.0.1:0", nil)
+	if err := a.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	received := make(chan interface{}, gossipQueueLength)
+	a.Subscribe("topic", func(topic string, event interface{}) {
+		received <- event
+	})
+	b := NewGossipTransport("", []string{a.Addr().String()})
+	if err := b.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "peers to connect", func() bool {
+		return a.NumPeers() == 1 && b.NumPeers() == 1
+	})
+
+	count := gossipQueueLength / 2
+	for i := 0; i < count; i++ {
+		assert.NoError(t, b.Publish("topic", float64(i)))
+	}
+	assert.NoError(t, b.Close())
+	for i := 0; i < count; i++ {
+		select {
+		case event := <-received:
+			assert.Equal(t, float64(i), event)
+		case <-time.After(20 * timeout):
+			t.Fatalf("Timed out waiting for message %d", i)
+		}
+	}
+}
+
+// But not to a peer that won't read.
+func TestGossipTransport_Close_Stuck(t *testing.T) {
+	gt := NewGossipTransport("", nil)
+	gt.Timeout = timeout
+	local, remote := net.Pipe()
+	peer := newGossipPeer(local, json.NewEncoder(local), time.Hour)
+	gt.peers["stuck"] = peer
+	go peer.write()
+	assert.NoError(t, peer.Send(gossipFrame{Type: "ping"}))
+
+	start := time.Now()
+	assert.NoError(t, gt.Close())
+	assert.True(t, time.Since(start) < 20*timeout, "Close took too long")
+	select {
+	case <-peer.finished:
+	case <-time.After(20 * timeout):
+		t.Fatal("Writer is still going")
+	}
+	remote.Close()
+}
+
+func TestGossipTransport_Disconnect(t *testing.T) {
+	gts := setupGossipLine(t)
+	defer closeAll(gts)
+
+	lost := make(chan error, 1)
+	gts[2].SetDisconnectCallback(func(err error) {
+		lost <- err
+	})
+	b_addr := gts[1].Addr().String()
+	gts[1].Close()
+	select {
+	case err := <-lost:
+		assert.Equal(t, "Lost connection to all gossip peers", err.Error())
+	case <-time.After(timeout):
+		t.Fatal("Disconnect callback was not called")
+	}
+
+	// Reconnecting dials the seeds again, which fails while B is down
+	assert.Error(t, gts[2].Connect("tcp://"+b_addr))
+	assert.NoError(t, gts[2].Connect("tcp://"+gts[0].Addr().String()))
+	waitFor(t, "C to reach A", func() bool { return gts[2].NumPeers() == 1 })
+}
+
+func TestGossipTransport_Publish_BadEvent(t *testing.T) {
+	gts := setupGossipLine(t)
+	defer closeAll(gts)
+	if err := gts[0].Publish("topic", make(chan int)); err == nil {
+		t.Fatal("Should have failed, chan int cannot be serialized")
+	}
+}
+
+func TestGossipTransport_SimpleClients(t *testing.T) {
+	gts := setupGossipLine(t)
+	defer closeAll(gts)
+	topic := "deje://gossip/simple"
+
+	clients := make([]*SimpleClient, len(gts))
+	for i, gt := range gts {
+		clients[i] = NewSimpleClientWithTransport(topic, gt, nil)
+		if err := clients[i].Connect(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Let the initial timestamp requests settle
+	<-time.After(timeout)
+
+	event := clients[0].GetDoc().NewEvent("SET")
+	event.Arguments["path"] = []interface{}{"hello"}
+	event.Arguments["value"] = "world"
+	register(clients[0], &event)
+	if err := clients[0].Promote(event); err != nil {
+		t.Fatal(err)
+	}
+
+	<-time.After(timeout)
+	assert.Equal(t,
+		map[string]interface{}{"hello": "world"},
+		clients[2].Export(),
+	)
+}
+
+func TestGossipTransport_Unsubscribe(t *testing.T) {
+	gts := setupGossipLine(t)
+	defer closeAll(gts)
+	topic := "deje://gossip/unsubscribe"
+
+	received := make(chan interface{}, 10)
+	gts[0].Subscribe(topic, func(topic string, event interface{}) {
+		received <- event
+	})
+	assert.NoError(t, gts[0].Unsubscribe(topic))
+	assert.NoError(t, gts[0].Unsubscribe("never subscribed"))
+
+	// Still relayed to C, but not delivered to A
+	relayed := make(chan interface{}, 10)
+	gts[2].Subscribe(topic, func(topic string, event interface{}) {
+		relayed <- event
+	})
+	if err := gts[1].Publish(topic, "data"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-relayed:
+	case <-time.After(20 * timeout):
+		t.Fatal("C timed out")
+	}
+	<-time.After(5 * time.Millisecond)
+	assert.Equal(t, 0, len(received))
+}
+
+func TestGossipTransport_Timeouts(t *testing.T) {
+	// A "peer" that accepts connections, and never says anything
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	gt := NewGossipTransport("127.0.0.1:0", nil)
+	gt.Timeout = timeout
+	defer gt.Close()
+	if err := gt.Connect(listener.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	silent := <-accepted
+	defer silent.Close()
+
+	// Gives up on the handshake
+	assertHangsUp(t, silent)
+	assert.Equal(t, 0, gt.NumPeers())
+
+	// Real peers ping each other, so they stay connected while idle
+	other := NewGossipTransport("", []string{gt.Addr().String()})
+	other.Timeout = gt.Timeout
+	defer other.Close()
+	if err := other.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "peers to connect", func() bool { return gt.NumPeers() == 1 })
+	<-time.After(5 * gt.Timeout)
+	assert.Equal(t, 1, gt.NumPeers())
+	assert.Equal(t, 1, other.NumPeers())
+
+	// But a peer that says hello, then goes quiet, is dropped
+	conn, err := net.Dial("tcp", gt.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hello := gossipFrame{Type: "hello", Node: "quiet", Conn: "1"}
+	if err := json.NewEncoder(conn).Encode(hello); err != nil {
+		t.Fatal(err)
+	}
+	assertHangsUp(t, conn)
+	assert.Equal(t, 1, gt.NumPeers())
+}
+
+// Read from a connection until the other end closes it.
+func assertHangsUp(t *testing.T, conn net.Conn) {
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(20 * timeout))
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			assert.Equal(t, io.EOF, err)
+			return
+		}
+	}
+}
+
+func TestGossipPeer_Send(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	peer := newGossipPeer(local, json.NewEncoder(local), timeout)
+
+	// Nothing is reading, so the queue fills up
+	for i := 0; i < gossipQueueLength; i++ {
+		assert.NoError(t, peer.Send(gossipFrame{Type: "ping"}))
+	}
+	assert.EqualError(t, peer.Send(gossipFrame{Type: "ping"}),
+		"Gossip peer is too far behind")
+
+	// Once closed, the writer sends what's queued, then hangs up
+	peer.close()
+	peer.close()
+	assert.EqualError(t, peer.Send(gossipFrame{Type: "ping"}),
+		"Gossip peer is disconnected")
+	go peer.write()
+	decoder := json.NewDecoder(remote)
+	for i := 0; i < gossipQueueLength; i++ {
+		var frame gossipFrame
+		if assert.NoError(t, decoder.Decode(&frame)) {
+			assert.Equal(t, "ping", frame.Type)
+		}
+	}
+	assertHangsUp(t, remote)
+
+	// Or sooner, if it can't send
+	local, remote = net.Pipe()
+	peer = newGossipPeer(local, json.NewEncoder(local), timeout)
+	peer.Send(gossipFrame{Type: "ping"})
+	peer.Send(gossipFrame{Type: "ping"})
+	remote.Close()
+	peer.write()
+	peer.close()
+	peer.flush()
+	_, err := local.Write([]byte("{}"))
+	assert.Error(t, err)
+}
+
+func TestGossipTransport_Disconnect_Handshaking(t *testing.T) {
+	gts := setupGossipLine(t)
+	defer closeAll(gts)
+	lost := make(chan error, 1)
+	gts[2].SetDisconnectCallback(func(err error) {
+		lost <- err
+	})
+
+	// A handshake is underway when C loses its only peer, so C waits
+	// to see how it turns out
+	local, remote := net.Pipe()
+	handshaking := make(chan struct{})
+	go func() {
+		gts[2].handshake(local, "", false)
+		close(handshaking)
+	}()
+	var hello gossipFrame
+	if err := json.NewDecoder(remote).Decode(&hello); err != nil {
+		t.Fatal(err)
+	}
+	gts[1].Close()
+	waitFor(t, "C to notice", func() bool { return gts[2].NumPeers() == 0 })
+	select {
+	case <-lost:
+		t.Fatal("Disconnect callback called while handshaking")
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	remote.Close()
+	<-handshaking
+	select {
+	case err := <-lost:
+		assert.Equal(t, "Lost connection to all gossip peers", err.Error())
+	case <-time.After(timeout):
+		t.Fatal("Disconnect callback was not called")
+	}
+
+	// Handshakes that fail right away are fine too
+	local, remote = net.Pipe()
+	remote.Close()
+	gts[2].handshake(local, "", false)
+	assert.Equal(t, 0, gts[2].NumPeers())
+}
+
+func TestGossipTransport_AddPeer(t *testing.T) {
+	gt := NewGossipTransport("", nil)
+	gt.nodeId = "self"
+	gt.handshakes = 4
+	newPeer := func(dialer, conn_id string) *gossipPeer {
+		local, _ := net.Pipe()
+		peer := newGossipPeer(local, json.NewEncoder(local), timeout)
+		peer.Node = "other"
+		peer.Dialer = dialer
+		peer.ConnId = conn_id
+		return peer
+	}
+
+	first := newPeer("self", "2")
+	assert.True(t, gt.addPeer(first))
+	assert.False(t, gt.addPeer(newPeer("self", "3")), "Loses to existing")
+
+	second := newPeer("other", "1")
+	assert.True(t, gt.addPeer(second), "Beats existing")
+	assert.EqualError(t, first.Send(gossipFrame{Type: "ping"}),
+		"Gossip peer is disconnected")
+	assert.Equal(t, map[string]*gossipPeer{"other": second}, gt.peers)
+
+	self := newPeer("self", "4")
+	self.Node = "self"
+	assert.False(t, gt.addPeer(self))
+	assert.Equal(t, 0, gt.handshakes)
+}
+
+func TestGossipTransport_Relay_SlowPeer(t *testing.T) {
+	gt := NewGossipTransport("", nil)
+	local, remote := net.Pipe()
+	peer := newGossipPeer(local, json.NewEncoder(local), timeout)
+	peer.outbox = make(chan gossipFrame) // Always full
+	gt.peers["slow"] = peer
+
+	gt.relay(nil, gossipFrame{Type: "publish"})
+	assertHangsUp(t, remote)
+}
+
+func TestGossipTransport_Seen(t *testing.T) {
+	gt := NewGossipTransport("", nil)
+	for i := 0; i <= gossipSeenLimit; i++ {
+		gt.markSeen(strconv.Itoa(i))
+	}
+	assert.Len(t, gt.seen, gossipSeenLimit)
+	assert.False(t, gt.seen["0"])
+	assert.True(t, gt.seen["1"])
+
+	// Already seen, or no ID at all
+	gt.rcvPublish(nil, gossipFrame{Id: "1"})
+	gt.rcvPublish(nil, gossipFrame{})
+	assert.Len(t, gt.seen, gossipSeenLimit)
+}
+
+func TestGossipTransport_Addresses(t *testing.T) {
+	gt := NewGossipTransport("127.0.0.1:0", nil)
+	if err := gt.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, gt.knowsAddr(gt.Addr().String()))
+	assert.False(t, gt.knowsAddr("127.0.0.1:1"))
+	assert.NotEqual(t, "", gt.listenPort())
+	gt.Close()
+
+	// Not every kind of address has a port
+	path := filepath.Join(t.TempDir(), "socket")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	gt.listener = listener
+	assert.Equal(t, "", gt.listenPort())
+}
@@ -0,0 +1,164 @@
+package deje
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// An in-process message bus, standing in for a WAMP router.
+//
+// Every LoopbackTransport created from the same hub can talk to the
+// others, which makes it possible to test multi-peer scenarios without
+// any network setup at all.
+type LoopbackHub struct {
+	mutex      sync.Mutex
+	transports map[*LoopbackTransport]bool
+	sessions   int
+}
+
+func NewLoopbackHub() *LoopbackHub {
+	return &LoopbackHub{
+		transports: make(map[*LoopbackTransport]bool),
+	}
+}
+
+// Create a Transport attached to this hub. It must still be connected
+// before it can send or receive events.
+func (hub *LoopbackHub) NewTransport() *LoopbackTransport {
+	return &LoopbackTransport{
+		hub:      hub,
+		handlers: make(map[string]TransportHandler),
+		queue:    newEventQueue(),
+	}
+}
+
+// Hand a serialized event to every connected transport except the sender.
+func (hub *LoopbackHub) broadcast(sender *LoopbackTransport, topic string, data []byte) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	for lt := range hub.transports {
+		if lt == sender {
+			continue
+		}
+		var event interface{}
+		if err := json.Unmarshal(data, &event); err == nil {
+			lt.queue.Push(topic, event)
+		}
+	}
+}
+
+// A Transport that delivers events through a LoopbackHub.
+//
+// Events are round-tripped through JSON, so handlers see the same
+// kind of values they would get from a real network connection.
+// Each transport delivers its events on its own goroutine, in the
+// order they were published.
+type LoopbackTransport struct {
+	hub       *LoopbackHub
+	mutex     sync.Mutex
+	handlers  map[string]TransportHandler
+	queue     *eventQueue
+	sessionId string
+
+	onDisconnect OnDisconnectCallback
+}
+
+// Attach to the hub. The URL is ignored.
+func (lt *LoopbackTransport) Connect(url string) error {
+	if lt.SessionID() != "" {
+		return nil
+	}
+
+	lt.hub.mutex.Lock()
+	lt.hub.sessions++
+	session := "loopback-" + strconv.Itoa(lt.hub.sessions)
+	lt.hub.transports[lt] = true
+	lt.hub.mutex.Unlock()
+
+	lt.mutex.Lock()
+	lt.sessionId = session
+	lt.mutex.Unlock()
+
+	lt.queue.Start(lt.dispatch)
+	return nil
+}
+
+func (lt *LoopbackTransport) Publish(topic string, event interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	lt.hub.broadcast(lt, topic, data)
+	return nil
+}
+
+func (lt *LoopbackTransport) Subscribe(topic string, handler TransportHandler) error {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	lt.handlers[topic] = handler
+	return nil
+}
+
+func (lt *LoopbackTransport) Unsubscribe(topic string) error {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	delete(lt.handlers, topic)
+	return nil
+}
+
+// Detach from the hub. Undelivered events are discarded.
+func (lt *LoopbackTransport) Close() error {
+	lt.detach()
+	return nil
+}
+
+// Detach from the hub as if the connection had been lost, calling the
+// disconnect callback. Useful for testing reconnection logic.
+func (lt *LoopbackTransport) Drop() {
+	if !lt.detach() {
+		return
+	}
+	lt.mutex.Lock()
+	callback := lt.onDisconnect
+	lt.mutex.Unlock()
+	if callback != nil {
+		callback(errors.New("Loopback connection dropped"))
+	}
+}
+
+func (lt *LoopbackTransport) SetDisconnectCallback(callback OnDisconnectCallback) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	lt.onDisconnect = callback
+}
+
+// Returns whether the transport was attached.
+func (lt *LoopbackTransport) detach() bool {
+	lt.hub.mutex.Lock()
+	attached := lt.hub.transports[lt]
+	delete(lt.hub.transports, lt)
+	lt.hub.mutex.Unlock()
+
+	lt.queue.Stop()
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	lt.sessionId = ""
+	return attached
+}
+
+func (lt *LoopbackTransport) SessionID() string {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	return lt.sessionId
+}
+
+func (lt *LoopbackTransport) dispatch(topic string, event interface{}) {
+	lt.mutex.Lock()
+	handler := lt.handlers[topic]
+	lt.mutex.Unlock()
+	if handler != nil {
+		handler(topic, event)
+	}
+}
@@ -0,0 +1,201 @@
+package deje
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupLoopback(t *testing.T, num int) []*LoopbackTransport {
+	hub := NewLoopbackHub()
+	transports := make([]*LoopbackTransport, num)
+	for i := range transports {
+		transports[i] = hub.NewTransport()
+		if err := transports[i].Connect(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return transports
+}
+
+func TestLoopbackTransport_SessionID(t *testing.T) {
+	hub := NewLoopbackHub()
+	lt1 := hub.NewTransport()
+	lt2 := hub.NewTransport()
+	assert.Equal(t, "", lt1.SessionID(), "No session before Connect")
+
+	assert.NoError(t, lt1.Connect(""))
+	assert.NoError(t, lt2.Connect(""))
+	assert.Equal(t, "loopback-1", lt1.SessionID())
+	assert.Equal(t, "loopback-2", lt2.SessionID())
+
+	// Connecting again keeps the same session
+	assert.NoError(t, lt1.Connect(""))
+	assert.Equal(t, "loopback-1", lt1.SessionID())
+
+	assert.NoError(t, lt1.Close())
+	assert.Equal(t, "", lt1.SessionID(), "No session after Close")
+}
+
+func TestLoopbackTransport_PubSub(t *testing.T) {
+	lts := setupLoopback(t, 3)
+	topic := "deje://loopback/pubsub"
+
+	received := make([]chan interface{}, len(lts))
+	for i, lt := range lts {
+		ch := make(chan interface{}, 10)
+		received[i] = ch
+		lt.Subscribe(topic, func(topic string, event interface{}) {
+			ch <- event
+		})
+	}
+
+	sent := map[string]interface{}{
+		"foo":  "bar",
+		"fire": []interface{}{"ant", "place", "nation"},
+	}
+	if err := lts[0].Publish(topic, sent); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{1, 2} {
+		select {
+		case recvd := <-received[i]:
+			assert.Equal(t, sent, recvd)
+		case <-time.After(timeout):
+			t.Fatalf("Transport %d timed out", i)
+		}
+	}
+
+	// Publisher does not receive its own events
+	select {
+	case recvd := <-received[0]:
+		t.Fatalf("Publisher received its own event: %#v", recvd)
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	// Other topics are not delivered
+	if err := lts[0].Publish("deje://loopback/other", sent); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case recvd := <-received[1]:
+		t.Fatalf("Received event for unsubscribed topic: %#v", recvd)
+	case <-time.After(5 * time.Millisecond):
+	}
+}
+
+func TestLoopbackTransport_Ordering(t *testing.T) {
+	lts := setupLoopback(t, 2)
+	topic := "deje://loopback/ordering"
+
+	received := make(chan interface{}, 100)
+	lts[1].Subscribe(topic, func(topic string, event interface{}) {
+		received <- event
+	})
+	for i := 0; i < 100; i++ {
+		if err := lts[0].Publish(topic, float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case recvd := <-received:
+			assert.Equal(t, float64(i), recvd)
+		case <-time.After(timeout):
+			t.Fatalf("Timed out waiting for event %d", i)
+		}
+	}
+}
+
+func TestLoopbackTransport_Publish_BadEvent(t *testing.T) {
+	lts := setupLoopback(t, 1)
+	if err := lts[0].Publish("topic", make(chan int)); err == nil {
+		t.Fatal("Should have failed, chan int cannot be serialized")
+	}
+}
+
+func TestLoopbackTransport_Close(t *testing.T) {
+	lts := setupLoopback(t, 2)
+	topic := "deje://loopback/close"
+
+	received := make(chan interface{}, 10)
+	lts[1].Subscribe(topic, func(topic string, event interface{}) {
+		received <- event
+	})
+	assert.NoError(t, lts[1].Close())
+	assert.NoError(t, lts[0].Publish(topic, "anyone there?"))
+
+	select {
+	case recvd := <-received:
+		t.Fatalf("Closed transport received event: %#v", recvd)
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	// Already detached, so there's no connection to drop
+	dropped := false
+	lts[1].SetDisconnectCallback(func(error) { dropped = true })
+	lts[1].Drop()
+	assert.False(t, dropped)
+}
+
+func TestEventQueue_Start(t *testing.T) {
+	q := newEventQueue()
+	defer q.Stop()
+	received := make(chan interface{}, 10)
+	dispatch := func(topic string, event interface{}) {
+		received <- event
+	}
+
+	// Starting again is harmless, and doesn't deliver twice
+	q.Start(dispatch)
+	q.Start(dispatch)
+	q.Push("topic", "event")
+	assert.Equal(t, "event", <-received)
+	select {
+	case recvd := <-received:
+		t.Fatalf("Delivered twice: %#v", recvd)
+	case <-time.After(5 * time.Millisecond):
+	}
+}
+
+func TestLoopbackTransport_SimpleClients(t *testing.T) {
+	lts := setupLoopback(t, 2)
+	topic := "deje://loopback/simple"
+	sc1 := NewSimpleClientWithTransport(topic, lts[0], nil)
+	sc2 := NewSimpleClientWithTransport(topic, lts[1], nil)
+	for _, sc := range []*SimpleClient{sc1, sc2} {
+		if err := sc.Connect(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Let the initial timestamp requests settle
+	<-time.After(timeout)
+
+	tips := make(chan string, 10)
+	sc2.SetRetipCallback(func(ev *document.Event) {
+		if ev != nil {
+			tips <- ev.Hash()
+		}
+	})
+
+	event := sc1.GetDoc().NewEvent("SET")
+	event.Arguments["path"] = []interface{}{"hello"}
+	event.Arguments["value"] = "world"
+	register(sc1, &event)
+	if err := sc1.Promote(event); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case hash := <-tips:
+		assert.Equal(t, event.Hash(), hash)
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for peer to sync")
+	}
+	assert.Equal(t,
+		map[string]interface{}{"hello": "world"},
+		sc2.Export(),
+	)
+}
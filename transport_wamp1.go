@@ -0,0 +1,218 @@
+package deje
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// WAMP v1 message codes used for publish/subscribe.
+const (
+	wamp1Welcome     = 0
+	wamp1Subscribe   = 5
+	wamp1Unsubscribe = 6
+	wamp1Publish     = 7
+	wamp1Event       = 8
+)
+
+// A Transport that speaks WAMP v1 to a router, such as the turnpike
+// server in demo/router.
+//
+// This is what NewClient uses by default. It doesn't use turnpike's
+// client, which can't be handed a connection, or asked to close its
+// own. Instead, it dials the websocket itself, and speaks the (small)
+// publish/subscribe part of the protocol directly.
+type Wamp1Transport struct {
+	// How long to wait for the router, when connecting and sending.
+	Timeout time.Duration
+
+	conn         *websocket.Conn
+	mutex        sync.Mutex
+	writeMutex   sync.Mutex
+	sessionId    string
+	handlers     map[string]TransportHandler
+	onDisconnect OnDisconnectCallback
+}
+
+func NewWamp1Transport() *Wamp1Transport {
+	return &Wamp1Transport{
+		Timeout:  DefaultTransportTimeout,
+		handlers: make(map[string]TransportHandler),
+	}
+}
+
+// Connect to a WAMP v1 router, given a ws:// URL. Any previous
+// connection is closed first.
+//
+// This can also be used to reconnect after the connection was lost.
+// Subscriptions do not carry over, and must be made again.
+func (t *Wamp1Transport) Connect(url string) error {
+	t.Close()
+
+	config, err := websocket.NewConfig(url, "http://localhost/")
+	if err != nil {
+		return err
+	}
+	config.Protocol = []string{"wamp"}
+	config.Dialer = &net.Dialer{Timeout: t.Timeout}
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		// Worded like turnpike's, as it always has been
+		return fmt.Errorf("Error connecting to websocket server: %s", err)
+	}
+
+	// [WELCOME, sessionId, protocolVersion, serverIdent]
+	conn.SetDeadline(time.Now().Add(t.Timeout))
+	var welcome []interface{}
+	if err := websocket.JSON.Receive(conn, &welcome); err != nil {
+		conn.Close()
+		return err
+	}
+	var session string
+	code, ok := wampCode(welcome)
+	if ok && code == wamp1Welcome && len(welcome) >= 2 {
+		session, ok = welcome[1].(string)
+	} else {
+		ok = false
+	}
+	if !ok {
+		conn.Close()
+		return errors.New("Expected WELCOME from WAMP router")
+	}
+	conn.SetDeadline(time.Time{})
+
+	t.mutex.Lock()
+	t.conn = conn
+	t.sessionId = session
+	t.mutex.Unlock()
+	go t.receive(conn)
+	return nil
+}
+
+func (t *Wamp1Transport) Publish(topic string, event interface{}) error {
+	// Exclude ourselves, as the Transport interface requires
+	return t.send([]interface{}{wamp1Publish, topic, event, true})
+}
+
+// WAMP v1 routers don't confirm subscriptions, so this only fails if
+// the request can't be sent.
+func (t *Wamp1Transport) Subscribe(topic string, handler TransportHandler) error {
+	t.mutex.Lock()
+	t.handlers[topic] = handler
+	t.mutex.Unlock()
+	if err := t.send([]interface{}{wamp1Subscribe, topic}); err != nil {
+		t.mutex.Lock()
+		delete(t.handlers, topic)
+		t.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (t *Wamp1Transport) Unsubscribe(topic string) error {
+	t.mutex.Lock()
+	delete(t.handlers, topic)
+	t.mutex.Unlock()
+	return t.send([]interface{}{wamp1Unsubscribe, topic})
+}
+
+// Close the connection to the router.
+func (t *Wamp1Transport) Close() error {
+	t.mutex.Lock()
+	conn := t.conn
+	t.drop(conn)
+	t.mutex.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+func (t *Wamp1Transport) SessionID() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.sessionId
+}
+
+func (t *Wamp1Transport) SetDisconnectCallback(callback OnDisconnectCallback) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.onDisconnect = callback
+}
+
+func (t *Wamp1Transport) send(message []interface{}) error {
+	// Serialize first, so that bad events are caught even when not
+	// connected, and never leave half a message on the wire
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	conn := t.conn
+	t.mutex.Unlock()
+	if conn == nil {
+		return errors.New("Not connected to a WAMP router")
+	}
+
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(t.Timeout))
+	return websocket.Message.Send(conn, string(data))
+}
+
+// Runs until the connection closes, dispatching incoming events.
+func (t *Wamp1Transport) receive(conn *websocket.Conn) {
+	for {
+		var message []interface{}
+		if err := websocket.JSON.Receive(conn, &message); err != nil {
+			break
+		}
+		t.rcvEvent(message)
+	}
+
+	// Otherwise, Close was called
+	t.mutex.Lock()
+	lost := t.drop(conn)
+	callback := t.onDisconnect
+	t.mutex.Unlock()
+
+	if lost && callback != nil {
+		callback(errors.New("Connection to WAMP router lost"))
+	}
+}
+
+// Forget about a connection, if it's still the current one. Returns
+// whether it was.
+//
+// Must be called with t.mutex held.
+func (t *Wamp1Transport) drop(conn *websocket.Conn) bool {
+	if conn == nil || t.conn != conn {
+		return false
+	}
+	t.handlers = make(map[string]TransportHandler)
+	t.conn = nil
+	t.sessionId = ""
+	return true
+}
+
+// [EVENT, topicURI, event]
+func (t *Wamp1Transport) rcvEvent(message []interface{}) {
+	code, ok := wampCode(message)
+	if !ok || code != wamp1Event || len(message) < 3 {
+		return
+	}
+	topic, _ := message[1].(string)
+
+	t.mutex.Lock()
+	handler := t.handlers[topic]
+	t.mutex.Unlock()
+	if handler != nil {
+		handler(topic, message[2])
+	}
+}
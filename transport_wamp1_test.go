@@ -0,0 +1,244 @@
+package deje
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+// A WAMP v1 "router" that follows a script, to test how
+// Wamp1Transport copes with routers that misbehave.
+func setupWamp1Script(script func(conn *websocket.Conn)) (string, func()) {
+	return setupWampScript("wamp", script)
+}
+
+// Welcome the client to session "session-1".
+func wamp1ScriptWelcome(conn *websocket.Conn) {
+	websocket.JSON.Send(conn, []interface{}{wamp1Welcome, "session-1", 1, "script"})
+}
+
+func TestWamp1Transport_SessionID(t *testing.T) {
+	transport := NewWamp1Transport()
+	server_addr, server_closer := setupServer()
+	defer server_closer()
+
+	assert.Equal(t, "", transport.SessionID(), "No session before Connect")
+	if err := transport.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, "", transport.SessionID(), "Session set by router")
+
+	assert.NoError(t, transport.Close())
+	assert.Equal(t, "", transport.SessionID(), "No session after Close")
+}
+
+func TestWamp1Transport_PubSub(t *testing.T) {
+	server_addr, server_closer := setupServer()
+	defer server_closer()
+	topic := "http://example.com/deje/pubsub"
+
+	transports := []*Wamp1Transport{NewWamp1Transport(), NewWamp1Transport()}
+	received := make([]chan interface{}, len(transports))
+	for i, transport := range transports {
+		if err := transport.Connect(server_addr); err != nil {
+			t.Fatal(err)
+		}
+		defer transport.Close()
+		ch := make(chan interface{}, 10)
+		received[i] = ch
+		if err := transport.Subscribe(topic, func(_ string, event interface{}) {
+			ch <- event
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-time.After(5 * time.Millisecond) // Routers don't confirm subscriptions
+
+	sent := map[string]interface{}{"hello": "world"}
+	if err := transports[0].Publish(topic, sent); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-received[1]:
+		assert.Equal(t, sent, event)
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for event")
+	}
+
+	// Nothing for the publisher, or after unsubscribing
+	assert.NoError(t, transports[1].Unsubscribe(topic))
+	<-time.After(5 * time.Millisecond)
+	if err := transports[0].Publish(topic, sent); err != nil {
+		t.Fatal(err)
+	}
+	<-time.After(5 * time.Millisecond)
+	assert.Equal(t, 0, len(received[0]))
+	assert.Equal(t, 0, len(received[1]))
+
+	assert.Error(t, transports[0].Publish(topic, make(chan int)))
+}
+
+func TestWamp1Transport_Close(t *testing.T) {
+	hung_up := make(chan struct{})
+	server_addr, server_closer := setupWamp1Script(func(conn *websocket.Conn) {
+		wamp1ScriptWelcome(conn)
+		wamp2ScriptHold(conn)
+		close(hung_up)
+	})
+	defer server_closer()
+
+	transport := NewWamp1Transport()
+	lost := make(chan error, 1)
+	transport.SetDisconnectCallback(func(err error) {
+		lost <- err
+	})
+	if err := transport.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "session-1", transport.SessionID())
+
+	assert.NoError(t, transport.Close())
+	select {
+	case <-hung_up:
+	case <-time.After(timeout):
+		t.Fatal("Connection was not closed")
+	}
+	select {
+	case err := <-lost:
+		t.Fatalf("Closing reported as lost: %v", err)
+	case <-time.After(5 * time.Millisecond):
+	}
+	assert.NoError(t, transport.Close(), "Closing again is harmless")
+
+	// Nothing to talk to
+	for _, err := range []error{
+		transport.Publish("topic", "data"),
+		transport.Subscribe("topic", func(string, interface{}) {}),
+		transport.Unsubscribe("topic"),
+	} {
+		if assert.Error(t, err) {
+			assert.Equal(t, "Not connected to a WAMP router", err.Error())
+		}
+	}
+	assert.Empty(t, transport.handlers, "Failed subscriptions are forgotten")
+}
+
+func TestWamp1Transport_Connect_Again(t *testing.T) {
+	hung_up := make(chan struct{}, 2)
+	server_addr, server_closer := setupWamp1Script(func(conn *websocket.Conn) {
+		wamp1ScriptWelcome(conn)
+		wamp2ScriptHold(conn)
+		hung_up <- struct{}{}
+	})
+	defer server_closer()
+
+	transport := NewWamp1Transport()
+	lost := make(chan error, 1)
+	transport.SetDisconnectCallback(func(err error) {
+		lost <- err
+	})
+	for i := 0; i < 2; i++ {
+		if err := transport.Connect(server_addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-hung_up:
+	case <-time.After(timeout):
+		t.Fatal("First connection was not closed")
+	}
+	select {
+	case err := <-lost:
+		t.Fatalf("Replacing connection reported as lost: %v", err)
+	case <-time.After(5 * time.Millisecond):
+	}
+	assert.Equal(t, "session-1", transport.SessionID())
+	transport.Close()
+}
+
+func TestWamp1Transport_Connect_Errors(t *testing.T) {
+	tests := []struct {
+		Description string
+		Script      func(conn *websocket.Conn)
+		Error       string
+	}{
+		{"Hangs up", func(conn *websocket.Conn) {}, "EOF"},
+		{"Says something else", func(conn *websocket.Conn) {
+			websocket.JSON.Send(conn, []interface{}{wamp1Event, "topic", "data"})
+		}, "Expected WELCOME from WAMP router"},
+		{"Says something strange", func(conn *websocket.Conn) {
+			websocket.JSON.Send(conn, []interface{}{"welcome", "session-1"})
+		}, "Expected WELCOME from WAMP router"},
+		{"No session ID", func(conn *websocket.Conn) {
+			websocket.JSON.Send(conn, []interface{}{wamp1Welcome, 1})
+		}, "Expected WELCOME from WAMP router"},
+		{"Says nothing", func(conn *websocket.Conn) {
+			wamp2ScriptHold(conn)
+		}, "i/o timeout"},
+	}
+	for _, test := range tests {
+		server_addr, server_closer := setupWamp1Script(test.Script)
+		transport := NewWamp1Transport()
+		transport.Timeout = timeout
+		err := transport.Connect(server_addr)
+		if assert.Error(t, err, test.Description) {
+			assert.Contains(t, err.Error(), test.Error, test.Description)
+		}
+		assert.Equal(t, "", transport.SessionID(), test.Description)
+		server_closer()
+	}
+
+	// Nothing listening, or not even a URL
+	server_addr, server_closer := setupWamp1Script(wamp2ScriptHold)
+	server_closer()
+	assert.Error(t, NewWamp1Transport().Connect(server_addr))
+	assert.Error(t, NewWamp1Transport().Connect("::"))
+}
+
+func TestWamp1Transport_Receive(t *testing.T) {
+	server_addr, server_closer := setupWamp1Script(func(conn *websocket.Conn) {
+		wamp1ScriptWelcome(conn)
+		wamp2ScriptReceive(conn) // SUBSCRIBE
+		for _, message := range []interface{}{
+			[]interface{}{},
+			[]interface{}{"event"},
+			[]interface{}{wamp1Event, "topic"},
+			[]interface{}{wamp1Event, "other topic", "ignored"},
+			[]interface{}{wamp1Welcome, "session-2"},
+			[]interface{}{wamp1Event, "topic", "data"},
+		} {
+			websocket.JSON.Send(conn, message)
+		}
+		// Then hang up
+	})
+	defer server_closer()
+
+	transport := NewWamp1Transport()
+	lost := make(chan error, 1)
+	transport.SetDisconnectCallback(func(err error) {
+		lost <- err
+	})
+	if err := transport.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan interface{}, 10)
+	if err := transport.Subscribe("topic", func(topic string, event interface{}) {
+		assert.Equal(t, "topic", topic)
+		received <- event
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-lost:
+		assert.Equal(t, "Connection to WAMP router lost", err.Error())
+	case <-time.After(timeout):
+		t.Fatal("Disconnect callback was not called")
+	}
+	if assert.Equal(t, 1, len(received)) {
+		assert.Equal(t, "data", <-received)
+	}
+	assert.Equal(t, "", transport.SessionID())
+}
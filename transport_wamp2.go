@@ -0,0 +1,365 @@
+package deje
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// WAMP v2 message codes used by the Basic Profile publish/subscribe roles.
+const (
+	wamp2Hello        = 1
+	wamp2Welcome      = 2
+	wamp2Abort        = 3
+	wamp2Goodbye      = 6
+	wamp2Error        = 8
+	wamp2Publish      = 16
+	wamp2Subscribe    = 32
+	wamp2Subscribed   = 33
+	wamp2Unsubscribe  = 34
+	wamp2Unsubscribed = 35
+	wamp2Event        = 36
+)
+
+// A Transport that speaks WAMP v2 (JSON serialization) to a router,
+// joining a specific realm.
+//
+// Only the publisher and subscriber roles are implemented, since
+// that's all the DEJE protocol needs. Routers exclude the publisher
+// from receiving its own events by default in WAMP v2.
+type Wamp2Transport struct {
+	Realm string
+
+	// How long to wait for the router, when connecting, and for replies
+	// to requests.
+	Timeout time.Duration
+
+	conn       *websocket.Conn
+	mutex      sync.Mutex
+	writeMutex sync.Mutex
+	sessionId  string
+	requestId  int64
+	pending    map[int64]chan []interface{}
+	handlers   map[int64]TransportHandler
+	topics     map[string]int64 // Subscription ID for each topic
+
+	onDisconnect OnDisconnectCallback
+}
+
+func NewWamp2Transport(realm string) *Wamp2Transport {
+	return &Wamp2Transport{
+		Realm:    realm,
+		Timeout:  DefaultTransportTimeout,
+		pending:  make(map[int64]chan []interface{}),
+		handlers: make(map[int64]TransportHandler),
+		topics:   make(map[string]int64),
+	}
+}
+
+// Connect to a WAMP v2 router, given a ws:// or wss:// URL, and
+// join the transport's realm. Any previous connection is closed first.
+func (t *Wamp2Transport) Connect(url string) error {
+	t.Close()
+
+	config, err := websocket.NewConfig(url, "http://localhost/")
+	if err != nil {
+		return err
+	}
+	config.Protocol = []string{"wamp.2.json"}
+	config.Dialer = &net.Dialer{Timeout: t.Timeout}
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(t.Timeout))
+
+	hello := []interface{}{wamp2Hello, t.Realm, map[string]interface{}{
+		"roles": map[string]interface{}{
+			"publisher":  map[string]interface{}{},
+			"subscriber": map[string]interface{}{},
+		},
+	}}
+	var welcome []interface{}
+	err = websocket.JSON.Send(conn, hello)
+	if err == nil {
+		err = websocket.JSON.Receive(conn, &welcome)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	code, _ := wampCode(welcome)
+	switch {
+	case code == wamp2Welcome && len(welcome) >= 2:
+		session, _ := welcome[1].(float64)
+		conn.SetDeadline(time.Time{})
+		t.mutex.Lock()
+		t.conn = conn
+		t.sessionId = strconv.FormatInt(int64(session), 10)
+		t.mutex.Unlock()
+	case code == wamp2Abort && len(welcome) >= 3:
+		conn.Close()
+		return fmt.Errorf("WAMP router aborted session: %v", welcome[2])
+	default:
+		conn.Close()
+		return errors.New("Expected WELCOME from WAMP router")
+	}
+
+	go t.receive(conn)
+	return nil
+}
+
+func (t *Wamp2Transport) Publish(topic string, event interface{}) error {
+	// Serialize first, so that bad events are caught before sending
+	args, err := json.Marshal([]interface{}{event})
+	if err != nil {
+		return err
+	}
+	return t.send([]interface{}{
+		wamp2Publish, t.nextRequest(), map[string]interface{}{},
+		topic, json.RawMessage(args),
+	})
+}
+
+// Subscribe to a topic, and wait for the router to confirm it.
+func (t *Wamp2Transport) Subscribe(topic string, handler TransportHandler) error {
+	reply, err := t.request(
+		wamp2Subscribe, map[string]interface{}{}, topic,
+	)
+	if err != nil {
+		return err
+	}
+	if code, _ := wampCode(reply); code != wamp2Subscribed || len(reply) < 3 {
+		return wamp2ReplyError("subscribe", reply)
+	}
+	subscription, _ := reply[2].(float64)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.handlers[int64(subscription)] = func(_ string, event interface{}) {
+		handler(topic, event)
+	}
+	t.topics[topic] = int64(subscription)
+	return nil
+}
+
+// Unsubscribe from a topic, and wait for the router to confirm it.
+func (t *Wamp2Transport) Unsubscribe(topic string) error {
+	t.mutex.Lock()
+	subscription, ok := t.topics[topic]
+	t.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+
+	reply, err := t.request(wamp2Unsubscribe, subscription)
+	if err != nil {
+		return err
+	}
+	if code, _ := wampCode(reply); code != wamp2Unsubscribed {
+		return wamp2ReplyError("unsubscribe", reply)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.handlers, subscription)
+	delete(t.topics, topic)
+	return nil
+}
+
+// Leave the realm and close the connection.
+func (t *Wamp2Transport) Close() error {
+	t.mutex.Lock()
+	conn := t.conn
+	t.mutex.Unlock()
+	if conn == nil {
+		return nil
+	}
+
+	t.send([]interface{}{
+		wamp2Goodbye, map[string]interface{}{}, "wamp.close.normal",
+	})
+	t.mutex.Lock()
+	t.drop(conn)
+	t.mutex.Unlock()
+	return conn.Close()
+}
+
+func (t *Wamp2Transport) SessionID() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.sessionId
+}
+
+func (t *Wamp2Transport) SetDisconnectCallback(callback OnDisconnectCallback) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.onDisconnect = callback
+}
+
+func (t *Wamp2Transport) nextRequest() int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.requestId++
+	return t.requestId
+}
+
+func (t *Wamp2Transport) send(message []interface{}) error {
+	t.mutex.Lock()
+	conn := t.conn
+	t.mutex.Unlock()
+	if conn == nil {
+		return errors.New("Not connected to a WAMP router")
+	}
+
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(t.Timeout))
+	return websocket.JSON.Send(conn, message)
+}
+
+// Send a message that the router will reply to, and wait for the reply.
+func (t *Wamp2Transport) request(code int, args ...interface{}) ([]interface{}, error) {
+	id := t.nextRequest()
+	reply := make(chan []interface{}, 1)
+	t.mutex.Lock()
+	t.pending[id] = reply
+	t.mutex.Unlock()
+
+	message := append([]interface{}{code, id}, args...)
+	if err := t.send(message); err != nil {
+		t.mutex.Lock()
+		delete(t.pending, id)
+		t.mutex.Unlock()
+		return nil, err
+	}
+
+	select {
+	case message, ok := <-reply:
+		if !ok {
+			return nil, errors.New("Connection to WAMP router lost")
+		}
+		return message, nil
+	case <-time.After(t.Timeout):
+		t.mutex.Lock()
+		delete(t.pending, id)
+		t.mutex.Unlock()
+		return nil, errors.New("Timed out waiting for WAMP router")
+	}
+}
+
+// Runs until the connection closes, dispatching incoming messages.
+func (t *Wamp2Transport) receive(conn *websocket.Conn) {
+	for {
+		var message []interface{}
+		if err := websocket.JSON.Receive(conn, &message); err != nil {
+			break
+		}
+		code, ok := wampCode(message)
+		if !ok {
+			continue
+		}
+
+		switch code {
+		case wamp2Event:
+			t.rcvEvent(message)
+		case wamp2Subscribed, wamp2Unsubscribed, wamp2Error:
+			t.rcvReply(code, message)
+		case wamp2Goodbye:
+			t.send([]interface{}{
+				wamp2Goodbye, map[string]interface{}{}, "wamp.close.goodbye_and_out",
+			})
+			conn.Close()
+		}
+	}
+
+	// Otherwise, Close was called
+	t.mutex.Lock()
+	lost := t.drop(conn)
+	callback := t.onDisconnect
+	t.mutex.Unlock()
+
+	if lost && callback != nil {
+		callback(errors.New("Connection to WAMP router lost"))
+	}
+}
+
+// Forget about a connection, and wake up anything waiting on a reply,
+// if it's still the current connection. Returns whether it was.
+//
+// Must be called with t.mutex held.
+func (t *Wamp2Transport) drop(conn *websocket.Conn) bool {
+	if t.conn != conn {
+		return false
+	}
+	for id, reply := range t.pending {
+		close(reply)
+		delete(t.pending, id)
+	}
+	t.handlers = make(map[int64]TransportHandler)
+	t.topics = make(map[string]int64)
+	t.conn = nil
+	t.sessionId = ""
+	return true
+}
+
+// [EVENT, Subscription|id, Publication|id, Details|dict, Arguments|list]
+func (t *Wamp2Transport) rcvEvent(message []interface{}) {
+	if len(message) < 5 {
+		return
+	}
+	subscription, _ := message[1].(float64)
+	args, ok := message[4].([]interface{})
+	if !ok || len(args) < 1 {
+		return
+	}
+
+	t.mutex.Lock()
+	handler := t.handlers[int64(subscription)]
+	t.mutex.Unlock()
+	if handler != nil {
+		handler("", args[0])
+	}
+}
+
+// Replies carry the request ID at index 1, except for ERROR, which
+// carries the request type first.
+func (t *Wamp2Transport) rcvReply(code int, message []interface{}) {
+	index := 1
+	if code == wamp2Error {
+		index = 2
+	}
+	if len(message) <= index {
+		return
+	}
+	id, _ := message[index].(float64)
+
+	t.mutex.Lock()
+	reply, ok := t.pending[int64(id)]
+	delete(t.pending, int64(id))
+	t.mutex.Unlock()
+	if ok {
+		reply <- message
+	}
+}
+
+func wampCode(message []interface{}) (int, bool) {
+	if len(message) == 0 {
+		return 0, false
+	}
+	code, ok := message[0].(float64)
+	return int(code), ok
+}
+
+func wamp2ReplyError(action string, reply []interface{}) error {
+	if code, _ := wampCode(reply); code == wamp2Error && len(reply) >= 5 {
+		return fmt.Errorf("WAMP router refused to %s: %v", action, reply[4])
+	}
+	return fmt.Errorf("WAMP router refused to %s", action)
+}
@@ -0,0 +1,601 @@
+package deje
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+// Just enough of a WAMP v2 broker to test Wamp2Transport against.
+type wamp2TestRouter struct {
+	Realm string
+
+	mutex    sync.Mutex
+	lastId   float64
+	subs     map[string]map[*wamp2TestSession]float64
+	sessions map[*wamp2TestSession]bool
+}
+
+type wamp2TestSession struct {
+	conn  *websocket.Conn
+	mutex sync.Mutex
+}
+
+func (s *wamp2TestSession) Send(message ...interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	websocket.JSON.Send(s.conn, message)
+}
+
+func (r *wamp2TestRouter) newId() float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lastId++
+	return r.lastId
+}
+
+func (r *wamp2TestRouter) handle(conn *websocket.Conn) {
+	session := &wamp2TestSession{conn: conn}
+	r.mutex.Lock()
+	r.sessions[session] = true
+	r.mutex.Unlock()
+	defer func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		delete(r.sessions, session)
+		for _, subscribers := range r.subs {
+			delete(subscribers, session)
+		}
+	}()
+
+	for {
+		var message []interface{}
+		if err := websocket.JSON.Receive(conn, &message); err != nil {
+			return
+		}
+		switch message[0].(float64) {
+		case wamp2Hello:
+			if message[1] != r.Realm {
+				session.Send(wamp2Abort, map[string]interface{}{}, "wamp.error.no_such_realm")
+				return
+			}
+			session.Send(wamp2Welcome, r.newId(), map[string]interface{}{})
+		case wamp2Subscribe:
+			topic := message[3].(string)
+			if topic == "" {
+				session.Send(wamp2Error, wamp2Subscribe, message[1], map[string]interface{}{}, "wamp.error.invalid_uri")
+				continue
+			}
+			id := r.newId()
+			r.mutex.Lock()
+			if r.subs[topic] == nil {
+				r.subs[topic] = make(map[*wamp2TestSession]float64)
+			}
+			r.subs[topic][session] = id
+			r.mutex.Unlock()
+			session.Send(wamp2Subscribed, message[1], id)
+		case wamp2Unsubscribe:
+			var found bool
+			r.mutex.Lock()
+			for _, subscribers := range r.subs {
+				if id, ok := subscribers[session]; ok && id == message[2] {
+					delete(subscribers, session)
+					found = true
+				}
+			}
+			r.mutex.Unlock()
+			if found {
+				session.Send(wamp2Unsubscribed, message[1])
+			} else {
+				session.Send(wamp2Error, wamp2Unsubscribe, message[1], map[string]interface{}{}, "wamp.error.no_such_subscription")
+			}
+		case wamp2Publish:
+			publication := r.newId()
+			r.mutex.Lock()
+			for subscriber, id := range r.subs[message[3].(string)] {
+				if subscriber != session {
+					subscriber.Send(wamp2Event, id, publication, map[string]interface{}{}, message[4])
+				}
+			}
+			r.mutex.Unlock()
+		case wamp2Goodbye:
+			session.Send(wamp2Goodbye, map[string]interface{}{}, "wamp.close.goodbye_and_out")
+			return
+		}
+	}
+}
+
+// Drop every client connection, without saying GOODBYE.
+func (r *wamp2TestRouter) Kick() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for session := range r.sessions {
+		session.conn.Close()
+	}
+}
+
+func setupWamp2Server(realm string) (string, func()) {
+	_, server_addr, server_closer := setupWamp2Router(realm)
+	return server_addr, server_closer
+}
+
+func setupWamp2Router(realm string) (*wamp2TestRouter, string, func()) {
+	router := &wamp2TestRouter{
+		Realm:    realm,
+		subs:     make(map[string]map[*wamp2TestSession]float64),
+		sessions: make(map[*wamp2TestSession]bool),
+	}
+	server := httptest.NewServer(websocket.Server{
+		Handler: router.handle,
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			config.Protocol = []string{"wamp.2.json"}
+			return nil
+		},
+	})
+	server_addr := strings.Replace(server.URL, "http", "ws", 1)
+	return router, server_addr, func() {
+		server.CloseClientConnections()
+		server.Close()
+	}
+}
+
+func TestWamp2Transport_Connect(t *testing.T) {
+	server_addr, server_closer := setupWamp2Server("deje")
+	defer server_closer()
+
+	transport := NewWamp2Transport("deje")
+	assert.Equal(t, "", transport.SessionID(), "No session before Connect")
+	if err := transport.Connect("foo"); err == nil {
+		t.Fatal("foo is not a real server - should not 'succeed'")
+	}
+	if err := transport.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "1", transport.SessionID())
+
+	assert.NoError(t, transport.Close())
+	assert.Equal(t, "", transport.SessionID(), "No session after Close")
+	if err := transport.Publish("deje://foo/", "bar"); assert.Error(t, err) {
+		assert.Equal(t, "Not connected to a WAMP router", err.Error())
+	}
+}
+
+func TestWamp2Transport_Disconnect(t *testing.T) {
+	router, server_addr, server_closer := setupWamp2Router("deje")
+	defer server_closer()
+
+	transport := NewWamp2Transport("deje")
+	lost := make(chan error, 1)
+	transport.SetDisconnectCallback(func(err error) {
+		lost <- err
+	})
+	if err := transport.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+
+	router.Kick()
+	select {
+	case err := <-lost:
+		assert.Equal(t, "Connection to WAMP router lost", err.Error())
+	case <-time.After(timeout):
+		t.Fatal("Disconnect callback was not called")
+	}
+	assert.Equal(t, "", transport.SessionID())
+
+	// Reconnecting works, and Close is not reported as a lost connection
+	if err := transport.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, transport.Close())
+	select {
+	case err := <-lost:
+		t.Fatalf("Close reported as lost connection: %v", err)
+	case <-time.After(5 * time.Millisecond):
+	}
+}
+
+func TestWamp2Transport_Connect_BadRealm(t *testing.T) {
+	server_addr, server_closer := setupWamp2Server("deje")
+	defer server_closer()
+
+	transport := NewWamp2Transport("not-deje")
+	err := transport.Connect(server_addr)
+	if assert.Error(t, err) {
+		assert.Equal(t, "WAMP router aborted session: wamp.error.no_such_realm", err.Error())
+	}
+	assert.Equal(t, "", transport.SessionID())
+}
+
+func TestWamp2Transport_Subscribe_Refused(t *testing.T) {
+	server_addr, server_closer := setupWamp2Server("deje")
+	defer server_closer()
+
+	transport := NewWamp2Transport("deje")
+	if err := transport.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	err := transport.Subscribe("", func(string, interface{}) {})
+	if assert.Error(t, err) {
+		assert.Equal(t, "WAMP router refused to subscribe: wamp.error.invalid_uri", err.Error())
+	}
+}
+
+func TestWamp2Transport_PubSub(t *testing.T) {
+	server_addr, server_closer := setupWamp2Server("deje")
+	defer server_closer()
+	topic := "deje://example.com/some-doc"
+
+	transports := []*Wamp2Transport{
+		NewWamp2Transport("deje"),
+		NewWamp2Transport("deje"),
+	}
+	received := make([]chan interface{}, len(transports))
+	for i, transport := range transports {
+		ch := make(chan interface{}, 10)
+		received[i] = ch
+		if err := transport.Connect(server_addr); err != nil {
+			t.Fatal(err)
+		}
+		err := transport.Subscribe(topic, func(rcv_topic string, event interface{}) {
+			assert.Equal(t, topic, rcv_topic)
+			ch <- event
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sent := map[string]interface{}{
+		"foo":  "bar",
+		"fire": []interface{}{"ant", "place", "nation"},
+	}
+	if err := transports[1].Publish(topic, sent); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case recvd := <-received[0]:
+		assert.Equal(t, sent, recvd)
+	case <-time.After(timeout):
+		t.Fatal("Recv timed out")
+	}
+	select {
+	case recvd := <-received[1]:
+		t.Fatalf("Publisher received its own event: %#v", recvd)
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	// Attempt to publish something that cannot be serialized
+	if err := transports[0].Publish(topic, make(chan int)); err == nil {
+		t.Fatal("Should have failed, chan int cannot be serialized")
+	}
+}
+
+func TestWamp2Transport_Unsubscribe(t *testing.T) {
+	server_addr, server_closer := setupWamp2Server("deje")
+	defer server_closer()
+	topic := "deje://example.com/some-doc"
+
+	transports := []*Wamp2Transport{
+		NewWamp2Transport("deje"),
+		NewWamp2Transport("deje"),
+	}
+	received := make(chan interface{}, 10)
+	for _, transport := range transports {
+		if err := transport.Connect(server_addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := transports[0].Subscribe(topic, func(_ string, event interface{}) {
+		received <- event
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, transports[0].Unsubscribe(topic))
+	assert.NoError(t, transports[0].Unsubscribe(topic), "Already unsubscribed")
+	assert.NoError(t, transports[1].Publish(topic, "hello"))
+	select {
+	case recvd := <-received:
+		t.Fatalf("Received event after unsubscribing: %#v", recvd)
+	case <-time.After(5 * time.Millisecond):
+	}
+}
+
+func TestWamp2Transport_SimpleClients(t *testing.T) {
+	server_addr, server_closer := setupWamp2Server("deje")
+	defer server_closer()
+
+	url := strings.Replace(server_addr, "ws://", "deje://", 1) + "/some/topic?realm=deje"
+	sc1, err := Open(url, nil, nil)
+	if !assert.NoError(t, err, "Open should succeed for URL '%s'", url) {
+		t.FailNow()
+	}
+	sc2, err := Open(url, nil, nil)
+	if !assert.NoError(t, err, "Open should succeed for URL '%s'", url) {
+		t.FailNow()
+	}
+	assert.Equal(t,
+		strings.Replace(server_addr, "ws://", "deje://", 1)+"/some/topic",
+		sc1.GetTopic(),
+		"Realm is not part of the topic",
+	)
+	// Let the initial timestamp requests settle
+	<-time.After(timeout)
+
+	event := sc1.GetDoc().NewEvent("SET")
+	event.Arguments["path"] = []interface{}{"hello"}
+	event.Arguments["value"] = "world"
+	register(sc1, &event)
+	if err := sc1.Promote(event); err != nil {
+		t.Fatal(err)
+	}
+
+	<-time.After(timeout)
+	assert.Equal(t,
+		map[string]interface{}{"hello": "world"},
+		sc2.Export(),
+	)
+}
+
+// A WAMP v2 "router" that follows a script, to test how Wamp2Transport
+// copes with routers that misbehave.
+func setupWamp2Script(script func(conn *websocket.Conn)) (string, func()) {
+	return setupWampScript("wamp.2.json", script)
+}
+
+// Serve a websocket subprotocol, running script for each connection.
+func setupWampScript(protocol string, script func(conn *websocket.Conn)) (string, func()) {
+	server := httptest.NewServer(websocket.Server{
+		Handler: script,
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			config.Protocol = []string{protocol}
+			return nil
+		},
+	})
+	server_addr := strings.Replace(server.URL, "http", "ws", 1)
+	return server_addr, func() {
+		server.CloseClientConnections()
+		server.Close()
+	}
+}
+
+// Receive a message in a script, or nil if the connection is gone.
+func wamp2ScriptReceive(conn *websocket.Conn) []interface{} {
+	var message []interface{}
+	if err := websocket.JSON.Receive(conn, &message); err != nil {
+		return nil
+	}
+	return message
+}
+
+// Receive HELLO, and welcome the client to session 1.
+func wamp2ScriptWelcome(conn *websocket.Conn) {
+	wamp2ScriptReceive(conn)
+	websocket.JSON.Send(conn, []interface{}{wamp2Welcome, 1, map[string]interface{}{}})
+}
+
+// Wait for the client to hang up.
+func wamp2ScriptHold(conn *websocket.Conn) {
+	for wamp2ScriptReceive(conn) != nil {
+	}
+}
+
+func TestWamp2Transport_Connect_Errors(t *testing.T) {
+	tests := []struct {
+		Description string
+		Script      func(conn *websocket.Conn)
+		Error       string
+	}{
+		{"Hangs up", func(conn *websocket.Conn) {}, "EOF"},
+		{"Says something else", func(conn *websocket.Conn) {
+			wamp2ScriptReceive(conn)
+			websocket.JSON.Send(conn, []interface{}{wamp2Goodbye, map[string]interface{}{}, "bye"})
+		}, "Expected WELCOME from WAMP router"},
+		{"Says nothing", func(conn *websocket.Conn) {
+			wamp2ScriptHold(conn)
+		}, "i/o timeout"},
+	}
+	for _, test := range tests {
+		server_addr, server_closer := setupWamp2Script(test.Script)
+		transport := NewWamp2Transport("deje")
+		transport.Timeout = timeout
+		err := transport.Connect(server_addr)
+		if assert.Error(t, err, test.Description) {
+			assert.Contains(t, err.Error(), test.Error, test.Description)
+		}
+		assert.Equal(t, "", transport.SessionID(), test.Description)
+		server_closer()
+	}
+
+	// Nothing listening
+	server_addr, server_closer := setupWamp2Script(wamp2ScriptHold)
+	server_closer()
+	assert.Error(t, NewWamp2Transport("deje").Connect(server_addr))
+}
+
+func TestWamp2Transport_Connect_Again(t *testing.T) {
+	router, server_addr, server_closer := setupWamp2Router("deje")
+	defer server_closer()
+
+	transport := NewWamp2Transport("deje")
+	lost := make(chan error, 1)
+	transport.SetDisconnectCallback(func(err error) {
+		lost <- err
+	})
+	if err := transport.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Subscribe("deje://example.com/", func(string, interface{}) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "3", transport.SessionID())
+
+	// The first connection is closed properly, and forgotten
+	select {
+	case err := <-lost:
+		t.Fatalf("Replacing connection reported as lost: %v", err)
+	case <-time.After(5 * time.Millisecond):
+	}
+	router.mutex.Lock()
+	assert.Len(t, router.sessions, 1)
+	router.mutex.Unlock()
+	assert.NoError(t, transport.Unsubscribe("deje://example.com/"), "Not subscribed anymore")
+	assert.NoError(t, transport.Close())
+}
+
+func TestWamp2Transport_Request_Errors(t *testing.T) {
+	tests := []struct {
+		Description string
+		Script      func(conn *websocket.Conn)
+		Error       string
+	}{
+		{"Never replies", func(conn *websocket.Conn) {
+			wamp2ScriptWelcome(conn)
+			wamp2ScriptHold(conn)
+		}, "Timed out waiting for WAMP router"},
+		{"Hangs up", func(conn *websocket.Conn) {
+			wamp2ScriptWelcome(conn)
+			wamp2ScriptReceive(conn)
+		}, "Connection to WAMP router lost"},
+		{"Replies with nonsense", func(conn *websocket.Conn) {
+			wamp2ScriptWelcome(conn)
+			request := wamp2ScriptReceive(conn)
+			websocket.JSON.Send(conn, []interface{}{wamp2Unsubscribed, request[1]})
+			wamp2ScriptHold(conn)
+		}, "WAMP router refused to subscribe"},
+	}
+	for _, test := range tests {
+		server_addr, server_closer := setupWamp2Script(test.Script)
+		transport := NewWamp2Transport("deje")
+		transport.Timeout = timeout
+		if err := transport.Connect(server_addr); err != nil {
+			t.Fatal(err)
+		}
+		err := transport.Subscribe("deje://example.com/", func(string, interface{}) {})
+		if assert.Error(t, err, test.Description) {
+			assert.Equal(t, test.Error, err.Error(), test.Description)
+		}
+		transport.Close()
+		server_closer()
+	}
+
+	// Not connected at all
+	transport := NewWamp2Transport("deje")
+	err := transport.Subscribe("deje://example.com/", func(string, interface{}) {})
+	if assert.Error(t, err) {
+		assert.Equal(t, "Not connected to a WAMP router", err.Error())
+	}
+}
+
+func TestWamp2Transport_Unsubscribe_Errors(t *testing.T) {
+	// Confirms the subscription, then ignores the first unsubscribe,
+	// and refuses the second
+	server_addr, server_closer := setupWamp2Script(func(conn *websocket.Conn) {
+		wamp2ScriptWelcome(conn)
+		request := wamp2ScriptReceive(conn)
+		websocket.JSON.Send(conn, []interface{}{wamp2Subscribed, request[1], 7})
+		wamp2ScriptReceive(conn)
+		request = wamp2ScriptReceive(conn)
+		websocket.JSON.Send(conn, []interface{}{
+			wamp2Error, wamp2Unsubscribe, request[1], map[string]interface{}{},
+			"wamp.error.no_such_subscription",
+		})
+		wamp2ScriptHold(conn)
+	})
+	defer server_closer()
+
+	transport := NewWamp2Transport("deje")
+	transport.Timeout = timeout
+	if err := transport.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	if err := transport.Subscribe("deje://example.com/", func(string, interface{}) {}); err != nil {
+		t.Fatal(err)
+	}
+	err := transport.Unsubscribe("deje://example.com/")
+	if assert.Error(t, err) {
+		assert.Equal(t, "Timed out waiting for WAMP router", err.Error())
+	}
+	err = transport.Unsubscribe("deje://example.com/")
+	if assert.Error(t, err) {
+		assert.Equal(t, "WAMP router refused to unsubscribe: wamp.error.no_such_subscription", err.Error())
+	}
+}
+
+func TestWamp2Transport_Receive_Oddities(t *testing.T) {
+	subscribed := make(chan struct{})
+	goodbye := make(chan []interface{}, 1)
+	server_addr, server_closer := setupWamp2Script(func(conn *websocket.Conn) {
+		wamp2ScriptWelcome(conn)
+		request := wamp2ScriptReceive(conn)
+		websocket.JSON.Send(conn, []interface{}{wamp2Subscribed, request[1], 7})
+		<-subscribed
+
+		for _, message := range [][]interface{}{
+			{},
+			{"not a code"},
+			{wamp2Event, 7},
+			{wamp2Event, 7, 1, map[string]interface{}{}, "not a list"},
+			{wamp2Event, 7, 1, map[string]interface{}{}, []interface{}{}},
+			{wamp2Event, 8, 1, map[string]interface{}{}, []interface{}{"unsubscribed"}},
+			{wamp2Subscribed},
+			{wamp2Subscribed, 999, 8},
+			{wamp2Event, 7, 1, map[string]interface{}{}, []interface{}{"hello"}},
+			{wamp2Goodbye, map[string]interface{}{}, "wamp.close.system_shutdown"},
+		} {
+			websocket.JSON.Send(conn, message)
+		}
+		goodbye <- wamp2ScriptReceive(conn)
+		wamp2ScriptHold(conn)
+	})
+	defer server_closer()
+
+	transport := NewWamp2Transport("deje")
+	lost := make(chan error, 1)
+	transport.SetDisconnectCallback(func(err error) {
+		lost <- err
+	})
+	if err := transport.Connect(server_addr); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan interface{}, 10)
+	err := transport.Subscribe("deje://example.com/", func(_ string, event interface{}) {
+		received <- event
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(subscribed)
+
+	select {
+	case event := <-received:
+		assert.Equal(t, "hello", event, "Only the good event gets through")
+	case <-time.After(timeout):
+		t.Fatal("Recv timed out")
+	}
+	select {
+	case message := <-goodbye:
+		assert.Equal(t, []interface{}{
+			float64(wamp2Goodbye), map[string]interface{}{}, "wamp.close.goodbye_and_out",
+		}, message)
+	case <-time.After(timeout):
+		t.Fatal("Did not say GOODBYE back")
+	}
+	select {
+	case err := <-lost:
+		assert.Equal(t, "Connection to WAMP router lost", err.Error())
+	case <-time.After(timeout):
+		t.Fatal("Disconnect callback was not called")
+	}
+}
@@ -4,32 +4,189 @@ import (
 	"errors"
 	"net/url"
 	"strings"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/timestamps"
 )
 
-// Given a deje:// URL, return router URL and DEJE topic.
-func GetRouterAndTopic(deje_url string) (router, topic string, err error) {
-	var router_url, topic_url *url.URL
-	if !strings.HasPrefix(deje_url, "deje://") {
-		return "", "", errors.New("URL does not start with 'deje://': '" + deje_url + "'")
-	}
+// The router path used when a URL doesn't pick one.
+const DefaultRouterPath = "/ws"
+
+// The ways to pick a timestamp service (see DejeURL.Timestamps).
+const (
+	// Timestamps gathered from peers. The default.
+	PeerTimestamps = "peer"
+
+	// Every known event, in hash order. Only useful for testing.
+	SortedTimestamps = "sorted"
+)
+
+// A parsed deje:// or dejes:// URL, which says where to find a
+// document's router, which topic the document lives at, and how to
+// open it:
+//
+//	deje://host[:port]/path?router=/ws&realm=...&timestamps=peer#<event>
+//
+// dejes:// URLs are the same, except that the router is reached over
+// TLS (wss://). Query parameters other than the ones below are kept,
+// and are part of both the router URL and the topic.
+type DejeURL struct {
+	TLS  bool
+	Host string // Including the port, if any
+	Path string
+
+	// Where the router lives on Host. See DefaultRouterPath.
+	RouterPath string
+
+	// The WAMP v2 realm to join, or "" to use WAMP v1.
+	Realm string
+
+	// The hash of an event to pin the document to, instead of following
+	// the latest tip (see SimpleClient.Pin). May be abbreviated, like
+	// deje://host/path#1220ab. The at query parameter does the same
+	// thing, for when fragments get lost along the way.
+	At string
+
+	// Where timestamps come from. See PeerTimestamps.
+	Timestamps string
+
+	// Everything else in the query string.
+	Query url.Values
+}
 
-	router_url, err = url.Parse(deje_url)
+// Parse a deje:// or dejes:// URL.
+func ParseDejeURL(deje_url string) (*DejeURL, error) {
+	var u DejeURL
+	switch {
+	case strings.HasPrefix(deje_url, "deje://"):
+	case strings.HasPrefix(deje_url, "dejes://"):
+		u.TLS = true
+	default:
+		return nil, errors.New("URL does not start with 'deje://' or 'dejes://': '" + deje_url + "'")
+	}
+	parsed, err := url.Parse(deje_url)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	// Separate copy, each gets different manipulations
-	topic_url = new(url.URL)
-	*topic_url = *router_url
+	u.Host = parsed.Host
+	u.Path = parsed.Path
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Query = parsed.Query()
+	take := func(key, fallback string) string {
+		value := u.Query.Get(key)
+		u.Query.Del(key)
+		if value == "" {
+			return fallback
+		}
+		return value
+	}
+	u.RouterPath = take("router", DefaultRouterPath)
+	u.Realm = take("realm", "")
+	u.At = take("at", "")
+	u.Timestamps = take("timestamps", PeerTimestamps)
+	if parsed.Fragment != "" {
+		if u.At != "" && u.At != parsed.Fragment {
+			return nil, errors.New("URL is pinned to two events: '" + u.At + "' and '" + parsed.Fragment + "'")
+		}
+		u.At = parsed.Fragment
+	}
 
-	// Router URL manipulations
-	router_url.Scheme = "ws"
-	router_url.Path = "/ws"
+	if !strings.HasPrefix(u.RouterPath, "/") {
+		return nil, errors.New("Router path must start with '/': '" + u.RouterPath + "'")
+	}
+	if u.Timestamps != PeerTimestamps && u.Timestamps != SortedTimestamps {
+		return nil, errors.New("Unknown timestamp service: '" + u.Timestamps + "'")
+	}
+	return &u, nil
+}
 
-	// Topic URL manipulations
-	if topic_url.Path == "" {
-		topic_url.Path = "/"
+// The URL as a string, with every option that isn't a default.
+// Parsing it gives back the same DejeURL.
+func (u *DejeURL) String() string {
+	query := u.extraQuery()
+	set := func(key, value, fallback string) {
+		if value != fallback {
+			query.Set(key, value)
+		}
 	}
+	set("router", u.RouterPath, DefaultRouterPath)
+	set("realm", u.Realm, "")
+	set("timestamps", u.Timestamps, PeerTimestamps)
 
-	return router_url.String(), topic_url.String(), nil
+	scheme := "deje"
+	if u.TLS {
+		scheme = "dejes"
+	}
+	built := u.build(scheme, u.Path, query)
+	if u.At != "" {
+		built += "#" + u.At
+	}
+	return built
+}
+
+// The URL of the router, like ws://host/ws.
+func (u *DejeURL) Router() string {
+	scheme := "ws"
+	if u.TLS {
+		scheme = "wss"
+	}
+	return u.build(scheme, u.RouterPath, u.extraQuery())
+}
+
+// The topic the document lives at. This is always a deje:// URL, even
+// if the router is reached over TLS, so it names the same document
+// either way.
+func (u *DejeURL) Topic() string {
+	return u.build("deje", u.Path, u.extraQuery())
+}
+
+func (u *DejeURL) extraQuery() url.Values {
+	query := make(url.Values)
+	for key, values := range u.Query {
+		query[key] = append([]string{}, values...)
+	}
+	return query
+}
+
+func (u *DejeURL) build(scheme, path string, query url.Values) string {
+	built := url.URL{
+		Scheme:   scheme,
+		Host:     u.Host,
+		Path:     path,
+		RawQuery: query.Encode(),
+	}
+	return built.String()
+}
+
+// Create the timestamp service the URL picks, for a document.
+func (u *DejeURL) TimestampService(doc *document.Document) timestamps.TimestampService {
+	if u.Timestamps == SortedTimestamps {
+		return timestamps.NewSortingTimestampService(*doc)
+	}
+	return timestamps.NewPeerTimestampService(doc)
+}
+
+// Given a deje:// URL, return router URL and DEJE topic. See DejeURL.
+func GetRouterAndTopic(deje_url string) (router, topic string, err error) {
+	u, err := ParseDejeURL(deje_url)
+	if err != nil {
+		return "", "", err
+	}
+	return u.Router(), u.Topic(), nil
+}
+
+// Given a deje:// URL, return the WAMP v2 realm it selects, or "" if
+// it does not select one (meaning WAMP v1 should be used).
+//
+// Realms are selected with a query parameter, like
+// deje://example.com/some/doc?realm=example.
+func GetRealm(deje_url string) (string, error) {
+	u, err := ParseDejeURL(deje_url)
+	if err != nil {
+		return "", err
+	}
+	return u.Realm, nil
 }
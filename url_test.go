@@ -1,7 +1,13 @@
 package deje
 
-import "testing"
-import "github.com/stretchr/testify/assert"
+import (
+	"net/url"
+	"testing"
+
+	"github.com/DJDNS/go-deje/document"
+	"github.com/DJDNS/go-deje/timestamps"
+	"github.com/stretchr/testify/assert"
+)
 
 type UrlTest struct {
 	Input  string
@@ -20,6 +26,9 @@ func (ut UrlTest) Test(t *testing.T) {
 }
 
 func TestGetRouterAndTopic(t *testing.T) {
+	// The wording varies between Go versions
+	_, parse_err := url.Parse("deje://%")
+
 	tests := []UrlTest{
 		UrlTest{
 			Input:  "deje://foo/bar",
@@ -33,7 +42,7 @@ func TestGetRouterAndTopic(t *testing.T) {
 		},
 		UrlTest{
 			Input:  "foo.bar.baz",
-			Router: "<error>: URL does not start with 'deje://': 'foo.bar.baz'",
+			Router: "<error>: URL does not start with 'deje://' or 'dejes://': 'foo.bar.baz'",
 			Topic:  "",
 		},
 		UrlTest{
@@ -43,7 +52,7 @@ func TestGetRouterAndTopic(t *testing.T) {
 		},
 		UrlTest{
 			Input:  "//foo.bar.baz:8080",
-			Router: "<error>: URL does not start with 'deje://': '//foo.bar.baz:8080'",
+			Router: "<error>: URL does not start with 'deje://' or 'dejes://': '//foo.bar.baz:8080'",
 			Topic:  "",
 		},
 		UrlTest{
@@ -51,9 +60,29 @@ func TestGetRouterAndTopic(t *testing.T) {
 			Router: "ws://foo.bar.baz:8080/ws",
 			Topic:  "deje://foo.bar.baz:8080/",
 		},
+		UrlTest{
+			Input:  "deje://foo/bar?realm=baz",
+			Router: "ws://foo/ws",
+			Topic:  "deje://foo/bar",
+		},
+		UrlTest{
+			Input:  "deje://foo/bar?realm=baz&other=thing",
+			Router: "ws://foo/ws?other=thing",
+			Topic:  "deje://foo/bar?other=thing",
+		},
+		UrlTest{
+			Input:  "dejes://foo:8443/bar?router=/deje/ws&realm=baz&at=1220ab&timestamps=peer",
+			Router: "wss://foo:8443/deje/ws",
+			Topic:  "deje://foo:8443/bar",
+		},
+		UrlTest{
+			Input:  "deje://foo/bar?other=thing#1220ab",
+			Router: "ws://foo/ws?other=thing",
+			Topic:  "deje://foo/bar?other=thing",
+		},
 		UrlTest{
 			Input:  "deje://%",
-			Router: "<error>: parse deje://%: hexadecimal escape in host",
+			Router: "<error>: " + parse_err.Error(),
 			Topic:  "",
 		},
 	}
@@ -61,3 +90,114 @@ func TestGetRouterAndTopic(t *testing.T) {
 		ut.Test(t)
 	}
 }
+
+func TestGetRealm(t *testing.T) {
+	tests := []struct {
+		Input string
+		Realm string
+		Error string
+	}{
+		{"deje://foo/bar", "", ""},
+		{"deje://foo/bar?realm=baz", "baz", ""},
+		{"deje://foo/bar?other=thing&realm=baz", "baz", ""},
+		{"dejes://foo/bar?realm=baz", "baz", ""},
+		{"foo/bar?realm=baz", "", "URL does not start with 'deje://' or 'dejes://': 'foo/bar?realm=baz'"},
+	}
+	for _, test := range tests {
+		realm, err := GetRealm(test.Input)
+		assert.Equal(t, test.Realm, realm, test.Input)
+		if test.Error == "" {
+			assert.NoError(t, err, test.Input)
+		} else if assert.Error(t, err, test.Input) {
+			assert.Equal(t, test.Error, err.Error())
+		}
+	}
+}
+
+func TestParseDejeURL(t *testing.T) {
+	tests := []struct {
+		Input  string
+		URL    DejeURL
+		String string
+	}{
+		{
+			"deje://foo",
+			DejeURL{Host: "foo", Path: "/", RouterPath: "/ws", Timestamps: "peer",
+				Query: url.Values{}},
+			"deje://foo/",
+		},
+		{
+			"dejes://foo:8443/bar/baz?router=/deje/ws",
+			DejeURL{TLS: true, Host: "foo:8443", Path: "/bar/baz", RouterPath: "/deje/ws",
+				Timestamps: "peer", Query: url.Values{}},
+			"dejes://foo:8443/bar/baz?router=%2Fdeje%2Fws",
+		},
+		{
+			"deje://foo/bar?timestamps=sorted&other=thing&at=1220ab&realm=baz&router=/ws",
+			DejeURL{Host: "foo", Path: "/bar", RouterPath: "/ws", Realm: "baz",
+				At: "1220ab", Timestamps: "sorted", Query: url.Values{"other": {"thing"}}},
+			"deje://foo/bar?other=thing&realm=baz&timestamps=sorted#1220ab",
+		},
+		{
+			"deje://foo/bar#1220ab",
+			DejeURL{Host: "foo", Path: "/bar", RouterPath: "/ws", At: "1220ab",
+				Timestamps: "peer", Query: url.Values{}},
+			"deje://foo/bar#1220ab",
+		},
+		{
+			"deje://foo/bar?at=1220ab#1220ab",
+			DejeURL{Host: "foo", Path: "/bar", RouterPath: "/ws", At: "1220ab",
+				Timestamps: "peer", Query: url.Values{}},
+			"deje://foo/bar#1220ab",
+		},
+	}
+	for _, test := range tests {
+		u, err := ParseDejeURL(test.Input)
+		if !assert.NoError(t, err, test.Input) {
+			continue
+		}
+		assert.Equal(t, test.URL, *u, test.Input)
+		assert.Equal(t, test.String, u.String(), test.Input)
+
+		// Round trip
+		again, err := ParseDejeURL(u.String())
+		if assert.NoError(t, err, test.Input) {
+			assert.Equal(t, u, again, test.Input)
+		}
+	}
+}
+
+func TestParseDejeURL_Errors(t *testing.T) {
+	tests := []struct {
+		Input string
+		Error string
+	}{
+		{"http://foo/", "URL does not start with 'deje://' or 'dejes://': 'http://foo/'"},
+		{"deje://foo/?router=ws", "Router path must start with '/': 'ws'"},
+		{"deje://foo/?timestamps=bitcoin", "Unknown timestamp service: 'bitcoin'"},
+		{"deje://foo/?at=1220ab#1220cd", "URL is pinned to two events: '1220ab' and '1220cd'"},
+	}
+	for _, test := range tests {
+		_, err := ParseDejeURL(test.Input)
+		assert.EqualError(t, err, test.Error, test.Input)
+	}
+}
+
+func TestDejeURL_TimestampService(t *testing.T) {
+	doc := document.NewDocument()
+	for _, name := range []string{"b", "a"} {
+		ev := doc.NewEvent(name)
+		ev.Register()
+	}
+
+	u, err := ParseDejeURL("deje://foo/")
+	if assert.NoError(t, err) {
+		assert.Equal(t, timestamps.NewPeerTimestampService(&doc), u.TimestampService(&doc))
+	}
+	u, err = ParseDejeURL("deje://foo/?timestamps=sorted")
+	if assert.NoError(t, err) {
+		ts, err := u.TimestampService(&doc).GetTimestamps()
+		assert.NoError(t, err)
+		assert.Len(t, ts, 2)
+	}
+}
@@ -0,0 +1,183 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Serialize an object to canonical JSON, as specified by RFC 8785
+// (the JSON Canonicalization Scheme), so that any implementation can
+// produce exactly the same bytes for the same data:
+//
+//   - No whitespace.
+//   - Object keys sorted by their UTF-16 code units.
+//   - Strings escape only '"', '\' and control characters, using the
+//     short forms (\n, \t...) where they exist, and \u00xx otherwise.
+//     Everything else is written as-is, in UTF-8.
+//   - Numbers are IEEE 754 doubles, written the way ECMAScript's
+//     Number.prototype.toString does. NaN and Infinity are errors.
+//
+// The object is first converted to plain JSON values with
+// encoding/json, so struct tags and custom marshallers are respected.
+func CanonicalJSON(object interface{}) ([]byte, error) {
+	var value interface{}
+	if err := CloneMarshal(object, &value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err := writeCanonical(&buf, value)
+	return buf.Bytes(), err
+}
+
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case float64:
+		formatted, err := canonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(formatted)
+	case string:
+		writeCanonicalString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return lessUTF16(keys[i], keys[j])
+		})
+
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, key)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	}
+	return nil
+}
+
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[r>>4])
+				buf.WriteByte(hex[r&0xf])
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// Compare strings by UTF-16 code units, which differs from Go's
+// byte-wise comparison for characters outside the Basic Multilingual
+// Plane.
+func lessUTF16(a, b string) bool {
+	units_a := utf16.Encode([]rune(a))
+	units_b := utf16.Encode([]rune(b))
+	for i := 0; i < len(units_a) && i < len(units_b); i++ {
+		if units_a[i] != units_b[i] {
+			return units_a[i] < units_b[i]
+		}
+	}
+	return len(units_a) < len(units_b)
+}
+
+// Format a number like ECMAScript's Number.prototype.toString.
+func canonicalNumber(f float64) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", errors.New("Cannot serialize NaN or Infinity")
+	}
+	if f == 0 {
+		return "0", nil // Including -0
+	}
+
+	var sign string
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+
+	// Shortest digits that round-trip, as d.ddde±x
+	sci := strconv.FormatFloat(f, 'e', -1, 64)
+	e := strings.IndexByte(sci, 'e')
+	exponent, _ := strconv.Atoi(sci[e+1:])
+	digits := strings.Replace(sci[:e], ".", "", 1)
+	k := len(digits)
+	n := exponent + 1 // Value is 0.digits * 10^n
+
+	var out string
+	switch {
+	case k <= n && n <= 21:
+		out = digits + strings.Repeat("0", n-k)
+	case 0 < n && n <= 21:
+		out = digits[:n] + "." + digits[n:]
+	case -6 < n && n <= 0:
+		out = "0." + strings.Repeat("0", -n) + digits
+	default:
+		out = digits[:1]
+		if k > 1 {
+			out += "." + digits[1:]
+		}
+		exp_sign := "+"
+		if n-1 < 0 {
+			exp_sign = "-"
+		}
+		out += "e" + exp_sign + strconv.Itoa(abs(n-1))
+	}
+	return sign + out, nil
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
@@ -0,0 +1,120 @@
+package util
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		Description string
+		Input       interface{}
+		Expected    string
+	}{
+		{"Literals", []interface{}{nil, true, false}, `[null,true,false]`},
+		{"Struct fields are sorted", ircLocation{"example.com", 666, "mtv"},
+			`{"channel":"mtv","host":"example.com","port":666}`},
+		{"Nested", jsonObject{"b": []interface{}{jsonObject{"d": 1, "c": 2}}, "ab": 0, "a": jsonObject{}},
+			`{"a":{},"ab":0,"b":[{"c":2,"d":1}]}`},
+		// From RFC 8785, section 3.2.3
+		{"Sorted by UTF-16", jsonObject{
+			"\u20ac":     "Euro Sign",
+			"\r":         "Carriage Return",
+			"\ufb33":     "Hebrew Letter Dalet With Dagesh",
+			"1":          "One",
+			"\U0001f600": "Emoji: Grinning Face",
+			"\u0080":     "Control",
+			"\u00f6":     "Latin Small Letter O With Diaeresis",
+		}, "{" +
+			`"\r":"Carriage Return",` +
+			`"1":"One",` +
+			"\"\u0080\":\"Control\"," +
+			"\"\u00f6\":\"Latin Small Letter O With Diaeresis\"," +
+			"\"\u20ac\":\"Euro Sign\"," +
+			"\"\U0001f600\":\"Emoji: Grinning Face\"," +
+			"\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"" +
+			"}"},
+		// From RFC 8785, section 3.2.2.2
+		{"String escapes", "\u20ac$\u000f\nA'B\"\\\\\"/",
+			"\"\u20ac$\\u000f\\nA'B\\\"\\\\\\\\\\\"/\""},
+		{"No HTML escaping", "<&>\u2028", "\"<&>\u2028\""},
+		{"Short escapes", "\b\f\n\r\t\u001f", `"\b\f\n\r\t\u001f"`},
+	}
+	for _, test := range tests {
+		got, err := CanonicalJSON(test.Input)
+		if err != nil {
+			t.Fatalf("%s: %v", test.Description, err)
+		}
+		if string(got) != test.Expected {
+			t.Fatalf("%s:\nExpected %s\nGot      %s", test.Description, test.Expected, got)
+		}
+	}
+}
+
+func TestCanonicalJSON_Unmarshallable(t *testing.T) {
+	if _, err := CanonicalJSON(make(chan int)); err == nil {
+		t.Fatal("CanonicalJSON should have choked on unmarshallable object")
+	}
+}
+
+// From RFC 8785, appendix B, and ECMAScript's Number.prototype.toString
+func TestCanonicalNumber(t *testing.T) {
+	tests := []struct {
+		Input    float64
+		Expected string
+	}{
+		{0, "0"},
+		{math.Copysign(0, -1), "0"},
+		{5e-324, "5e-324"},
+		{-5e-324, "-5e-324"},
+		{1.7976931348623157e308, "1.7976931348623157e+308"},
+		{-1.7976931348623157e308, "-1.7976931348623157e+308"},
+		{9007199254740992, "9007199254740992"},
+		{-9007199254740992, "-9007199254740992"},
+		{295147905179352830000, "295147905179352830000"},
+		{9.999999999999997e22, "9.999999999999997e+22"},
+		{1e23, "1e+23"},
+		{1e21, "1e+21"},
+		{1e20, "100000000000000000000"},
+		{333333333.3333333, "333333333.3333333"},
+		{1.5, "1.5"},
+		{0.1, "0.1"},
+		{0.000001, "0.000001"},
+		{1e-7, "1e-7"},
+		{1.5e-7, "1.5e-7"},
+		{4.5, "4.5"},
+		{2e-3, "0.002"},
+		{666, "666"},
+	}
+	for _, test := range tests {
+		got, err := canonicalNumber(test.Input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.Expected {
+			t.Fatalf("Expected %s, got %s", test.Expected, got)
+		}
+	}
+
+	for _, bad := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := canonicalNumber(bad); err == nil {
+			t.Fatalf("Should not be able to serialize %v", bad)
+		}
+	}
+}
+
+// encoding/json already refuses NaN, so this can only happen to
+// values that didn't come through it.
+func TestWriteCanonical_BadNumbers(t *testing.T) {
+	for _, value := range []interface{}{
+		math.NaN(),
+		[]interface{}{math.NaN()},
+		map[string]interface{}{"x": math.NaN()},
+	} {
+		var buf bytes.Buffer
+		if err := writeCanonical(&buf, value); err == nil {
+			t.Fatalf("Should not be able to serialize %v", value)
+		}
+	}
+}
@@ -11,7 +11,7 @@ func TestCloneMarshal(t *testing.T) {
 	loc := new(ircLocation)
 	err := CloneMarshal(m, loc)
 	if err != nil {
-		t.Fatal("Error in CloneMarshal: %v", err)
+		t.Fatalf("Error in CloneMarshal: %v", err)
 	}
 
 	expected := ircLocation{
@@ -1,25 +1,11 @@
 package util
 
-import (
-	"crypto/sha1"
-	"encoding/hex"
-	"encoding/json"
-)
-
-// Serialize an object to compact, deterministic JSON
-// (no whitespace, fields in struct order or alphabetical),
-// then take the SHA1 of that, and return the hex digest.
+// Serialize an object to canonical JSON (see CanonicalJSON), then
+// hash that with the DefaultHashAlgorithm, and return the hex encoded
+// multihash (see HashAlgorithm).
 //
-// This is the algorithm we use for hashing events, topics, etc.
+// This is the algorithm we use for hashing events, topics, etc.,
+// unless a Document says otherwise.
 func HashObject(object interface{}) (string, error) {
-	serialized, err := json.Marshal(object)
-	if err != nil {
-		return "", err
-	}
-
-	hasher := sha1.New()
-	_, _ = hasher.Write(serialized)
-
-	sum := hasher.Sum(nil)
-	return hex.EncodeToString(sum[:]), nil
+	return HashObjectWith(DefaultHashAlgorithm, object)
 }
@@ -1,9 +1,6 @@
 package util
 
-import (
-	"encoding/json"
-	"testing"
-)
+import "testing"
 
 func TestHashObjectBasic(t *testing.T) {
 	obj := make(jsonObject)
@@ -11,7 +8,7 @@ func TestHashObjectBasic(t *testing.T) {
 	obj["z"] = []interface{}{8, 9, nil, true}
 
 	// For debugging
-	marshalled, err := json.Marshal(obj)
+	marshalled, err := CanonicalJSON(obj)
 	if err != nil {
 		t.Fatal(err)
 	} else {
@@ -19,8 +16,9 @@ func TestHashObjectBasic(t *testing.T) {
 	}
 
 	// Obtained with:
-	// echo -n '{"x":"y","z":[8,9,null,true]}' | sha1sum
-	expected := "b39d52797d2e72ddbe4f2b940a6700122d288a0c"
+	// echo -n '{"x":"y","z":[8,9,null,true]}' | sha256sum
+	// plus the multihash prefix for SHA-256
+	expected := "122079d53040491c841310c4e3ecbee7d65f38b986b82a348f4f855461613701a65b"
 
 	hash, err := HashObject(obj)
 	if err != nil {
@@ -40,7 +38,7 @@ func TestHashObjectEmpty(t *testing.T) {
 	}
 
 	// For debugging
-	marshalled, err := json.Marshal(loc)
+	marshalled, err := CanonicalJSON(loc)
 	if err != nil {
 		t.Fatal(err)
 	} else {
@@ -48,8 +46,9 @@ func TestHashObjectEmpty(t *testing.T) {
 	}
 
 	// Obtained with:
-	// echo -n '{"host":"","port":0,"channel":""}' | sha1sum
-	expected := "47f58ae5a60f9e23a2c90e02faf4040ac6b0a98b"
+	// echo -n '{"channel":"","host":"","port":0}' | sha256sum
+	// plus the multihash prefix for SHA-256
+	expected := "12207df4e7f5f7839aaa108319c070b1692634932860f98d27a414371ca8c3643d16"
 
 	if hash != expected {
 		t.Fatalf("Expected %v, got %v", expected, hash)
@@ -68,7 +67,7 @@ func TestHashObjectPopulated(t *testing.T) {
 	}
 
 	// For debugging
-	marshalled, err := json.Marshal(loc)
+	marshalled, err := CanonicalJSON(loc)
 	if err != nil {
 		t.Fatal(err)
 	} else {
@@ -76,8 +75,9 @@ func TestHashObjectPopulated(t *testing.T) {
 	}
 
 	// Obtained with:
-	// echo -n '{"host":"example.com","port":666,"channel":"mtv"}' | sha1sum
-	expected := "6f226d7455fbb88772b8e933009aa8e2bf7800df"
+	// echo -n '{"channel":"mtv","host":"example.com","port":666}' | sha256sum
+	// plus the multihash prefix for SHA-256
+	expected := "1220190b18f205bbba0f39f960752ff8d76b2da05b6bb2d590b62349dd544e9c3c7e"
 
 	if hash != expected {
 		t.Fatalf("Expected %v, got %v", expected, hash)
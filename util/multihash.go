@@ -0,0 +1,134 @@
+package util
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+)
+
+// A HashAlgorithm turns serialized objects into hashes.
+//
+// Apart from LegacySHA1, hashes describe themselves, multihash style:
+// they're the hex encoding of the algorithm's code, the digest length,
+// and then the digest, each length and code as an unsigned varint.
+// So SHA-256 hashes start with "1220", and SHA-1 hashes with "1114".
+//
+// See https://github.com/multiformats/multihash for the codes.
+type HashAlgorithm string
+
+const (
+	SHA1   HashAlgorithm = "sha1"
+	SHA256 HashAlgorithm = "sha2-256"
+	SHA512 HashAlgorithm = "sha2-512"
+
+	// Bare SHA-1 hex digests, as used before hashes described
+	// themselves. Any 40-digit hash is taken to be one of these.
+	// Objects are serialized the old way for these, too: plain
+	// json.Marshal output, with struct fields in declaration order.
+	LegacySHA1 HashAlgorithm = "legacy-sha1"
+
+	// What new Documents use, unless told otherwise.
+	DefaultHashAlgorithm = SHA256
+)
+
+type hashSpec struct {
+	Code uint64
+	New  func() hash.Hash
+}
+
+var hashSpecs = map[HashAlgorithm]hashSpec{
+	SHA1:       {0x11, sha1.New},
+	SHA256:     {0x12, sha256.New},
+	SHA512:     {0x13, sha512.New},
+	LegacySHA1: {0, sha1.New},
+}
+
+// Whether this is an algorithm we know how to use.
+func (alg HashAlgorithm) Known() bool {
+	_, ok := hashSpecs[alg]
+	return ok
+}
+
+// Hash some data, returning the hex encoded (multi)hash.
+func (alg HashAlgorithm) Sum(data []byte) (string, error) {
+	spec, ok := hashSpecs[alg]
+	if !ok {
+		return "", errors.New("Unknown hash algorithm: '" + string(alg) + "'")
+	}
+
+	hasher := spec.New()
+	_, _ = hasher.Write(data)
+	digest := hasher.Sum(nil)
+	if alg == LegacySHA1 {
+		return hex.EncodeToString(digest), nil
+	}
+
+	buf := make([]byte, 2*binary.MaxVarintLen64, 2*binary.MaxVarintLen64+len(digest))
+	n := binary.PutUvarint(buf, spec.Code)
+	n += binary.PutUvarint(buf[n:], uint64(len(digest)))
+	return hex.EncodeToString(append(buf[:n], digest...)), nil
+}
+
+// Work out which algorithm produced a hash, without checking what
+// it's a hash of.
+func ParseHash(hash string) (HashAlgorithm, error) {
+	raw, err := hex.DecodeString(hash)
+	if err != nil {
+		return "", errors.New("Hash is not hex")
+	}
+	if len(raw) == sha1.Size {
+		return LegacySHA1, nil
+	}
+
+	code, n := binary.Uvarint(raw)
+	if n <= 0 {
+		return "", errors.New("Hash is too short")
+	}
+	length, m := binary.Uvarint(raw[n:])
+	if m <= 0 {
+		return "", errors.New("Hash is too short")
+	}
+	for alg, spec := range hashSpecs {
+		if alg == LegacySHA1 || spec.Code != code {
+			continue
+		}
+		size := uint64(spec.New().Size())
+		if length != size || uint64(len(raw[n+m:])) != size {
+			return "", errors.New("Hash has wrong length")
+		}
+		return alg, nil
+	}
+	return "", errors.New("Unknown hash algorithm")
+}
+
+// Hash an object with a specific algorithm. See HashObject.
+func HashObjectWith(alg HashAlgorithm, object interface{}) (string, error) {
+	var serialized []byte
+	var err error
+	if alg == LegacySHA1 {
+		// Must match the hashes of existing Documents, byte for byte
+		serialized, err = json.Marshal(object)
+	} else {
+		serialized, err = CanonicalJSON(object)
+	}
+	if err != nil {
+		return "", err
+	}
+	return alg.Sum(serialized)
+}
+
+// Check whether a hash is the hash of an object, using whichever
+// algorithm the hash says it was made with.
+func VerifyHash(hash string, object interface{}) bool {
+	alg, err := ParseHash(hash)
+	if err != nil {
+		return false
+	}
+	expected, err := HashObjectWith(alg, object)
+	return err == nil && expected == hash
+}
@@ -0,0 +1,108 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+// Obtained with:
+// echo -n 'abc' | sha1sum (sha256sum, sha512sum)
+// plus the multihash prefixes
+var abcHashes = map[HashAlgorithm]string{
+	SHA1:       "1114a9993e364706816aba3e25717850c26c9cd0d89d",
+	SHA256:     "1220ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	SHA512:     "1340ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+	LegacySHA1: "a9993e364706816aba3e25717850c26c9cd0d89d",
+}
+
+func TestHashAlgorithm_Sum(t *testing.T) {
+	for alg, expected := range abcHashes {
+		got, err := alg.Sum([]byte("abc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Fatalf("%s: Expected %v, got %v", alg, expected, got)
+		}
+	}
+
+	_, err := HashAlgorithm("md5").Sum([]byte("abc"))
+	if err == nil || err.Error() != "Unknown hash algorithm: 'md5'" {
+		t.Fatalf("Expected unknown algorithm error, got %v", err)
+	}
+}
+
+func TestHashAlgorithm_Known(t *testing.T) {
+	for alg := range abcHashes {
+		if !alg.Known() {
+			t.Fatalf("%s should be known", alg)
+		}
+	}
+	for _, alg := range []HashAlgorithm{"", "md5"} {
+		if alg.Known() {
+			t.Fatalf("%q should not be known", alg)
+		}
+	}
+}
+
+func TestParseHash(t *testing.T) {
+	tests := []struct {
+		Hash      string
+		Algorithm HashAlgorithm
+		Error     string
+	}{
+		{abcHashes[SHA1], SHA1, ""},
+		{abcHashes[SHA256], SHA256, ""},
+		{abcHashes[SHA512], SHA512, ""},
+		{abcHashes[LegacySHA1], LegacySHA1, ""},
+		{"xyz", "", "Hash is not hex"},
+		{"", "", "Hash is too short"},
+		{"12", "", "Hash is too short"},
+		{"0120" + strings.Repeat("00", 32), "", "Unknown hash algorithm"},
+		{"1220" + strings.Repeat("00", 31), "", "Hash has wrong length"},
+		{"1210" + strings.Repeat("00", 16), "", "Hash has wrong length"},
+	}
+	for _, test := range tests {
+		alg, err := ParseHash(test.Hash)
+		if test.Error != "" {
+			if err == nil || err.Error() != test.Error {
+				t.Fatalf("%q: Expected error %q, got %v", test.Hash, test.Error, err)
+			}
+		} else if err != nil {
+			t.Fatalf("%q: %v", test.Hash, err)
+		}
+		if alg != test.Algorithm {
+			t.Fatalf("%q: Expected %q, got %q", test.Hash, test.Algorithm, alg)
+		}
+	}
+}
+
+func TestHashObjectWith_Unmarshallable(t *testing.T) {
+	_, err := HashObjectWith(SHA1, make(chan int))
+	if err == nil {
+		t.Fatal("HashObjectWith should have choked on unmarshallable object")
+	}
+}
+
+func TestVerifyHash(t *testing.T) {
+	obj := jsonObject{"x": "y"}
+	for alg := range abcHashes {
+		hash, err := HashObjectWith(alg, obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyHash(hash, obj) {
+			t.Fatalf("%s: Hash %v did not verify", alg, hash)
+		}
+		if VerifyHash(hash, jsonObject{"x": "z"}) {
+			t.Fatalf("%s: Hash %v verified for the wrong object", alg, hash)
+		}
+	}
+
+	if VerifyHash("xyz", obj) {
+		t.Fatal("Unparseable hash should not verify")
+	}
+	if VerifyHash(abcHashes[SHA256], make(chan int)) {
+		t.Fatal("Unmarshallable object should not verify")
+	}
+}